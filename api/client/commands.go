@@ -2611,6 +2611,26 @@ func (cli *DockerCli) CmdExec(args ...string) error {
 func (cli *DockerCli) CmdCheckpoint(args ...string) error {
 	cmd := cli.Subcmd("checkpoint", "CONTAINER", "Checkpoint a container", true)
 	stop := cmd.Bool([]string{"s", "-stop"}, false, "Stop a container after checkpointed")
+	snapshot := cmd.Bool([]string{"-snapshot"}, false, "Snapshot the rootfs instead of committing it, if the storage driver supports it")
+	flLabels := opts.NewListOpts(nil)
+	cmd.Var(&flLabels, []string{"l", "-label"}, "Set a key=value label on the checkpoint")
+	description := cmd.String([]string{"d", "-description"}, "", "Describe the checkpoint")
+	parent := cmd.String([]string{"p", "-parent"}, "", "Dump incrementally on top of the given checkpoint ID")
+	preDump := cmd.Bool([]string{"-pre-dump"}, false, "Only dump the memory pages, leaving the container running, for a checkpoint taken on top of it with --parent to freeze it shorter")
+	dedup := cmd.Bool([]string{"-dedup"}, false, "Store memory pages deduplicated with the other checkpoints of the container")
+	compress := cmd.Bool([]string{"-compress"}, false, "Compress memory pages, they are decompressed when restoring")
+	force := cmd.Bool([]string{"f", "-force"}, false, "Checkpoint even with exec sessions running, leaving their processes out of the checkpoint")
+	tcpEstablished := cmd.Bool([]string{"-tcp-established"}, false, "Checkpoint established TCP connections, to be restored with the container's own address")
+	shellJob := cmd.Bool([]string{"-shell-job"}, false, "Checkpoint processes started by a shell, e.g. with a tty, whose session is outside of the container")
+	fileLocks := cmd.Bool([]string{"-file-locks"}, true, "Checkpoint the file locks held by the processes, which are taken again on restore")
+	checkpointName := cmd.String([]string{"n", "-name"}, "", "Name the checkpoint, to restore it by that name instead of a generated ID")
+	id := cmd.String([]string{"-id"}, "", "Use the given checkpoint ID, as prepared by the daemon receiving the pages")
+	pageServer := cmd.String([]string{"-page-server"}, "", "Stream memory pages to the page server at HOST:PORT instead of storing them locally")
+	bandwidth := cmd.String([]string{"-page-server-bandwidth"}, "", "Cap the bandwidth used to stream memory pages to the page server, in bytes per second (format: <number><optional unit>, where unit = b, k, m or g)")
+	flSkipNs := opts.NewListOpts(nil)
+	cmd.Var(&flSkipNs, []string{"-skip-ns"}, "Leave the state of a namespace out of the checkpoint, it is restored empty (only net is supported)")
+	criuTimeout := cmd.Int([]string{"-criu-timeout"}, 0, "Number of seconds criu waits for the processes to be frozen before giving up, criu's default if 0")
+	restart := cmd.Bool([]string{"-restart"}, false, "Stop the container and restore it from the checkpoint right away, printing the restored container ID")
 
 	if err := cmd.Parse(args); err != nil {
 		return err
@@ -2626,25 +2646,166 @@ func (cli *DockerCli) CmdCheckpoint(args ...string) error {
 	if stop != nil && *stop {
 		v.Set("stop", "1")
 	}
+	if *snapshot {
+		v.Set("snapshot", "1")
+	}
+	for _, label := range flLabels.GetAll() {
+		v.Add("label", label)
+	}
+	if *description != "" {
+		v.Set("description", *description)
+	}
+	if *parent != "" {
+		v.Set("parent", *parent)
+	}
+	if *preDump {
+		if *stop {
+			return fmt.Errorf("Conflicting options: --pre-dump and --stop")
+		}
+		v.Set("predump", "1")
+	}
+	if *dedup {
+		v.Set("dedup", "1")
+	}
+	if *compress {
+		v.Set("compress", "1")
+	}
+	if *force {
+		v.Set("force", "1")
+	}
+	if *tcpEstablished {
+		v.Set("tcp_established", "1")
+	}
+	if *shellJob {
+		v.Set("shell_job", "1")
+	}
+	if !*fileLocks {
+		v.Set("file_locks", "0")
+	}
+	if *checkpointName != "" {
+		if *id != "" {
+			return fmt.Errorf("Conflicting options: --name and --id")
+		}
+		v.Set("name", *checkpointName)
+	}
+	if *id != "" {
+		v.Set("id", *id)
+	}
+	if *pageServer != "" {
+		v.Set("page_server", *pageServer)
+	}
+	if *bandwidth != "" {
+		bytesPerSec, err := units.RAMInBytes(*bandwidth)
+		if err != nil {
+			return err
+		}
+		v.Set("page_server_bandwidth", strconv.FormatInt(bytesPerSec, 10))
+	}
+	for _, ns := range flSkipNs.GetAll() {
+		v.Add("skip_ns", ns)
+	}
+	if *criuTimeout != 0 {
+		v.Set("criu_timeout", strconv.Itoa(*criuTimeout))
+	}
+
+	if *restart {
+		if *parent != "" || *preDump || *dedup || *compress || *id != "" || *pageServer != "" || *bandwidth != "" || *criuTimeout != 0 {
+			return fmt.Errorf("Conflicting options: --restart and --parent, --pre-dump, --dedup, --compress, --id, --page-server or --criu-timeout")
+		}
+		stream, _, err := cli.call("POST", fmt.Sprintf("/containers/%s/checkpoint-restart?%s", name, v.Encode()), nil, false)
+		if err != nil {
+			fmt.Fprintf(cli.err, "%s\n", err)
+			return fmt.Errorf("Error: failed to restart container named %s from a checkpoint", name)
+		}
+		var restartResult engine.Env
+		if err := restartResult.Decode(stream); err != nil {
+			return err
+		}
+		fmt.Fprintln(cli.out, restartResult.Get("Id"))
+		return nil
+	}
+
+	if cli.isTerminalOut {
+		// show how the dump proceeds, it may take a while
+		v.Set("progress", "1")
+		stream, _, err := cli.call("POST", fmt.Sprintf("/containers/%s/checkpoint?%s", name, v.Encode()), nil, false)
+		if err != nil {
+			fmt.Fprintf(cli.err, "%s\n", err)
+			return fmt.Errorf("Error: failed to checkpoint container named %s: %s", name, err)
+		}
+		defer stream.Close()
+		checkpointID, err := cli.displayCheckpointProgress(stream)
+		if err != nil {
+			return fmt.Errorf("Error: failed to checkpoint container named %s: %s", name, err)
+		}
+		fmt.Fprintln(cli.out, checkpointID)
+		return nil
+	}
 
-	_, _, err := readBody(cli.call("POST", fmt.Sprintf("/containers/%s/checkpoint?%s", name, v.Encode()), nil, false))
+	stream, _, err := cli.call("POST", fmt.Sprintf("/containers/%s/checkpoint?%s", name, v.Encode()), nil, false)
 	if err != nil {
 		fmt.Fprintf(cli.err, "%s\n", err)
 		return fmt.Errorf("Error: failed to checkpoint container named %s: %s", name, err)
 	}
-	fmt.Fprintf(cli.out, "%s\n", name)
+	var checkpointResult engine.Env
+	if err := checkpointResult.Decode(stream); err != nil {
+		return err
+	}
+	fmt.Fprintln(cli.out, checkpointResult.Get("Id"))
 	return nil
 }
 
+// displayCheckpointProgress shows the progress messages streamed while a
+// container is checkpointed on a single line, until the final result, and
+// returns the ID of the checkpoint.
+func (cli *DockerCli) displayCheckpointProgress(stream io.Reader) (string, error) {
+	dec := json.NewDecoder(stream)
+	for {
+		var msg struct {
+			Status string `json:"status"`
+			Error  string `json:"error"`
+			ID     string `json:"Id"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			if err == io.EOF {
+				return "", fmt.Errorf("no result received")
+			}
+			return "", err
+		}
+		switch {
+		case msg.Error != "":
+			fmt.Fprintf(cli.out, "\n")
+			return "", fmt.Errorf("%s", msg.Error)
+		case msg.Status != "":
+			fmt.Fprintf(cli.out, "%c[2K\r%s", 27, msg.Status)
+		default:
+			fmt.Fprintf(cli.out, "%c[2K\r", 27)
+			return msg.ID, nil
+		}
+	}
+}
+
 func (cli *DockerCli) CmdRestore(args ...string) error {
-	cmd := cli.Subcmd("restore", "CONTAINER CHECKPOINT_ID", "Restore a container", true)
+	cmd := cli.Subcmd("restore", "CONTAINER CHECKPOINT_ID [ARG...]", "Restore a container", true)
 	clone := cmd.Bool([]string{"c", "-clone"}, false, "Clone a container before restoring")
+	fork := cmd.Bool([]string{"-fork"}, false, "Restore into a new container sharing the volumes of the checkpointed one, leaving it untouched")
+	forkName := cmd.String([]string{"-name"}, "", "Name of the forked container")
+	cgroupParent := cmd.String([]string{"-cgroup-parent"}, "", "Cgroup parent to place the restored container under")
+	emptyNetNs := cmd.Bool([]string{"-empty-netns"}, false, "Restore without networking, into an empty network namespace")
+	natNetwork := cmd.Bool([]string{"-nat-network"}, false, "Restore behind the host NAT with its published ports forwarded, instead of attaching to the bridge")
+	skipMemoryCheck := cmd.Bool([]string{"-skip-memory-check"}, false, "Restore even if the host does not have enough memory available")
+	entrypoint := cmd.String([]string{"-entrypoint"}, "", "Run this command with the given ARGs in the restored container instead of resuming its processes")
+	force := cmd.Bool([]string{"f", "-force"}, false, "Stop the container first if it is running")
+	attached := cmd.Bool([]string{"-attached"}, false, "Keep criu as the parent of the restored processes instead of restoring them detached")
+	cloneStrategy := cmd.String([]string{"-clone-strategy"}, "", "How the checkpoint image files are cloned for the restored container: hardlink, copy or reflink, the daemon's default if empty")
+	verify := cmd.Bool([]string{"-verify"}, false, "Only check that the checkpoint can be restored, by restoring a throwaway clone without networking")
+	check := cmd.Bool([]string{"-check"}, false, "Only check the checkpoint image and have criu check it could restore it, without restoring anything")
 
 	if err := cmd.Parse(args); err != nil {
 		return err
 	}
 
-	if cmd.NArg() != 2 {
+	if cmd.NArg() < 2 || (cmd.NArg() > 2 && *entrypoint == "") {
 		cmd.Usage()
 		return nil
 	}
@@ -2653,9 +2814,101 @@ func (cli *DockerCli) CmdRestore(args ...string) error {
 	name := cmdArgs[0]
 	checkpointID := cmdArgs[1]
 	v := url.Values{}
+	if *check {
+		if *verify || *clone || *fork || *forkName != "" || *cgroupParent != "" || *emptyNetNs || *natNetwork || *skipMemoryCheck || *entrypoint != "" || *force || *attached || *cloneStrategy != "" {
+			return fmt.Errorf("Conflicting options: --check and any other option")
+		}
+		stream, _, err := cli.call("POST", fmt.Sprintf("/containers/%s/checkpoints/%s/check", name, checkpointID), nil, false)
+		if err != nil {
+			fmt.Fprintf(cli.err, "%s\n", err)
+			return fmt.Errorf("Error: failed to check checkpoint %s of container named %s", checkpointID, name)
+		}
+		var checkResult engine.Env
+		if err := checkResult.Decode(stream); err != nil {
+			return err
+		}
+		var checks []struct {
+			Name   string
+			Passed bool
+			Error  string
+		}
+		if err := checkResult.GetJson("Checks", &checks); err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
+		fmt.Fprintln(w, "CHECK\tRESULT\tERROR")
+		for _, c := range checks {
+			result := "ok"
+			if !c.Passed {
+				result = "failed"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", c.Name, result, c.Error)
+		}
+		w.Flush()
+		if !checkResult.GetBool("Restorable") {
+			return fmt.Errorf("Error: checkpoint %s of container named %s cannot be restored", checkpointID, name)
+		}
+		return nil
+	}
+	if *verify {
+		if *clone || *fork || *forkName != "" || *cgroupParent != "" || *emptyNetNs || *natNetwork || *entrypoint != "" || *force || *attached || *cloneStrategy != "" {
+			return fmt.Errorf("Conflicting options: --verify and options other than --skip-memory-check")
+		}
+		if *skipMemoryCheck {
+			v.Set("skip_memory_check", "1")
+		}
+		stream, _, err := cli.call("POST", fmt.Sprintf("/containers/%s/checkpoints/%s/verify?%s", name, checkpointID, v.Encode()), nil, false)
+		if err != nil {
+			fmt.Fprintf(cli.err, "%s\n", err)
+			return fmt.Errorf("Error: failed to verify checkpoint %s of container named %s", checkpointID, name)
+		}
+		var verifyResult engine.Env
+		if err := verifyResult.Decode(stream); err != nil {
+			return err
+		}
+		if !verifyResult.GetBool("Restorable") {
+			fmt.Fprintf(cli.err, "%s\n", verifyResult.Get("Error"))
+			return fmt.Errorf("Error: checkpoint %s of container named %s cannot be restored", checkpointID, name)
+		}
+		fmt.Fprintf(cli.out, "checkpoint %s is restorable (%dms)\n", checkpointID, verifyResult.GetInt64("DurationMs"))
+		return nil
+	}
 	if clone != nil && *clone {
 		v.Set("clone", "1")
 	}
+	if *fork {
+		v.Set("fork", "1")
+	}
+	if *forkName != "" {
+		v.Set("name", *forkName)
+	}
+	if *cgroupParent != "" {
+		v.Set("cgroup_parent", *cgroupParent)
+	}
+	if *emptyNetNs {
+		v.Set("empty_netns", "1")
+	}
+	if *natNetwork {
+		v.Set("nat_network", "1")
+	}
+	if *skipMemoryCheck {
+		v.Set("skip_memory_check", "1")
+	}
+	if *force {
+		v.Set("force", "1")
+	}
+	if *attached {
+		v.Set("attached", "1")
+	}
+	if *cloneStrategy != "" {
+		v.Set("clone_strategy", *cloneStrategy)
+	}
+	if *entrypoint != "" {
+		v.Add("entrypoint", *entrypoint)
+		for _, arg := range cmdArgs[2:] {
+			v.Add("entrypoint", arg)
+		}
+	}
 
 	path := fmt.Sprintf("/containers/%s/restore/%s?%s", name, checkpointID, v.Encode())
 	stream, _, err := cli.call("POST", path, nil, false)
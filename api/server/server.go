@@ -1210,6 +1210,153 @@ func postContainersCheckpoint(eng *engine.Engine, version version.Version, w htt
 		return err
 	}
 	job := eng.Job("checkpoint", vars["name"], r.Form.Get("stop"))
+	job.SetenvBool("snapshot", r.Form.Get("snapshot") == "1")
+	job.SetenvList("labels", r.Form["label"])
+	job.Setenv("description", r.Form.Get("description"))
+	job.Setenv("parent", r.Form.Get("parent"))
+	job.SetenvBool("predump", r.Form.Get("predump") == "1")
+	job.SetenvBool("dedup", r.Form.Get("dedup") == "1")
+	job.SetenvBool("compress", r.Form.Get("compress") == "1")
+	job.SetenvBool("force", r.Form.Get("force") == "1")
+	job.SetenvBool("tcpEstablished", r.Form.Get("tcp_established") == "1")
+	job.SetenvBool("shellJob", r.Form.Get("shell_job") == "1")
+	job.SetenvBool("fileLocks", r.Form.Get("file_locks") != "0")
+	job.Setenv("id", r.Form.Get("id"))
+	job.Setenv("name", r.Form.Get("name"))
+	job.Setenv("pageServer", r.Form.Get("page_server"))
+	job.Setenv("pageServerBandwidth", r.Form.Get("page_server_bandwidth"))
+	job.SetenvList("skipNamespaces", r.Form["skip_ns"])
+	job.SetenvBool("frozen", r.Form.Get("frozen") == "1")
+	job.Setenv("criuTimeout", r.Form.Get("criu_timeout"))
+
+	if r.Form.Get("progress") == "1" {
+		job.SetenvBool("progress", true)
+		streamJSON(job, w, true)
+		if err := job.Run(); err != nil {
+			if !job.Stdout.Used() {
+				return err
+			}
+			sf := utils.NewStreamFormatter(true)
+			w.Write(sf.FormatError(err))
+		}
+		return nil
+	}
+
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		return err
+	}
+	if err := job.Run(); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, *out)
+}
+
+func postContainersCheckpointsReceive(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	job := eng.Job("checkpoint_receive", vars["name"])
+	job.Setenv("port", r.Form.Get("port"))
+
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		return err
+	}
+	if err := job.Run(); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, *out)
+}
+
+func postContainersCheckpointRestart(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	job := eng.Job("checkpoint_restart", vars["name"])
+	job.SetenvBool("snapshot", r.Form.Get("snapshot") == "1")
+	job.SetenvList("labels", r.Form["label"])
+	job.Setenv("description", r.Form.Get("description"))
+	job.SetenvBool("tcpEstablished", r.Form.Get("tcp_established") == "1")
+	job.SetenvBool("shellJob", r.Form.Get("shell_job") == "1")
+	job.SetenvBool("fileLocks", r.Form.Get("file_locks") != "0")
+	job.Setenv("cgroupParent", r.Form.Get("cgroup_parent"))
+	job.SetenvBool("skipMemoryCheck", r.Form.Get("skip_memory_check") == "1")
+
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		return err
+	}
+	if err := job.Run(); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, *out)
+}
+
+func postContainersCheckpointVerify(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	job := eng.Job("checkpoint_verify", vars["name"], vars["checkpointID"])
+	job.SetenvBool("skipMemoryCheck", r.Form.Get("skip_memory_check") == "1")
+
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		return err
+	}
+	if err := job.Run(); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, *out)
+}
+
+func postContainersCheckpointCheck(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	job := eng.Job("checkpoint_check", vars["name"], vars["checkpointID"])
+
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		return err
+	}
+	if err := job.Run(); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, *out)
+}
+
+func postContainersCheckpointRename(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+
+	job := eng.Job("checkpoint_rename", vars["name"], vars["checkpointID"], r.Form.Get("name"))
+	if err := job.Run(); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func deleteContainersCheckpoint(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+
+	job := eng.Job("checkpoint_delete", vars["name"], vars["checkpointID"])
 	if err := job.Run(); err != nil {
 		return err
 	}
@@ -1217,6 +1364,19 @@ func postContainersCheckpoint(eng *engine.Engine, version version.Version, w htt
 	return nil
 }
 
+func getContainersCheckpoints(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	job := eng.Job("checkpoint_list", vars["name"])
+	job.Setenv("filters", r.Form.Get("filters"))
+	streamJSON(job, w, false)
+	return job.Run()
+}
+
 func postContainersRestore(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
@@ -1227,25 +1387,70 @@ func postContainersRestore(eng *engine.Engine, version version.Version, w http.R
 
 	job := eng.Job("restore", vars["name"], vars["checkpointID"])
 	job.SetenvBool("clone", r.Form.Get("clone") == "1")
+	job.SetenvBool("fork", r.Form.Get("fork") == "1")
+	job.Setenv("name", r.Form.Get("name"))
+	job.Setenv("cgroupParent", r.Form.Get("cgroup_parent"))
+	job.SetenvBool("emptyNetNs", r.Form.Get("empty_netns") == "1")
+	job.SetenvBool("natNetwork", r.Form.Get("nat_network") == "1")
+	job.SetenvList("entrypoint", r.Form["entrypoint"])
+	job.SetenvBool("force", r.Form.Get("force") == "1")
+	job.SetenvBool("attached", r.Form.Get("attached") == "1")
+	job.Setenv("cloneStrategy", r.Form.Get("clone_strategy"))
+	job.SetenvBool("skipMemoryCheck", r.Form.Get("skip_memory_check") == "1")
+
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		return err
+	}
+	if err := job.Run(); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, *out)
+}
 
-	stdoutBuffer := bytes.NewBuffer(nil)
-	job.Stdout.Add(stdoutBuffer)
+func postContainersLoad(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
 
+	job := eng.Job("container_load", r.Form.Get("id"), r.Form.Get("new_image_id"))
+	job.SetenvList("volumeMap", r.Form["volume_map"])
 	if err := job.Run(); err != nil {
 		return err
 	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
 
-	var out engine.Env
-	out.Set("Id", engine.Tail(stdoutBuffer, 1))
-	return writeJSON(w, http.StatusOK, out)
+func postContainersMigrate(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := parseForm(r); err != nil {
+		return err
+	}
+
+	job := eng.Job("container_migrate", vars["name"], r.Form.Get("remote"))
+	if port := r.Form.Get("page_server_port"); port != "" {
+		job.Setenv("pageServerPort", port)
+	}
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		return err
+	}
+	if err := job.Run(); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, *out)
 }
 
-func postContainersLoad(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+func postContainersReceive(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := parseForm(r); err != nil {
 		return err
 	}
 
-	job := eng.Job("container_load", r.Form.Get("id"), r.Form.Get("new_image_id"))
+	job := eng.Job("container_receive", r.Form.Get("id"))
+	job.Stdin.Add(r.Body)
 	if err := job.Run(); err != nil {
 		return err
 	}
@@ -1356,6 +1561,7 @@ func createRouter(eng *engine.Engine, logging, enableCors bool, dockerVersion st
 			"/containers/{name:.*}/top":       getContainersTop,
 			"/containers/{name:.*}/logs":      getContainersLogs,
 			"/containers/{name:.*}/attach/ws": wsContainersAttach,
+			"/containers/{name:.*}/checkpoints": getContainersCheckpoints,
 			"/exec/{id:.*}/json":              getExecByID,
 		},
 		"POST": {
@@ -1382,12 +1588,20 @@ func createRouter(eng *engine.Engine, logging, enableCors bool, dockerVersion st
 			"/exec/{name:.*}/resize":        postContainerExecResize,
 			"/containers/{name:.*}/rename":  postContainerRename,
 			"/containers/{name:.*}/checkpoint": postContainersCheckpoint,
+			"/containers/{name:.*}/checkpoints/receive": postContainersCheckpointsReceive,
+			"/containers/{name:.*}/checkpoint-restart":  postContainersCheckpointRestart,
+			"/containers/{name:.*}/checkpoints/{checkpointID:.*}/verify": postContainersCheckpointVerify,
+			"/containers/{name:.*}/checkpoints/{checkpointID:.*}/rename": postContainersCheckpointRename,
+			"/containers/{name:.*}/checkpoints/{checkpointID:.*}/check":  postContainersCheckpointCheck,
 			"/containers/{name:.*}/restore/{checkpointID:.*}":    postContainersRestore,
+			"/containers/{name:.*}/migrate":                      postContainersMigrate,
 
 			"/containers/load":              postContainersLoad,
+			"/containers/receive":           postContainersReceive,
 		},
 		"DELETE": {
 			"/containers/{name:.*}": deleteContainers,
+			"/containers/{name:.*}/checkpoints/{checkpointID:.*}": deleteContainersCheckpoint,
 			"/images/{name:.*}":     deleteImages,
 		},
 		"OPTIONS": {
@@ -0,0 +1,476 @@
+// Package checkpoint provides a typed client over the engine jobs which
+// checkpoint, restore and migrate containers, so that callers don't have to
+// know their positional arguments and environment.
+package checkpoint
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/pkg/parsers/filters"
+)
+
+// Client drives the checkpoint jobs of a daemon through its engine.
+type Client struct {
+	eng *engine.Engine
+}
+
+func NewClient(eng *engine.Engine) *Client {
+	return &Client{eng: eng}
+}
+
+// CheckpointOptions specifies how a container is checkpointed.
+type CheckpointOptions struct {
+	Stop           bool // stop the container after checkpointed
+	Snapshot       bool // snapshot the rootfs instead of committing it, if the storage driver supports it
+	Labels         map[string]string
+	Description    string
+	ParentID       string   // dump incrementally on top of this checkpoint
+	PreDump        bool     // only dump the memory pages, for a checkpoint with it as parent to freeze the container shorter
+	DedupPages     bool     // store memory pages deduplicated with the other checkpoints
+	Compress       bool     // gzip the memory pages, they are decompressed when restoring
+	Force          bool     // checkpoint even with exec sessions running, leaving them out of the dump
+	TcpEstablished bool     // dump established TCP connections, restorable with the container's own address only
+	ShellJob       bool     // dump the processes as a shell job, e.g. started by an interactive shell
+	NoFileLocks    bool     // fail on file locks held by the processes instead of dumping them
+	Name           string   // name the checkpoint, to restore it by instead of a generated ID
+	ID             string   // use this ID instead of generating one, e.g. the one a receiving daemon prepared
+	PageServer     string   // stream memory pages to the page server at this host:port instead of storing them
+	SkipNamespaces []string // leave the state of these namespaces out, e.g. "net"
+	Frozen         bool     // dump the container paused beforehand and leave it paused
+
+	// Cap in bytes per second of the stream of memory pages to the page
+	// server, unlimited if 0.
+	PageServerBandwidth int64
+
+	// Called with the progress of the checkpoint as it proceeds, if not nil
+	Progress func(status string)
+
+	// How long criu waits for the processes to be frozen, rounded up to
+	// seconds. Its own default if 0.
+	CriuTimeout time.Duration
+}
+
+// CheckpointResult describes a checkpoint just taken.
+type CheckpointResult struct {
+	ID        string
+	Duration  time.Duration
+	ImageSize int64 // size of the checkpoint image in bytes, -1 if unknown
+}
+
+// RestoreOptions specifies how a container is restored from a checkpoint.
+type RestoreOptions struct {
+	Clone           bool     // restore as a clone of the checkpointed container, with a new network address
+	Fork            bool     // restore as a clone into a lighter container sharing the volumes of the checkpointed one
+	Name            string   // name of the fork, generated if empty
+	CgroupParent    string   // cgroup parent to place the restored container under
+	EmptyNetNs      bool     // restore without networking, into an empty network namespace
+	NatNetwork      bool     // restore behind the host NAT instead of attaching to the bridge
+	SkipMemoryCheck bool     // restore even if the host does not have enough memory available
+	Entrypoint      []string // run this command afresh instead of resuming the checkpointed processes
+	Force           bool     // stop the container first if it is running
+	Attached        bool     // keep criu as the parent of the restored processes instead of restoring them detached
+	CloneStrategy   string   // "hardlink", "copy" or "reflink" to clone the image files with, the daemon's default if empty
+}
+
+// RestoreResult describes a container just restored.
+type RestoreResult struct {
+	ID        string // ID of the restored container
+	Pid       int
+	Duration  time.Duration
+	ImageSize int64 // size of the checkpoint image in bytes, -1 if unknown
+}
+
+// RestartResult describes a container restarted from a checkpoint.
+type RestartResult struct {
+	ID           string // ID of the restored container
+	CheckpointID string
+	Pid          int
+	Duration     time.Duration
+}
+
+// VerifyResult describes the outcome of a throwaway restore of a checkpoint.
+type VerifyResult struct {
+	Restorable bool
+	Error      string // why the checkpoint could not be restored, with the errors logged by criu
+	Duration   time.Duration
+}
+
+// CheckResult is the outcome of one of the checks of a checkpoint.
+type CheckResult struct {
+	Name   string // "image", "pages" or "criu"
+	Passed bool
+	Error  string
+}
+
+// CheckReport describes the outcome of checking a checkpoint without
+// restoring it.
+type CheckReport struct {
+	Restorable bool // all the checks passed
+	Checks     []CheckResult
+	Duration   time.Duration
+}
+
+// Checkpoint is an entry of the checkpoints of a container.
+type Checkpoint struct {
+	ID          string
+	ImageID     string
+	CreatedAt   time.Time
+	Labels      map[string]string
+	Description string
+	Stats       *Stats  // nil if criu left no statistics
+	Origin      *Origin // nil if taken by a daemon which didn't record it
+	Size        int64   // size of the image in bytes, -1 if unknown
+
+	// Valid is false if the image of the checkpoint can't be restored,
+	// ValidationError telling what it lacks.
+	Valid           bool
+	ValidationError string
+}
+
+// Origin identifies the host a checkpoint was taken on.
+type Origin struct {
+	Hostname      string
+	KernelVersion string
+	DaemonVersion string
+}
+
+// Stats summarizes what a checkpoint holds.
+type Stats struct {
+	FreezingTime       time.Duration // time taken to freeze the processes
+	FrozenTime         time.Duration // time the processes were frozen for
+	MemdumpTime        time.Duration
+	MemwriteTime       time.Duration
+	PagesScanned       uint64
+	PagesSkippedParent uint64 // pages unchanged since the parent checkpoint, if incremental
+	PagesWritten       uint64
+	OpenFiles          int // file descriptors of all the processes
+	Sockets            int
+}
+
+// MigrateOptions specifies how a container is migrated to another daemon.
+type MigrateOptions struct {
+	Address  string // address of the target host to stream memory pages to
+	Port     int    // port the page server listens on at the target host
+	Snapshot bool   // snapshot the rootfs instead of committing it, if the storage driver supports it
+
+	// Sync the volumes of the container to the target host with rsync,
+	// rebasing their paths by VolumeMap like Load does.
+	SyncVolumes bool
+	VolumeMap   map[string]string
+
+	// Cap in bytes per second of the bandwidth used to transfer the memory
+	// pages and sync the volumes, unlimited if 0. It lets a container be
+	// migrated without disrupting the other traffic on the network.
+	Bandwidth int64
+}
+
+func (c *Client) Checkpoint(container string, opts CheckpointOptions) (*CheckpointResult, error) {
+	stop := ""
+	if opts.Stop {
+		stop = "1"
+	}
+	job := c.eng.Job("checkpoint", container, stop)
+	job.SetenvBool("snapshot", opts.Snapshot)
+	labels := make([]string, 0, len(opts.Labels))
+	for k, v := range opts.Labels {
+		labels = append(labels, k+"="+v)
+	}
+	job.SetenvList("labels", labels)
+	job.Setenv("description", opts.Description)
+	job.Setenv("parent", opts.ParentID)
+	job.SetenvBool("predump", opts.PreDump)
+	job.SetenvBool("dedup", opts.DedupPages)
+	job.SetenvBool("compress", opts.Compress)
+	job.SetenvBool("force", opts.Force)
+	job.SetenvBool("tcpEstablished", opts.TcpEstablished)
+	job.SetenvBool("shellJob", opts.ShellJob)
+	job.SetenvBool("fileLocks", !opts.NoFileLocks)
+	job.Setenv("name", opts.Name)
+	job.Setenv("id", opts.ID)
+	job.Setenv("pageServer", opts.PageServer)
+	job.SetenvList("skipNamespaces", opts.SkipNamespaces)
+	job.SetenvBool("frozen", opts.Frozen)
+	job.SetenvInt64("pageServerBandwidth", opts.PageServerBandwidth)
+	job.SetenvInt64("criuTimeout", int64((opts.CriuTimeout+time.Second-1)/time.Second))
+
+	var progressDone chan struct{}
+	if opts.Progress != nil {
+		job.SetenvBool("progress", true)
+		stream, err := job.Stdout.AddPipe()
+		if err != nil {
+			return nil, err
+		}
+		progressDone = make(chan struct{})
+		go func() {
+			defer close(progressDone)
+			decoder := engine.NewDecoder(stream)
+			for {
+				msg, err := decoder.Decode()
+				if err != nil {
+					io.Copy(ioutil.Discard, stream)
+					return
+				}
+				if status := msg.Get("status"); status != "" {
+					opts.Progress(status)
+				}
+			}
+		}()
+	}
+
+	out, err := run(job)
+	if progressDone != nil {
+		<-progressDone
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &CheckpointResult{
+		ID:        out.Get("Id"),
+		Duration:  time.Duration(out.GetInt64("DurationMs")) * time.Millisecond,
+		ImageSize: out.GetInt64("ImageSize"),
+	}, nil
+}
+
+// List returns the checkpoints of the container having all of the given
+// labels, each of which is either a "key" or a "key=value", newest first.
+func (c *Client) List(container string, labels ...string) ([]*Checkpoint, error) {
+	job := c.eng.Job("checkpoint_list", container)
+	if len(labels) > 0 {
+		param, err := filters.ToParam(filters.Args{"label": labels})
+		if err != nil {
+			return nil, err
+		}
+		job.Setenv("filters", param)
+	}
+	outs, err := job.Stdout.AddListTable()
+	if err != nil {
+		return nil, err
+	}
+	if err := job.Run(); err != nil {
+		return nil, err
+	}
+
+	checkpoints := make([]*Checkpoint, 0, outs.Len())
+	for _, out := range outs.Data {
+		checkpoint := &Checkpoint{
+			ID:              out.Get("Id"),
+			ImageID:         out.Get("ImageID"),
+			Description:     out.Get("Description"),
+			Size:            out.GetInt64("SizeBytes"),
+			Valid:           out.GetBool("Valid"),
+			ValidationError: out.Get("ValidationError"),
+		}
+		if createdAt := out.Get("CreatedAt"); createdAt != "" {
+			if checkpoint.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+				return nil, err
+			}
+		}
+		if err := out.GetJson("Labels", &checkpoint.Labels); err != nil {
+			return nil, err
+		}
+		if err := out.GetJson("Stats", &checkpoint.Stats); err != nil {
+			return nil, err
+		}
+		if err := out.GetJson("Origin", &checkpoint.Origin); err != nil {
+			return nil, err
+		}
+		checkpoints = append(checkpoints, checkpoint)
+	}
+	return checkpoints, nil
+}
+
+func (c *Client) Restore(container, checkpointID string, opts RestoreOptions) (*RestoreResult, error) {
+	job := c.eng.Job("restore", container, checkpointID)
+	job.SetenvBool("clone", opts.Clone)
+	job.SetenvBool("fork", opts.Fork)
+	job.Setenv("name", opts.Name)
+	job.Setenv("cgroupParent", opts.CgroupParent)
+	job.SetenvBool("emptyNetNs", opts.EmptyNetNs)
+	job.SetenvBool("natNetwork", opts.NatNetwork)
+	job.SetenvBool("skipMemoryCheck", opts.SkipMemoryCheck)
+	job.SetenvList("entrypoint", opts.Entrypoint)
+	job.SetenvBool("force", opts.Force)
+	job.SetenvBool("attached", opts.Attached)
+	job.Setenv("cloneStrategy", opts.CloneStrategy)
+
+	out, err := run(job)
+	if err != nil {
+		return nil, err
+	}
+	return &RestoreResult{
+		ID:        out.Get("Id"),
+		Pid:       out.GetInt("Pid"),
+		Duration:  time.Duration(out.GetInt64("DurationMs")) * time.Millisecond,
+		ImageSize: out.GetInt64("ImageSize"),
+	}, nil
+}
+
+// Clone restores a copy of the container from the checkpoint, with a new
+// network address, leaving the container itself as is.
+func (c *Client) Clone(container, checkpointID string, opts RestoreOptions) (*RestoreResult, error) {
+	opts.Clone = true
+	return c.Restore(container, checkpointID, opts)
+}
+
+// Fork restores a copy of the container from the checkpoint into a new
+// container named name, leaving the container itself as is.
+func (c *Client) Fork(container, checkpointID, name string, opts RestoreOptions) (*RestoreResult, error) {
+	opts.Fork = true
+	opts.Name = name
+	return c.Restore(container, checkpointID, opts)
+}
+
+// Restart checkpoints the container stopping it, and restores it from that
+// checkpoint right away.
+func (c *Client) Restart(container string, opts RestoreOptions) (*RestartResult, error) {
+	job := c.eng.Job("checkpoint_restart", container)
+	job.Setenv("cgroupParent", opts.CgroupParent)
+	job.SetenvBool("skipMemoryCheck", opts.SkipMemoryCheck)
+
+	out, err := run(job)
+	if err != nil {
+		return nil, err
+	}
+	return &RestartResult{
+		ID:           out.Get("Id"),
+		CheckpointID: out.Get("CheckpointId"),
+		Pid:          out.GetInt("Pid"),
+		Duration:     time.Duration(out.GetInt64("DurationMs")) * time.Millisecond,
+	}, nil
+}
+
+// Verify checks that the checkpoint can be restored on the daemon, by
+// restoring a clone of the container from it without networking and
+// removing the clone right after.
+func (c *Client) Verify(container, checkpointID string, opts RestoreOptions) (*VerifyResult, error) {
+	job := c.eng.Job("checkpoint_verify", container, checkpointID)
+	job.SetenvBool("skipMemoryCheck", opts.SkipMemoryCheck)
+
+	out, err := run(job)
+	if err != nil {
+		return nil, err
+	}
+	return &VerifyResult{
+		Restorable: out.GetBool("Restorable"),
+		Error:      out.Get("Error"),
+		Duration:   time.Duration(out.GetInt64("DurationMs")) * time.Millisecond,
+	}, nil
+}
+
+// Check checks that the checkpoint can be restored without restoring it:
+// its image files and a sample of its memory pages are checked, and criu
+// restore is run with --check-only. It is cheaper than Verify but doesn't
+// catch as much.
+func (c *Client) Check(container, checkpointID string) (*CheckReport, error) {
+	out, err := run(c.eng.Job("checkpoint_check", container, checkpointID))
+	if err != nil {
+		return nil, err
+	}
+	report := &CheckReport{
+		Restorable: out.GetBool("Restorable"),
+		Duration:   time.Duration(out.GetInt64("DurationMs")) * time.Millisecond,
+	}
+	if err := out.GetJson("Checks", &report.Checks); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Rename gives the checkpoint of the container the ID newID.
+func (c *Client) Rename(container, checkpointID, newID string) error {
+	return c.eng.Job("checkpoint_rename", container, checkpointID, newID).Run()
+}
+
+// Delete removes the checkpoint of the container along with its image.
+func (c *Client) Delete(container, checkpointID string) error {
+	return c.eng.Job("checkpoint_delete", container, checkpointID).Run()
+}
+
+// Receive prepares a checkpoint of the container going to be migrated to
+// this daemon, receiving its memory pages on the given port. It returns
+// the ID of the checkpoint to take on the source daemon.
+func (c *Client) Receive(container string, port int) (string, error) {
+	job := c.eng.Job("checkpoint_receive", container)
+	job.SetenvInt("port", port)
+
+	out, err := run(job)
+	if err != nil {
+		return "", err
+	}
+	return out.Get("Id"), nil
+}
+
+// Load registers the container transferred to this daemon along with its
+// checkpoints, on top of the image its rootfs was committed into. The
+// volume paths of the source host are rebased by volumeMap, mapping them
+// to the paths on this host.
+func (c *Client) Load(container, imageID string, volumeMap map[string]string) error {
+	job := c.eng.Job("container_load", container, imageID)
+	specs := make([]string, 0, len(volumeMap))
+	for src, dest := range volumeMap {
+		specs = append(specs, src+":"+dest)
+	}
+	job.SetenvList("volumeMap", specs)
+	return job.Run()
+}
+
+// Migrate checkpoints the container stopping it, streaming its memory pages
+// to the target daemon. The rest of the checkpoint and the container are
+// then to be transferred to the target host, to be loaded and restored
+// there.
+//
+// If requested, the volumes are synced to the target host while the
+// container is still running, and once more right after it is dumped to
+// transfer what changed meanwhile. The container is down only for the dump
+// and that delta sync, and its volumes are consistent with its checkpoint.
+func (c *Client) Migrate(container string, target *Client, opts MigrateOptions) (*CheckpointResult, error) {
+	var volumes []string
+	if opts.SyncVolumes {
+		var err error
+		if volumes, err = c.volumes(container); err != nil {
+			return nil, err
+		}
+		if err := syncVolumes(volumes, opts.Address, opts.VolumeMap, opts.Bandwidth); err != nil {
+			return nil, err
+		}
+	}
+
+	checkpointID, err := target.Receive(container, opts.Port)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare the target to receive %s: %s", container, err)
+	}
+	result, err := c.Checkpoint(container, CheckpointOptions{
+		Stop:       true,
+		Snapshot:   opts.Snapshot,
+		ID:         checkpointID,
+		PageServer: net.JoinHostPort(opts.Address, strconv.Itoa(opts.Port)),
+
+		PageServerBandwidth: opts.Bandwidth,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The processes are gone, nothing writes to the volumes anymore
+	if err := syncVolumes(volumes, opts.Address, opts.VolumeMap, opts.Bandwidth); err != nil {
+		return nil, fmt.Errorf("failed to sync volumes of %s after checkpointing it: %s", container, err)
+	}
+	return result, nil
+}
+
+func run(job *engine.Job) (*engine.Env, error) {
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		return nil, err
+	}
+	if err := job.Run(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
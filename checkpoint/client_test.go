@@ -0,0 +1,330 @@
+package checkpoint
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/engine"
+)
+
+func TestCheckpoint(t *testing.T) {
+	eng := engine.New()
+	eng.Register("checkpoint", func(job *engine.Job) engine.Status {
+		if !reflect.DeepEqual(job.Args, []string{"container", "1"}) {
+			t.Fatalf("unexpected args %v", job.Args)
+		}
+		if !job.GetenvBool("snapshot") || job.Getenv("parent") != "parent" || job.Getenv("name") != "nightly" || job.GetenvInt64("criuTimeout") != 3 {
+			t.Fatalf("unexpected env %v", job.Environ())
+		}
+		if labels := job.GetenvList("labels"); !reflect.DeepEqual(labels, []string{"app=web"}) {
+			t.Fatalf("unexpected labels %v", labels)
+		}
+		out := &engine.Env{}
+		out.Set("Id", job.Getenv("name"))
+		out.SetInt64("DurationMs", 1500)
+		out.SetInt64("ImageSize", 4096)
+		out.WriteTo(job.Stdout)
+		return engine.StatusOK
+	})
+
+	result, err := NewClient(eng).Checkpoint("container", CheckpointOptions{
+		Stop:     true,
+		Snapshot: true,
+		Labels:   map[string]string{"app": "web"},
+		ParentID: "parent",
+		Name:     "nightly",
+
+		CriuTimeout: 2500 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &CheckpointResult{ID: "nightly", Duration: 1500 * time.Millisecond, ImageSize: 4096}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, result)
+	}
+}
+
+func TestCheckpointError(t *testing.T) {
+	eng := engine.New()
+	eng.Register("checkpoint", func(job *engine.Job) engine.Status {
+		return job.Errorf("Cannot checkpoint container %s", job.Args[0])
+	})
+	if _, err := NewClient(eng).Checkpoint("container", CheckpointOptions{}); err == nil {
+		t.Fatal("expected the job failure to be returned")
+	}
+}
+
+func TestCheckpointProgress(t *testing.T) {
+	eng := engine.New()
+	eng.Register("checkpoint", func(job *engine.Job) engine.Status {
+		if !job.GetenvBool("progress") {
+			t.Fatalf("unexpected env %v", job.Environ())
+		}
+		job.Stdout.Write([]byte(`{"status":"Dumping process 1"}` + "\n"))
+		job.Stdout.Write([]byte(`{"status":"Committing the rootfs"}` + "\n"))
+		out := &engine.Env{}
+		out.Set("Id", "checkpoint")
+		out.WriteTo(job.Stdout)
+		return engine.StatusOK
+	})
+
+	var statuses []string
+	result, err := NewClient(eng).Checkpoint("container", CheckpointOptions{
+		Progress: func(status string) { statuses = append(statuses, status) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ID != "checkpoint" {
+		t.Fatalf("expected the result to be the last line, got %+v", result)
+	}
+	if expected := []string{"Dumping process 1", "Committing the rootfs"}; !reflect.DeepEqual(statuses, expected) {
+		t.Fatalf("expected progress %v, got %v", expected, statuses)
+	}
+}
+
+func TestList(t *testing.T) {
+	createdAt := time.Date(2015, 1, 2, 3, 4, 5, 6, time.UTC)
+	eng := engine.New()
+	eng.Register("checkpoint_list", func(job *engine.Job) engine.Status {
+		if job.Getenv("filters") != `{"label":["app=web"]}` {
+			t.Fatalf("unexpected filters %s", job.Getenv("filters"))
+		}
+		outs := engine.NewTable("", 0)
+		out := &engine.Env{}
+		out.Set("Id", "checkpoint")
+		out.Set("ImageID", "image")
+		out.SetAuto("CreatedAt", createdAt)
+		out.SetJson("Labels", map[string]string{"app": "web"})
+		out.Set("Description", "before upgrade")
+		out.SetInt64("SizeBytes", 8192)
+		out.SetJson("Stats", map[string]interface{}{"PagesWritten": 256, "FrozenTime": 2000000})
+		out.SetJson("Origin", map[string]string{"Hostname": "source", "KernelVersion": "3.19.0", "DaemonVersion": "1.4.1"})
+		out.SetBool("Valid", true)
+		outs.Add(out)
+		outs.WriteListTo(job.Stdout)
+		return engine.StatusOK
+	})
+
+	checkpoints, err := NewClient(eng).List("container", "app=web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*Checkpoint{{
+		ID:          "checkpoint",
+		ImageID:     "image",
+		CreatedAt:   createdAt,
+		Labels:      map[string]string{"app": "web"},
+		Description: "before upgrade",
+		Stats:       &Stats{PagesWritten: 256, FrozenTime: 2 * time.Millisecond},
+		Size:        8192,
+		Origin:      &Origin{Hostname: "source", KernelVersion: "3.19.0", DaemonVersion: "1.4.1"},
+		Valid:       true,
+	}}
+	if !reflect.DeepEqual(checkpoints, expected) {
+		t.Fatalf("expected %+v, got %+v", expected[0], checkpoints[0])
+	}
+}
+
+func TestClone(t *testing.T) {
+	eng := engine.New()
+	eng.Register("restore", func(job *engine.Job) engine.Status {
+		if !reflect.DeepEqual(job.Args, []string{"container", "checkpoint"}) {
+			t.Fatalf("unexpected args %v", job.Args)
+		}
+		if !job.GetenvBool("clone") || job.Getenv("cgroupParent") != "/restored" || job.Getenv("cloneStrategy") != "reflink" {
+			t.Fatalf("unexpected env %v", job.Environ())
+		}
+		out := &engine.Env{}
+		out.Set("Id", "clone")
+		out.SetInt("Pid", 42)
+		out.SetInt64("DurationMs", 200)
+		out.SetInt64("ImageSize", -1)
+		out.WriteTo(job.Stdout)
+		return engine.StatusOK
+	})
+
+	result, err := NewClient(eng).Clone("container", "checkpoint", RestoreOptions{CgroupParent: "/restored", CloneStrategy: "reflink"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &RestoreResult{ID: "clone", Pid: 42, Duration: 200 * time.Millisecond, ImageSize: -1}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, result)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	eng := engine.New()
+	eng.Register("checkpoint_verify", func(job *engine.Job) engine.Status {
+		if !reflect.DeepEqual(job.Args, []string{"container", "checkpoint"}) {
+			t.Fatalf("unexpected args %v", job.Args)
+		}
+		if !job.GetenvBool("skipMemoryCheck") {
+			t.Fatalf("unexpected env %v", job.Environ())
+		}
+		out := &engine.Env{}
+		out.SetBool("Restorable", false)
+		out.Set("Error", "criu exited with 1")
+		out.SetInt64("DurationMs", 300)
+		out.WriteTo(job.Stdout)
+		return engine.StatusOK
+	})
+
+	result, err := NewClient(eng).Verify("container", "checkpoint", RestoreOptions{SkipMemoryCheck: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &VerifyResult{Restorable: false, Error: "criu exited with 1", Duration: 300 * time.Millisecond}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, result)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	eng := engine.New()
+	eng.Register("checkpoint_check", func(job *engine.Job) engine.Status {
+		if !reflect.DeepEqual(job.Args, []string{"container", "checkpoint"}) {
+			t.Fatalf("unexpected args %v", job.Args)
+		}
+		out := &engine.Env{}
+		out.SetBool("Restorable", false)
+		out.SetJson("Checks", []map[string]interface{}{
+			{"Name": "image", "Passed": true},
+			{"Name": "pages", "Passed": false, "Error": "pages-1.img holds 4096 bytes of pages, pagemap-1.img lists 8192"},
+		})
+		out.SetInt64("DurationMs", 20)
+		out.WriteTo(job.Stdout)
+		return engine.StatusOK
+	})
+
+	report, err := NewClient(eng).Check("container", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &CheckReport{
+		Restorable: false,
+		Checks: []CheckResult{
+			{Name: "image", Passed: true},
+			{Name: "pages", Error: "pages-1.img holds 4096 bytes of pages, pagemap-1.img lists 8192"},
+		},
+		Duration: 20 * time.Millisecond,
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, report)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	eng := engine.New()
+	eng.Register("container_load", func(job *engine.Job) engine.Status {
+		if !reflect.DeepEqual(job.Args, []string{"container", "image"}) {
+			t.Fatalf("unexpected args %v", job.Args)
+		}
+		volumeMap := job.GetenvList("volumeMap")
+		sort.Strings(volumeMap)
+		if !reflect.DeepEqual(volumeMap, []string{"/a:/b", "/c:/d"}) {
+			t.Fatalf("unexpected volume map %v", volumeMap)
+		}
+		return engine.StatusOK
+	})
+
+	if err := NewClient(eng).Load("container", "image", map[string]string{"/a": "/b", "/c": "/d"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	source, target := engine.New(), engine.New()
+	target.Register("checkpoint_receive", func(job *engine.Job) engine.Status {
+		if job.GetenvInt("port") != 2015 {
+			t.Fatalf("unexpected port %d", job.GetenvInt("port"))
+		}
+		out := &engine.Env{}
+		out.Set("Id", "received")
+		out.SetInt("Port", 2015)
+		out.WriteTo(job.Stdout)
+		return engine.StatusOK
+	})
+	source.Register("checkpoint", func(job *engine.Job) engine.Status {
+		if job.Args[1] != "1" {
+			t.Fatal("expected the migrated container to be stopped")
+		}
+		if job.Getenv("id") != "received" || job.Getenv("pageServer") != "10.0.0.2:2015" {
+			t.Fatalf("unexpected env %v", job.Environ())
+		}
+		out := &engine.Env{}
+		out.Set("Id", job.Getenv("id"))
+		out.WriteTo(job.Stdout)
+		return engine.StatusOK
+	})
+
+	result, err := NewClient(source).Migrate("container", NewClient(target), MigrateOptions{Address: "10.0.0.2", Port: 2015})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ID != "received" {
+		t.Fatalf("expected the checkpoint prepared by the target, got %s", result.ID)
+	}
+}
+
+func TestMigrateSyncVolumes(t *testing.T) {
+	var steps []string
+	defer func(orig func(src, dest string, bandwidth int64) error) { syncVolume = orig }(syncVolume)
+	syncVolume = func(src, dest string, bandwidth int64) error {
+		if bandwidth != 1<<20 {
+			t.Fatalf("expected the sync to be capped to 1MB/s, got %d", bandwidth)
+		}
+		steps = append(steps, "sync "+src+" "+dest)
+		return nil
+	}
+
+	source, target := engine.New(), engine.New()
+	source.Register("container_inspect", func(job *engine.Job) engine.Status {
+		out := &engine.Env{}
+		out.SetJson("Volumes", map[string]string{"/data": "/var/lib/docker/vfs/dir/data", "/app.log": "/var/log/app.log"})
+		out.WriteTo(job.Stdout)
+		return engine.StatusOK
+	})
+	target.Register("checkpoint_receive", func(job *engine.Job) engine.Status {
+		steps = append(steps, "receive")
+		out := &engine.Env{}
+		out.Set("Id", "received")
+		out.WriteTo(job.Stdout)
+		return engine.StatusOK
+	})
+	source.Register("checkpoint", func(job *engine.Job) engine.Status {
+		if job.GetenvInt64("pageServerBandwidth") != 1<<20 {
+			t.Fatalf("unexpected env %v", job.Environ())
+		}
+		steps = append(steps, "checkpoint")
+		out := &engine.Env{}
+		out.Set("Id", job.Getenv("id"))
+		out.WriteTo(job.Stdout)
+		return engine.StatusOK
+	})
+
+	if _, err := NewClient(source).Migrate("container", NewClient(target), MigrateOptions{
+		Address:     "10.0.0.2",
+		Port:        2015,
+		SyncVolumes: true,
+		VolumeMap:   map[string]string{"/var/log/app.log": "/srv/log/app.log", "/var/lib/docker": "/data/docker"},
+		Bandwidth:   1 << 20,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"sync /var/lib/docker/vfs/dir/data 10.0.0.2:/data/docker/vfs/dir/data",
+		"sync /var/log/app.log 10.0.0.2:/srv/log/app.log",
+		"receive",
+		"checkpoint",
+		"sync /var/lib/docker/vfs/dir/data 10.0.0.2:/data/docker/vfs/dir/data",
+		"sync /var/log/app.log 10.0.0.2:/srv/log/app.log",
+	}
+	if !reflect.DeepEqual(steps, expected) {
+		t.Fatalf("expected %q, got %q", expected, steps)
+	}
+}
@@ -0,0 +1,69 @@
+package checkpoint
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+
+	"github.com/docker/docker/volumes"
+)
+
+// syncVolume copies the volume at src to dest, either of which may be on a
+// remote host as "host:path", transferring only what dest doesn't hold yet
+// and removing from it what src doesn't hold anymore. The transfer is capped
+// to bandwidth bytes per second unless it is 0.
+var syncVolume = func(src, dest string, bandwidth int64) error {
+	if fi, err := os.Stat(src); err != nil {
+		return err
+	} else if fi.IsDir() {
+		// sync the contents, not the directory itself
+		src, dest = src+"/", dest+"/"
+	}
+	args := []string{"-a", "--delete"}
+	if bandwidth > 0 {
+		// rsync takes the limit in KiB per second
+		kbps := bandwidth / 1024
+		if kbps == 0 {
+			kbps = 1
+		}
+		args = append(args, "--bwlimit="+strconv.FormatInt(kbps, 10))
+	}
+	args = append(args, src, dest)
+	if output, err := exec.Command("rsync", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to sync volume %s to %s: %s; %s", src, dest, err, output)
+	}
+	return nil
+}
+
+// volumes returns the host paths of the volumes of the container.
+func (c *Client) volumes(container string) ([]string, error) {
+	out, err := run(c.eng.Job("container_inspect", container))
+	if err != nil {
+		return nil, err
+	}
+	var volumes map[string]string
+	if err := out.GetJson("Volumes", &volumes); err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(volumes))
+	for _, path := range volumes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// syncVolumes syncs the volumes at the given paths to the host at address,
+// rebasing them by the longest directory of volumeMap they are in as Load
+// does, capping the transfer to bandwidth bytes per second unless it is 0.
+func syncVolumes(paths []string, address string, volumeMap map[string]string, bandwidth int64) error {
+	for _, src := range paths {
+		dest := volumes.RebasePath(src, volumeMap)
+		if err := syncVolume(src, address+":"+dest, bandwidth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
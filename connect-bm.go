@@ -7,23 +7,61 @@ import (
 	"net"
 	"io"
 	"io/ioutil"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"sort"
+	"syscall"
 )
 
 const ConnectTimeEpsilon = 10 * time.Millisecond
 
-func waitConnect(host string) (net.Conn, error) {
+var (
+	flIterations = flag.Int("n", 1, "number of times to measure")
+	flRetryWait  = flag.Duration("retry-wait", time.Millisecond, "time to sleep between connection attempts")
+	flFormat     = flag.String("format", "text", "output format: text, csv or json")
+	flTimeout    = flag.Duration("timeout", time.Minute, "give up connecting after this long")
+)
+
+// waitConnect keeps trying to connect to host until it accepts the
+// connection, e.g. once the container listening on it is restored. It
+// gives up on errors which won't go away by retrying, and at deadline.
+func waitConnect(host string, retryWait time.Duration, deadline time.Time) (net.Conn, error) {
 	for {
 		conn, err := net.DialTimeout("tcp", host, ConnectTimeEpsilon)
-		if err != nil {
-			continue
+		if err == nil {
+			return conn, nil
+		}
+		if !isRetryable(err) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s did not come up in time: %s", host, err)
+		}
+		time.Sleep(retryWait)
+	}
+}
+
+// isRetryable tells whether err is from connecting to a host which is up
+// but not listening yet, rather than from one which can't be reached at all,
+// e.g. with no route to it or failing to be resolved.
+func isRetryable(err error) bool {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return true
+	}
+	if oe, ok := err.(*net.OpError); ok {
+		err = oe.Err
+		if se, ok := err.(*os.SyscallError); ok {
+			err = se.Err
 		}
-		return conn, nil
+		return err == syscall.ECONNREFUSED
 	}
+	return false
 }
 
-func waitClose(conn net.Conn) error {
+func waitClose(conn net.Conn, input io.Reader) error {
 	defer conn.Close()
-	if _, err := io.Copy(conn, os.Stdin); err != nil {
+	if _, err := io.Copy(conn, input); err != nil {
 		return err
 	}
 	if _, err := io.Copy(ioutil.Discard, conn); err != nil {
@@ -32,23 +70,116 @@ func waitClose(conn net.Conn) error {
 	return nil
 }
 
+// Stats summarizes the durations, in seconds, of one phase over all
+// the iterations.
+type Stats struct {
+	Min    float64 `json:"min"`
+	Median float64 `json:"median"`
+	P95    float64 `json:"p95"`
+	Max    float64 `json:"max"`
+}
+
+// percentile returns the nearest-rank p-th percentile of the sorted samples.
+func percentile(sorted []float64, p int) float64 {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func newStats(samples []time.Duration) Stats {
+	sorted := make([]float64, len(samples))
+	for i, d := range samples {
+		sorted[i] = d.Seconds()
+	}
+	sort.Float64s(sorted)
+	return Stats{
+		Min:    sorted[0],
+		Median: percentile(sorted, 50),
+		P95:    percentile(sorted, 95),
+		Max:    sorted[len(sorted)-1],
+	}
+}
+
+func printStats(format string, iterations int, phases []string, stats map[string]Stats) error {
+	switch format {
+	case "text":
+		for _, phase := range phases {
+			s := stats[phase]
+			fmt.Printf("%-8s min %f  median %f  p95 %f  max %f secs\n", phase+":", s.Min, s.Median, s.P95, s.Max)
+		}
+	case "csv":
+		fmt.Println("phase,iterations,min,median,p95,max")
+		for _, phase := range phases {
+			s := stats[phase]
+			fmt.Printf("%s,%d,%f,%f,%f,%f\n", phase, iterations, s.Min, s.Median, s.P95, s.Max)
+		}
+	case "json":
+		out := map[string]interface{}{"iterations": iterations}
+		for _, phase := range phases {
+			out[phase] = stats[phase]
+		}
+		return json.NewEncoder(os.Stdout).Encode(out)
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+	return nil
+}
+
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Fprintf(os.Stderr, "Usage: %s HOST\n", os.Args[0])
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS] HOST\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || *flIterations < 1 {
+		flag.Usage()
 		os.Exit(1)
 	}
-	t0 := time.Now()
-	conn, err := waitConnect(os.Args[1])
+	host := flag.Arg(0)
+	switch *flFormat {
+	case "text", "csv", "json":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown output format %q\n", *flFormat)
+		os.Exit(1)
+	}
+
+	// Sent again on every connection
+	input, err := ioutil.ReadAll(os.Stdin)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "connect test failed: %s\n", err)
+		fmt.Fprintf(os.Stderr, "failed to read stdin: %s\n", err)
+		os.Exit(1)
+	}
+
+	var connects, closes, totals []time.Duration
+	for i := 0; i < *flIterations; i++ {
+		t0 := time.Now()
+		conn, err := waitConnect(host, *flRetryWait, t0.Add(*flTimeout))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "connect test failed: %s\n", err)
+			os.Exit(1)
+		}
+		tConn := time.Now()
+
+		if err := waitClose(conn, bytes.NewReader(input)); err != nil {
+			fmt.Fprintf(os.Stderr, "close test failed: %s\n", err)
+			os.Exit(1)
+		}
+		tClose := time.Now()
+
+		connects = append(connects, tConn.Sub(t0))
+		closes = append(closes, tClose.Sub(tConn))
+		totals = append(totals, tClose.Sub(t0))
 	}
-	tConn := time.Now()
-	fmt.Printf("connect: %f secs\n", tConn.Sub(t0).Seconds())
 
-	if err := waitClose(conn); err != nil {
-		fmt.Fprintf(os.Stderr, "close test failed: %s\n", err)
+	stats := map[string]Stats{
+		"connect": newStats(connects),
+		"close":   newStats(closes),
+		"total":   newStats(totals),
+	}
+	if err := printStats(*flFormat, *flIterations, []string{"connect", "close", "total"}, stats); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	tClose := time.Now()
-	fmt.Printf("close:   %f secs\n", tClose.Sub(tConn).Seconds())
-	fmt.Printf("total:   %f secs\n", tClose.Sub(t0).Seconds())
 }
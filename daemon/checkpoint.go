@@ -3,50 +3,371 @@ package daemon
 import (
 	"os"
 	"os/exec"
+	"io"
 	"io/ioutil"
 	"time"
 	"fmt"
 	"strings"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"syscall"
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/engine"
 	"github.com/docker/docker/daemon/execdriver"
+	"github.com/docker/docker/dockerversion"
+	"github.com/docker/docker/pkg/parsers/filters"
+	"github.com/docker/docker/pkg/parsers/kernel"
+	"github.com/docker/docker/pkg/system"
+	"github.com/docker/docker/pkg/units"
+	"github.com/docker/docker/utils"
 )
 
+// cloneWorkers bounds the number of image files linked or copied
+// concurrently while cloning a checkpoint.
+const cloneWorkers = 8
+
+var validCheckpointIDPattern = regexp.MustCompile(`^` + validContainerNameChars + `+$`)
+
+// Images criu writes for every dump, without which it can't restore.
+var requiredCriuImages = []string{"inventory.img", "pstree.img"}
+
 type ContainerCheckpoint struct {
 	ID              string
 	ImageID         string
 	NetworkSettings *NetworkSettings
 	CreatedAt       time.Time
+	SnapshotID      string // ID of the graph driver layer holding the rootfs snapshot, if taken
+	Labels          map[string]string
+	Description     string
+	ParentID        string   // ID of the checkpoint this one is incremental to, if any
+	PreDump         bool     // only the memory pages were dumped, to take a checkpoint on top of instead of restoring it
+	Paused          bool     // the container was paused when dumped, and is restored paused
+	TcpEstablished  bool     // established TCP connections were dumped, and are restored with the same address
+	ShellJob        bool     // the processes were dumped as a shell job, with their session outside of the container
+	FileLocks       bool     // file locks were dumped, and are taken again on restore
+	DedupPages      bool     // memory pages are stored in the container's page store
+	Compressed      bool     // memory pages are gzipped
+	SkipNamespaces  []string // namespaces whose state was left out, restored empty
+	Stats           *CheckpointStats
+	Origin          *CheckpointOrigin // host the checkpoint was taken on, nil for the ones taken before it was recorded
+
+	// Size of the memory pages before they were compressed, if they are
+	UncompressedPagesSize int64 `json:",omitempty"`
 
-	container       *Container
-	original        *ContainerCheckpoint // just nil if it's not a cloned one
+	container  *Container
+	original   *ContainerCheckpoint // just nil if it's not a cloned one
+	pageServer string               // address to stream memory pages to while dumping, if any
+	progress   func(status string)  // called with the progress of the dump, if not nil
+	timeout    time.Duration        // how long criu waits for the tasks to be frozen, its default if 0
+	restoring  int                  // number of restores in progress from it, guarded by the container lock
+}
+
+// CheckpointOrigin identifies the host a checkpoint was taken on, the first
+// thing to look at when it fails to be restored on another one.
+type CheckpointOrigin struct {
+	Hostname      string
+	KernelVersion string
+	DaemonVersion string
+}
+
+func newCheckpointOrigin() *CheckpointOrigin {
+	origin := &CheckpointOrigin{
+		Hostname:      "<unknown>",
+		KernelVersion: "<unknown>",
+		DaemonVersion: dockerversion.VERSION,
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		origin.Hostname = hostname
+	}
+	if kv, err := kernel.GetKernelVersion(); err == nil {
+		origin.KernelVersion = kv.String()
+	}
+	return origin
+}
+
+func (o *CheckpointOrigin) String() string {
+	return fmt.Sprintf("%s (kernel %s, docker %s)", o.Hostname, o.KernelVersion, o.DaemonVersion)
+}
+
+// CheckpointOptions specifies how a container is checkpointed.
+type CheckpointOptions struct {
+	Stop           bool // stop the container after checkpointed
+	Snapshot       bool // snapshot the rootfs instead of committing it, if the storage driver supports it
+	Labels         map[string]string
+	Description    string
+	ParentID       string   // dump incrementally on top of this checkpoint
+	PreDump        bool     // only dump the memory pages, for a checkpoint on top of it to freeze the container shorter
+	DedupPages     bool     // store memory pages deduplicated with the other checkpoints
+	Compress       bool     // gzip the memory pages after dumping them
+	Force          bool     // checkpoint even with exec sessions running, leaving them out of the dump
+	TcpEstablished bool     // dump established TCP connections, instead of failing on them
+	ShellJob       bool     // dump the processes as a shell job, e.g. started by an interactive shell
+	FileLocks      bool     // dump the file locks held by the processes, instead of failing on them
+	ID             string   // use this ID instead of generating one, e.g. a name or the one a receiving daemon prepared
+	PageServer     string   // stream memory pages to the page server at this host:port instead of storing them
+	SkipNamespaces []string // leave the state of these namespaces out, e.g. "net" to set networking up afresh
+
+	// Cap in bytes per second of the stream of memory pages to the page
+	// server, so as not to saturate a network shared with other traffic.
+	// Unlimited if 0.
+	PageServerBandwidth int64
+
+	// The container was paused by the caller, e.g. along with the other
+	// containers of a group so that their checkpoints are consistent with
+	// each other, and checkpointing fails if it isn't. A paused container
+	// is always dumped as is and left paused for the caller to unpause
+	// once all of them are checkpointed.
+	Frozen bool
+
+	// Called with the progress of the checkpoint as it proceeds, if not nil
+	Progress func(status string)
+
+	// How long criu waits for the tasks to be frozen before giving up on
+	// the dump, its own default if 0. This is not a timeout of the whole
+	// checkpoint, only of the wait for misbehaving processes to stop.
+	CriuTimeout time.Duration
+}
+
+// RestoreOptions specifies how a container is restored from a checkpoint.
+type RestoreOptions struct {
+	Clone        bool     // restore as a clone of the checkpointed container, with a new network address
+	CgroupParent string   // cgroup parent to place the restored container under
+	EmptyNetNs   bool     // restore without networking, into an empty network namespace
+	NatNetwork   bool     // restore behind the host NAT with the same address, instead of attaching to the bridge
+	Entrypoint   []string // run this command afresh instead of resuming the checkpointed processes, for recovery
+	Attached     bool     // keep criu as the parent of the restored processes instead of restoring them detached
 }
 
 func (cp *ContainerCheckpoint) imagePath() string {
-	return filepath.Join(cp.container.root, "checkpoints", cp.ID)
+	return filepath.Join(cp.container.checkpointsPath(), cp.ID)
+}
+
+// imageSize returns the size in bytes of the checkpoint image on disk.
+func (cp *ContainerCheckpoint) imageSize() int64 {
+	size, err := utils.TreeSize(cp.imagePath())
+	if err != nil {
+		log.Warnf("failed to get size of checkpoint image %s: %s", cp.imagePath(), err)
+		return -1
+	}
+	return size
+}
+
+// validateImage returns an error telling what is missing from the image of
+// the checkpoint for it to be restorable, e.g. after a dump which failed
+// halfway or a transfer from another host which didn't complete.
+func (cp *ContainerCheckpoint) validateImage() error {
+	if cp.PreDump {
+		return fmt.Errorf("it is a pre-dump of the memory, only a checkpoint taken on top of it can be restored")
+	}
+	imagePath := cp.imagePath()
+	for _, name := range requiredCriuImages {
+		if _, err := os.Stat(filepath.Join(imagePath, name)); err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("%s is missing from the checkpoint image", name)
+			}
+			return err
+		}
+	}
+	cores, err := filepath.Glob(filepath.Join(imagePath, "core-*.img"))
+	if err != nil {
+		return err
+	}
+	if len(cores) == 0 {
+		return fmt.Errorf("no process core image in the checkpoint image")
+	}
+	return nil
+}
+
+// checkpointStatus is a checkpoint as reported by inspect and the list
+// API, flagged if its image is not restorable.
+type checkpointStatus struct {
+	*ContainerCheckpoint
+	Valid           bool
+	ValidationError string `json:",omitempty"`
+	// Disk taken by the image, and by the memory pages out of it, not
+	// counting the deduplicated pages shared with other checkpoints
+	SizeBytes       int64
+	PagesImageBytes int64
+}
+
+func (container *Container) checkpointStatuses() []*checkpointStatus {
+	checkpoints := container.sortedCheckpoints()
+	statuses := make([]*checkpointStatus, len(checkpoints))
+	for i, checkpoint := range checkpoints {
+		statuses[i] = &checkpointStatus{
+			ContainerCheckpoint: checkpoint,
+			Valid:               true,
+			SizeBytes:           checkpoint.imageSize(),
+			PagesImageBytes:     checkpoint.pagesImageSize(),
+		}
+		if err := checkpoint.validateImage(); err != nil {
+			statuses[i].Valid = false
+			statuses[i].ValidationError = err.Error()
+		}
+	}
+	return statuses
 }
 
 func (cp *ContainerCheckpoint) execdriverCheckpoint() *execdriver.Checkpoint {
-	return &execdriver.Checkpoint{
-		Command:   cp.container.command,
-		ImagePath: cp.imagePath(),
-		Volumes:   cp.container.Volumes,
+	checkpoint := &execdriver.Checkpoint{
+		Command:        cp.container.command,
+		ImagePath:      cp.imagePath(),
+		StorageDriver:  cp.container.Driver,
+		PageServer:     cp.pageServer,
+		SkipNamespaces: cp.SkipNamespaces,
+		Frozen:         cp.Paused,
+		TcpEstablished: cp.TcpEstablished,
+		ShellJob:       cp.ShellJob,
+		FileLocks:      cp.FileLocks,
+		Progress:       cp.progress,
+		CriuTimeout:    cp.timeout,
+		PreDump:        cp.PreDump,
+	}
+	if cp.ParentID != "" {
+		checkpoint.ParentImagePath = filepath.Join(cp.container.checkpointsPath(), cp.ParentID)
 	}
+	return checkpoint
+}
+
+// skipsNamespace returns true if the state of the namespace ns was left out
+// of the checkpoint.
+func (cp *ContainerCheckpoint) skipsNamespace(ns string) bool {
+	for _, skipped := range cp.SkipNamespaces {
+		if skipped == ns {
+			return true
+		}
+	}
+	return false
+}
+
+// startRestoring keeps the checkpoint from being deleted until
+// doneRestoring is called.
+func (cp *ContainerCheckpoint) startRestoring() {
+	cp.container.Lock()
+	cp.restoring++
+	cp.container.Unlock()
+}
+
+func (cp *ContainerCheckpoint) doneRestoring() {
+	cp.container.Lock()
+	cp.restoring--
+	cp.container.Unlock()
 }
 
 func (cp *ContainerCheckpoint) cleanFiles() {
 	if err := os.RemoveAll(cp.imagePath()); err != nil {
 		log.Warnf("failed to cleanup checkpoint image %s: %s", cp.imagePath(), err)
 	}
+	if cp.SnapshotID != "" && cp.original == nil {
+		if err := cp.container.daemon.driver.Remove(cp.SnapshotID); err != nil {
+			log.Warnf("failed to remove checkpoint rootfs snapshot %s: %s", cp.SnapshotID, err)
+		}
+	}
 }
 
-func (cp *ContainerCheckpoint) clone(forContainer *Container) (*ContainerCheckpoint, error) {
+// snapshotSupported returns true if the storage driver of the container can
+// take a cheap and atomic snapshot of its rootfs. Devicemapper suspends the
+// thin device, flushing the filesystem on it, while snapshotting it.
+func (cp *ContainerCheckpoint) snapshotSupported() bool {
+	switch cp.container.Driver {
+	case "btrfs", "devicemapper":
+		return true
+	}
+	return false
+}
+
+// takeSnapshot snapshots the rootfs of the checkpointed container into a
+// new graph driver layer instead of committing it into an image.
+func (cp *ContainerCheckpoint) takeSnapshot(stop bool) error {
+	container := cp.container
+	if !stop {
+		if err := container.pause(); err != nil {
+			return fmt.Errorf("failed to pause %s: %s", container.ID, err)
+		}
+		defer func() {
+			if err := container.unpause(); err != nil {
+				log.Errorf("failed to unpause %s: %s", container.ID, err)
+			}
+		}()
+	}
+	snapshotID := fmt.Sprintf("%s-checkpoint-%s", container.ID, cp.ID)
+	if err := container.daemon.driver.Create(snapshotID, container.ID); err != nil {
+		return fmt.Errorf("failed to snapshot rootfs of %s: %s", container.ID, err)
+	}
+	cp.SnapshotID = snapshotID
+	// The image is still needed as a base for cloning the container
+	cp.ImageID = container.ImageID
+	return nil
+}
+
+// restoreSnapshot replaces the rootfs of the given container with a
+// snapshot of the checkpoint's rootfs snapshot.
+func (cp *ContainerCheckpoint) restoreSnapshot(container *Container) error {
+	driver := container.daemon.driver
+	if err := driver.Remove(container.ID); err != nil {
+		return err
+	}
+	return driver.Create(container.ID, cp.SnapshotID)
+}
+
+// Strategies to clone the image files of a checkpoint with, for restoring
+// it into another container.
+const (
+	CloneHardlink = "hardlink" // share the files with the checkpoint, the default
+	CloneCopy     = "copy"     // copy the files, independent of the checkpoint
+	CloneReflink  = "reflink"  // copy-on-write copy of the files if the filesystem supports it, plain copy otherwise
+)
+
+func validateCloneStrategy(strategy string) error {
+	switch strategy {
+	case "", CloneHardlink, CloneCopy, CloneReflink:
+		return nil
+	}
+	return fmt.Errorf("invalid clone strategy %q, must be one of %s, %s or %s", strategy, CloneHardlink, CloneCopy, CloneReflink)
+}
+
+// checkCloneAddress verifies that the clone the checkpoint is restored into
+// was allocated an address of its own, rather than the one of the
+// checkpointed container, which may still be running, or the one its
+// processes had when they were dumped, which patching would then leave as
+// is. The clone is allocated its address before the image is patched.
+func (cp *ContainerCheckpoint) checkCloneAddress() error {
+	ip := cp.container.NetworkSettings.IPAddress
+	if ip == "" {
+		return nil
+	}
+	source := cp.original.container
+	if ip == cp.original.NetworkSettings.IPAddress || (source.IsRunning() && source.NetworkSettings != nil && ip == source.NetworkSettings.IPAddress) {
+		return fmt.Errorf("clone %s was allocated the address %s of the checkpointed container %s", cp.container.ID, ip, source.ID)
+	}
+	return nil
+}
+
+// clone clones the image of the checkpoint for restoring it into
+// forContainer, linking or copying its files according to strategy.
+func (cp *ContainerCheckpoint) clone(forContainer *Container, strategy string) (*ContainerCheckpoint, error) {
 	newCheckpoint := *cp
 	networkSettings := *cp.NetworkSettings
 	newCheckpoint.NetworkSettings = &networkSettings
 	newCheckpoint.container = forContainer
 	newCheckpoint.original = cp
+	newCheckpoint.DedupPages = false
+	newCheckpoint.Compressed = false
+	newCheckpoint.UncompressedPagesSize = 0
+
+	// Images of an incremental checkpoint refer to its parent's images by
+	// a relative "parent" link, so the whole chain has to be cloned.
+	if cp.ParentID != "" {
+		parent := cp.container.Checkpoints[cp.ParentID]
+		if parent == nil {
+			return nil, fmt.Errorf("parent checkpoint %s of %s does not exist", cp.ParentID, cp.ID)
+		}
+		if _, err := parent.clone(forContainer, strategy); err != nil {
+			return nil, err
+		}
+	}
 
 	newImagePath := newCheckpoint.imagePath()
 	if err := os.MkdirAll(newImagePath, 0775); err != nil {
@@ -64,34 +385,159 @@ func (cp *ContainerCheckpoint) clone(forContainer *Container) (*ContainerCheckpo
 	if err != nil {
 		return nil, err
 	}
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		errs  []string
+		names = make(chan string)
+	)
+	for i := 0; i < cloneWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				if err := cp.cloneImageFile(imagePath, newImagePath, name, strategy); err != nil {
+					mu.Lock()
+					errs = append(errs, err.Error())
+					mu.Unlock()
+				}
+			}
+		}()
+	}
 	for _, name := range dirents {
-		// TODO solve this by better way
+		// Left in the images of checkpoints restored before the pidfile
+		// was moved out of them
 		if name == "restore.pid" {
 			continue
 		}
-		src := filepath.Join(imagePath, name)
-		dest := filepath.Join(newImagePath, name)
-		// if err := os.Symlink(src, dest); err != nil {
-		if err := os.Link(src, dest); err != nil {
-			return nil, err
-		}
+		names <- name
+	}
+	close(names)
+	wg.Wait()
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("failed to clone checkpoint %s: %s", cp.ID, strings.Join(errs, ", "))
 	}
 	return &newCheckpoint, nil
 }
 
+// cloneImageFile links or copies the image file name of cp into
+// newImagePath according to strategy, copying it if it can't be linked, or
+// assembles or decompresses the memory pages of it if they are
+// deduplicated or compressed. Symlinks are recreated as they are.
+func (cp *ContainerCheckpoint) cloneImageFile(imagePath, newImagePath, name, strategy string) error {
+	src := filepath.Join(imagePath, name)
+	dest := filepath.Join(newImagePath, name)
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		// e.g. the parent link of an incremental checkpoint, relative
+		// to the image and pointing to the parent cloned along with it
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dest)
+	}
+	if cp.Compressed && isCompressedPagesImage(name) {
+		pagesPath := strings.TrimSuffix(dest, compressedPagesExtension)
+		if err := decompressFile(src, pagesPath); err != nil {
+			return fmt.Errorf("failed to decompress %s: %s", src, err)
+		}
+		return nil
+	}
+	if cp.DedupPages && strings.HasSuffix(name, pageManifestExtension) {
+		pagesPath := strings.TrimSuffix(dest, pageManifestExtension)
+		if err := assemblePages(cp.container.pageStorePath(), src, pagesPath); err != nil {
+			return fmt.Errorf("failed to assemble %s: %s", pagesPath, err)
+		}
+		return nil
+	}
+	switch strategy {
+	case CloneCopy:
+		if err := copyImageFile(src, dest); err != nil {
+			return fmt.Errorf("failed to copy %s: %s", src, err)
+		}
+		return nil
+	case CloneReflink:
+		if err := reflinkFile(src, dest); err != nil {
+			return fmt.Errorf("failed to clone %s: %s", src, err)
+		}
+		return nil
+	}
+	if err := os.Link(src, dest); err != nil {
+		// The checkpoint root may live on a different device
+		// than the checkpoint being cloned, e.g. after it has
+		// been reconfigured, so fall back to copying.
+		if linkErr, ok := err.(*os.LinkError); !ok || linkErr.Err != syscall.EXDEV {
+			return err
+		}
+		if err := copyImageFile(src, dest); err != nil {
+			return fmt.Errorf("failed to copy %s: %s", src, err)
+		}
+	}
+	return nil
+}
+
+// copyImageFile copies the image file src to dest, which must not exist
+// yet, with the same mode for the copy to be restorable like a link is.
+func copyImageFile(src, dest string) (err error) {
+	sf, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sf.Close()
+	fi, err := sf.Stat()
+	if err != nil {
+		return err
+	}
+	df, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := df.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(dest)
+		}
+	}()
+	if _, err := io.Copy(df, sf); err != nil {
+		return err
+	}
+	// The mode given to OpenFile is masked by the umask
+	return df.Chmod(fi.Mode().Perm())
+}
+
 func (cp *ContainerCheckpoint) patchImage() error {
+	if err := cp.checkCloneAddress(); err != nil {
+		return err
+	}
 	imagePath := cp.imagePath()
 	tmpdir, err := ioutil.TempDir(os.TempDir(), "docker-patchcriu-")
 	if err != nil {
 		return err
 	}
-	defer os.Remove(tmpdir) // No need to be RemoveAll, see below
+	// Emptied by the renames below, unless one of them fails
+	defer os.RemoveAll(tmpdir)
 
-	cmd := exec.Command("patch-criu", imagePath, tmpdir,
-		"ip="+cp.container.NetworkSettings.IPAddress,
-		"mac="+strings.Replace(cp.container.NetworkSettings.MacAddress, ":", "", -1),
-		"cgroup="+fmt.Sprintf("docker-%s:docker-%s", cp.original.container.ID, cp.container.ID))
-	output, err := cmd.CombinedOutput()
+	// The cgroups of the container are named after its ID, as docker/ID
+	// by the cgroupfs driver and docker-ID.scope by systemd's, so the
+	// IDs alone are rewritten to cover both.
+	args := []string{imagePath, tmpdir,
+		"cgroup=" + cp.original.container.ID + ":" + cp.container.ID}
+	// There's no address to rewrite to if restoring without networking
+	if cp.container.NetworkSettings.IPAddress != "" {
+		args = append(args,
+			"ip="+cp.container.NetworkSettings.IPAddress,
+			"mac="+strings.Replace(cp.container.NetworkSettings.MacAddress, ":", "", -1))
+	}
+	if cp.container.NetworkSettings.GlobalIPv6Address != "" {
+		args = append(args, "ip="+cp.container.NetworkSettings.GlobalIPv6Address)
+	}
+	output, err := exec.Command("patch-criu", args...).CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("patch-criu %s: output=%s", err, string(output))
 	}
@@ -106,13 +552,308 @@ func (cp *ContainerCheckpoint) patchImage() error {
 		return err
 	}
 	for _, name := range dirents {
-		if err := os.Rename(filepath.Join(tmpdir, name), filepath.Join(imagePath, name)); err != nil {
+		dest := filepath.Join(imagePath, name)
+		orig, err := os.Stat(dest)
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(filepath.Join(tmpdir, name), dest); err != nil {
+			return err
+		}
+		// The patched image has to match the rest of the dump
+		if err := os.Chmod(dest, orig.Mode().Perm()); err != nil {
+			return err
+		}
+		if st, ok := orig.Sys().(*syscall.Stat_t); ok {
+			if err := os.Lchown(dest, int(st.Uid), int(st.Gid)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// matchLabels returns true if the checkpoint has all of the given labels,
+// each of which is either a "key" or a "key=value".
+func (cp *ContainerCheckpoint) matchLabels(labels []string) bool {
+	for _, label := range labels {
+		kv := strings.SplitN(label, "=", 2)
+		value, exists := cp.Labels[kv[0]]
+		if !exists || (len(kv) == 2 && value != kv[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkpointRoot returns the directory the checkpoint data of the
+// container is stored under, which is the container's root unless the
+// daemon is configured with a separate checkpoint root.
+func (container *Container) checkpointRoot() string {
+	if container.daemon == nil {
+		return container.root
+	}
+	return container.daemon.checkpointRoot(container.ID)
+}
+
+func (daemon *Daemon) checkpointRoot(containerID string) string {
+	if daemon.config.CheckpointRoot != "" {
+		return filepath.Join(daemon.config.CheckpointRoot, containerID)
+	}
+	return filepath.Join(daemon.repository, containerID)
+}
+
+func (container *Container) checkpointsPath() string {
+	return filepath.Join(container.checkpointRoot(), "checkpoints")
+}
+
+// cleanCheckpoints removes all checkpoints of the container along with
+// their images.
+func (container *Container) cleanCheckpoints() error {
+	for id, checkpoint := range container.Checkpoints {
+		checkpoint.cleanFiles()
+		delete(container.Checkpoints, id)
+	}
+	if err := os.RemoveAll(container.pageStorePath()); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(container.checkpointsPath()); err != nil {
+		return err
+	}
+	if root := container.checkpointRoot(); root != container.root {
+		return os.RemoveAll(root)
+	}
+	return nil
+}
+
+// deleteCheckpoint removes the checkpoint along with its image. It refuses
+// to remove a checkpoint which other checkpoints are incremental to, or
+// which is being restored from.
+func (container *Container) deleteCheckpoint(id string) error {
+	checkpoint := container.Checkpoints[id]
+	if checkpoint == nil {
+		return fmt.Errorf("No such checkpoint %s for container %s", id, container.ID)
+	}
+	if checkpoint.restoring > 0 {
+		return fmt.Errorf("checkpoint %s is being restored", id)
+	}
+	for _, cp := range container.Checkpoints {
+		if cp.ParentID == id {
+			return fmt.Errorf("checkpoint %s is the parent of checkpoint %s", id, cp.ID)
+		}
+	}
+	checkpoint.cleanFiles()
+	delete(container.Checkpoints, id)
+	if checkpoint.DedupPages {
+		return container.gcPageStore()
+	}
+	return nil
+}
+
+// isCheckpointAncestor tells whether cp is the checkpoint id or is
+// incremental to it, directly or not.
+func (container *Container) isCheckpointAncestor(id string, cp *ContainerCheckpoint) bool {
+	for ; cp != nil; cp = container.Checkpoints[cp.ParentID] {
+		if cp.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// renameCheckpoint gives the checkpoint id the ID newID, e.g. to promote
+// one taken automatically to a well-known name. Its image is moved along,
+// and the checkpoints incremental to it are pointed to it again.
+func (container *Container) renameCheckpoint(id, newID string) error {
+	checkpoint := container.Checkpoints[id]
+	if checkpoint == nil {
+		return fmt.Errorf("No such checkpoint %s for container %s", id, container.ID)
+	}
+	if !validCheckpointIDPattern.MatchString(newID) {
+		return fmt.Errorf("Invalid checkpoint name %q, only %s are allowed", newID, validContainerNameChars)
+	}
+	if container.Checkpoints[newID] != nil {
+		return fmt.Errorf("Checkpoint %s already exists for container %s", newID, container.ID)
+	}
+	// The image would be moved out from under criu, which also reads it
+	// through the parent links when restoring the checkpoints on top of it
+	for _, cp := range container.Checkpoints {
+		if cp.restoring > 0 && container.isCheckpointAncestor(id, cp) {
+			return fmt.Errorf("checkpoint %s is being restored", cp.ID)
+		}
+	}
+
+	oldPath := checkpoint.imagePath()
+	checkpoint.ID = newID
+	if err := os.Rename(oldPath, checkpoint.imagePath()); err != nil {
+		checkpoint.ID = id
+		return err
+	}
+	delete(container.Checkpoints, id)
+	container.Checkpoints[newID] = checkpoint
+
+	for _, cp := range container.Checkpoints {
+		if cp.ParentID != id {
+			continue
+		}
+		cp.ParentID = newID
+		// criu follows the parent link it created in the image when
+		// restoring, it's relative to the image
+		link := filepath.Join(cp.imagePath(), "parent")
+		if _, err := os.Lstat(link); err != nil {
+			continue
+		}
+		target, err := filepath.Rel(cp.imagePath(), checkpoint.imagePath())
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(link); err != nil {
 			return err
 		}
+		if err := os.Symlink(target, link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkpointsSize returns the disk usage of all checkpoints of the
+// container, including the memory pages in its page store.
+func (container *Container) checkpointsSize() int64 {
+	var size int64
+	for _, path := range []string{container.checkpointsPath(), container.pageStorePath()} {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		s, err := utils.TreeSize(path)
+		if err != nil {
+			log.Warnf("failed to get size of %s: %s", path, err)
+			continue
+		}
+		size += s
+	}
+	return size
+}
+
+// enforceCheckpointQuota makes sure the checkpoints of the container don't
+// exceed the checkpoint quota of the daemon, if any, before another one is
+// taken. Depending on the configuration, the oldest checkpoints which
+// aren't needed by others are removed until they fit, or an error is
+// returned. keepID is never removed, e.g. the parent of the new checkpoint.
+func (container *Container) enforceCheckpointQuota(keepID string) error {
+	if container.daemon == nil || container.daemon.config.CheckpointQuota <= 0 {
+		return nil
+	}
+	quota := container.daemon.config.CheckpointQuota
+
+	size := container.checkpointsSize()
+	if size < quota {
+		return nil
+	}
+	if !container.daemon.config.CheckpointQuotaPrune {
+		return fmt.Errorf("checkpoints of container %s use %s, exceeding the quota of %s",
+			container.ID, units.HumanSize(float64(size)), units.HumanSize(float64(quota)))
+	}
+
+	// The pruned checkpoints are gone from disk, save the container right
+	// away for them not to come back if the checkpoint fails afterwards.
+	pruned := false
+	defer func() {
+		if !pruned {
+			return
+		}
+		if err := container.toDisk(); err != nil {
+			log.Errorf("Failed to save container %s after pruning its checkpoints: %s", container.ID, err)
+		}
+	}()
+
+	// Parents can only be removed after their children, so repeat until
+	// nothing more can be removed.
+	for removed := true; removed; {
+		removed = false
+		for _, checkpoint := range container.sortedCheckpoints() {
+			if checkpoint.ID == keepID {
+				continue
+			}
+			if err := container.deleteCheckpoint(checkpoint.ID); err != nil {
+				continue
+			}
+			removed, pruned = true, true
+			log.Infof("Removed checkpoint %s of container %s to fit in the checkpoint quota", checkpoint.ID, container.ID)
+			if size = container.checkpointsSize(); size < quota {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("checkpoints of container %s use %s, exceeding the quota of %s even after pruning",
+		container.ID, units.HumanSize(float64(size)), units.HumanSize(float64(quota)))
+}
+
+// sortedCheckpoints returns the checkpoints of the container ordered by
+// creation time.
+func (container *Container) sortedCheckpoints() []*ContainerCheckpoint {
+	checkpoints := make([]*ContainerCheckpoint, 0, len(container.Checkpoints))
+	for _, checkpoint := range container.Checkpoints {
+		checkpoints = append(checkpoints, checkpoint)
+		for i := len(checkpoints) - 1; i > 0; i-- {
+			if checkpoints[i-1].CreatedAt.Before(checkpoint.CreatedAt) {
+				break
+			}
+			checkpoints[i], checkpoints[i-1] = checkpoints[i-1], checkpoint
+		}
+	}
+	return checkpoints
+}
+
+// checkCheckpointable returns an error explaining why the container can't
+// be checkpointed if it shares namespaces with the host, as criu would only
+// fail on it with an obscure message.
+func (container *Container) checkCheckpointable() error {
+	if container.hostConfig == nil {
+		return nil
+	}
+	if container.hostConfig.PidMode.IsHost() {
+		return fmt.Errorf("it shares the PID namespace with the host (--pid=host), its process tree is not contained in the container")
+	}
+	if container.hostConfig.NetworkMode.IsHost() {
+		return fmt.Errorf("it shares the network namespace with the host (--net=host), which can't be dumped nor restored along with it")
+	}
+	return nil
+}
+
+// checkAvailableMemory verifies that the host has enough memory
+// available to hold the memory of the checkpointed processes.
+func (cp *ContainerCheckpoint) checkAvailableMemory() error {
+	size, err := cp.pagesSize()
+	if err != nil {
+		return err
+	}
+	meminfo, err := system.ReadMemInfo()
+	if err != nil {
+		return err
+	}
+	available := meminfo.MemAvailable
+	if available == 0 {
+		// not reported by older kernels
+		available = meminfo.MemFree
+	}
+	if size > available {
+		return fmt.Errorf("checkpoint %s needs up to %s of memory but only %s is available",
+			cp.ID, units.BytesSize(float64(size)), units.BytesSize(float64(available)))
 	}
 	return nil
 }
 
+// checkpointEventAttributes returns the attributes of the events logged
+// when the checkpoint is taken or restored from.
+func checkpointEventAttributes(cp *ContainerCheckpoint, duration time.Duration) map[string]string {
+	return map[string]string{
+		"checkpointId": cp.ID,
+		"durationMs":   strconv.FormatInt(int64(duration/time.Millisecond), 10),
+		"imageSize":    strconv.FormatInt(cp.imageSize(), 10),
+	}
+}
+
 func (daemon *Daemon) ContainerCheckpoint(job *engine.Job) engine.Status {
 	if len(job.Args) != 2 {
 		return job.Errorf("Usage: %s CONTAINER", job.Name)
@@ -122,11 +863,407 @@ func (daemon *Daemon) ContainerCheckpoint(job *engine.Job) engine.Status {
 	if container == nil {
 		return job.Errorf("No such container: %s", name)
 	}
-	// TODO is this ok with job.Args[1] == "1"?
-	if err := container.Checkpoint(job.Args[1] == "1"); err != nil {
+	opts := &CheckpointOptions{
+		// TODO is this ok with job.Args[1] == "1"?
+		Stop:           job.Args[1] == "1",
+		Snapshot:       job.GetenvBool("snapshot"),
+		Labels:         make(map[string]string),
+		Description:    job.Getenv("description"),
+		ParentID:       job.Getenv("parent"),
+		PreDump:        job.GetenvBool("predump"),
+		DedupPages:     job.GetenvBool("dedup"),
+		Compress:       job.GetenvBool("compress"),
+		Force:          job.GetenvBool("force"),
+		TcpEstablished: job.GetenvBool("tcpEstablished"),
+		ShellJob:       job.GetenvBool("shellJob"),
+		// File locks are dumped unless told otherwise
+		FileLocks:      !job.EnvExists("fileLocks") || job.GetenvBool("fileLocks"),
+		ID:             job.Getenv("id"),
+		PageServer:     job.Getenv("pageServer"),
+		SkipNamespaces: job.GetenvList("skipNamespaces"),
+		Frozen:         job.GetenvBool("frozen"),
+
+		PageServerBandwidth: job.GetenvInt64("pageServerBandwidth"),
+		CriuTimeout:         time.Duration(job.GetenvInt64("criuTimeout")) * time.Second,
+	}
+	// Deduplicated pages are no longer in the image to be compressed
+	if opts.DedupPages && opts.Compress {
+		return job.Errorf("Cannot checkpoint container %s: memory pages can't be both deduplicated and compressed", name)
+	}
+	// A name is the ID of the checkpoint, to restore it by
+	if checkpointName := job.Getenv("name"); checkpointName != "" {
+		if opts.ID != "" {
+			return job.Errorf("Cannot name a checkpoint taken with the ID prepared by a receiving daemon")
+		}
+		opts.ID = checkpointName
+	}
+	if job.GetenvBool("progress") {
+		// The progress goes first, one JSON message per line, and the
+		// result is the last line as usual.
+		sf := utils.NewStreamFormatter(true)
+		opts.Progress = func(status string) {
+			job.Stdout.Write(sf.FormatStatus("", "%s", status))
+		}
+	}
+	for _, label := range job.GetenvList("labels") {
+		kv := strings.SplitN(label, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return job.Errorf("Invalid label %s, must be in the form of key=value", label)
+		}
+		opts.Labels[kv[0]] = kv[1]
+	}
+
+	startedAt := time.Now()
+	container.LogEvent("checkpoint-start")
+	checkpoint, err := container.Checkpoint(opts)
+	if err != nil {
+		container.LogEventWithAttributes("checkpoint-failed", map[string]string{
+			"error": err.Error(),
+		})
 		return job.Errorf("Cannot checkpoint container %s: %s", name, err)
 	}
-	container.LogEvent("checkpoint")
+	duration := time.Since(startedAt)
+	container.LogEventWithAttributes("checkpoint", checkpointEventAttributes(checkpoint, duration))
+
+	out := &engine.Env{}
+	out.Set("Id", checkpoint.ID)
+	out.SetInt64("DurationMs", int64(duration/time.Millisecond))
+	out.SetInt64("ImageSize", checkpoint.imageSize())
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// ContainerCheckpointReceive prepares a checkpoint of a container which is
+// going to be migrated to this host, and starts a page server receiving the
+// memory pages the source daemon streams while dumping it. The other image
+// files are small and are expected to be transferred along with the
+// container afterwards.
+func (daemon *Daemon) ContainerCheckpointReceive(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s CONTAINER_ID", job.Name)
+	}
+	id := job.Args[0]
+	// It comes from the URL and names a directory under the daemon root
+	if err := utils.ValidateID(id); err != nil {
+		return job.Errorf("Invalid container ID %s", id)
+	}
+	port := job.GetenvInt("port")
+	if port <= 0 || port > 65535 {
+		return job.Errorf("Invalid page server port %d", port)
+	}
+
+	checkpointID := utils.GenerateRandomID()
+	imagePath := filepath.Join(daemon.checkpointRoot(id), "checkpoints", checkpointID)
+	if err := os.MkdirAll(imagePath, 0755); err != nil {
+		return job.Error(err)
+	}
+	if err := daemon.execDriver.ReceiveCheckpoint(imagePath, port); err != nil {
+		os.RemoveAll(imagePath)
+		return job.Errorf("Cannot receive checkpoint of container %s: %s", id, err)
+	}
+
+	out := &engine.Env{}
+	out.Set("Id", checkpointID)
+	out.SetInt("Port", port)
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// ContainerCheckpointList lists the checkpoints of a container, newest
+// first, along with the size of their images.
+func (daemon *Daemon) ContainerCheckpointList(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s CONTAINER", job.Name)
+	}
+	name := job.Args[0]
+	container := daemon.Get(name)
+	if container == nil {
+		return job.Errorf("No such container: %s", name)
+	}
+
+	cpFilters, err := filters.FromParam(job.Getenv("filters"))
+	if err != nil {
+		return job.Error(err)
+	}
+
+	container.Lock()
+	defer container.Unlock()
+
+	outs := engine.NewTable("", 0)
+	statuses := container.checkpointStatuses()
+	for i := len(statuses) - 1; i >= 0; i-- {
+		checkpoint := statuses[i]
+		if !checkpoint.matchLabels(cpFilters["label"]) {
+			continue
+		}
+		out := &engine.Env{}
+		out.Set("Id", checkpoint.ID)
+		out.Set("ImageID", checkpoint.ImageID)
+		out.SetAuto("CreatedAt", checkpoint.CreatedAt)
+		out.SetInt64("SizeBytes", checkpoint.SizeBytes)
+		out.SetJson("Labels", checkpoint.Labels)
+		out.Set("Description", checkpoint.Description)
+		out.SetJson("Stats", checkpoint.Stats)
+		out.SetJson("Origin", checkpoint.Origin)
+		out.SetBool("Valid", checkpoint.Valid)
+		out.Set("ValidationError", checkpoint.ValidationError)
+		outs.Add(out)
+	}
+	if _, err := outs.WriteListTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// ContainerCheckpointRename renames a checkpoint of a container.
+func (daemon *Daemon) ContainerCheckpointRename(job *engine.Job) engine.Status {
+	if len(job.Args) != 3 {
+		return job.Errorf("Usage: %s CONTAINER CHECKPOINT_ID NEW_NAME", job.Name)
+	}
+	name, checkpointID, newID := job.Args[0], job.Args[1], job.Args[2]
+	container := daemon.Get(name)
+	if container == nil {
+		return job.Errorf("No such container: %s", name)
+	}
+
+	container.Lock()
+	defer container.Unlock()
+	if err := container.renameCheckpoint(checkpointID, newID); err != nil {
+		return job.Errorf("Cannot rename checkpoint %s of container %s: %s", checkpointID, name, err)
+	}
+	if err := container.toDisk(); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// ContainerCheckpointDelete removes a checkpoint of a container along with
+// its image, e.g. to reclaim the disk space of a stale one.
+func (daemon *Daemon) ContainerCheckpointDelete(job *engine.Job) engine.Status {
+	if len(job.Args) != 2 {
+		return job.Errorf("Usage: %s CONTAINER CHECKPOINT_ID", job.Name)
+	}
+	name, checkpointID := job.Args[0], job.Args[1]
+	container := daemon.Get(name)
+	if container == nil {
+		return job.Errorf("No such container: %s", name)
+	}
+
+	container.Lock()
+	defer container.Unlock()
+	if err := container.deleteCheckpoint(checkpointID); err != nil {
+		return job.Errorf("Cannot delete checkpoint %s of container %s: %s", checkpointID, name, err)
+	}
+	if err := container.toDisk(); err != nil {
+		return job.Error(err)
+	}
+	container.LogEventWithAttributes("checkpoint-delete", map[string]string{
+		"checkpointId": checkpointID,
+	})
+	return engine.StatusOK
+}
+
+// ContainerCheckpointRestart checkpoints a container stopping it, and
+// immediately restores it from that checkpoint on this host. This is a warm
+// restart, the restored container keeps the memory state of the original.
+func (daemon *Daemon) ContainerCheckpointRestart(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s CONTAINER", job.Name)
+	}
+	name := job.Args[0]
+	container := daemon.Get(name)
+	if container == nil {
+		return job.Errorf("No such container: %s", name)
+	}
+	startedAt := time.Now()
+
+	checkpointJob := job.Eng.Job("checkpoint", name, "1")
+	checkpointJob.SetenvBool("snapshot", job.GetenvBool("snapshot"))
+	checkpointJob.SetenvList("labels", job.GetenvList("labels"))
+	checkpointJob.Setenv("description", job.Getenv("description"))
+	checkpointJob.SetenvBool("tcpEstablished", job.GetenvBool("tcpEstablished"))
+	checkpointJob.SetenvBool("shellJob", job.GetenvBool("shellJob"))
+	if job.EnvExists("fileLocks") {
+		checkpointJob.SetenvBool("fileLocks", job.GetenvBool("fileLocks"))
+	}
+	checkpointOut, err := checkpointJob.Stdout.AddEnv()
+	if err != nil {
+		return job.Error(err)
+	}
+	if err := checkpointJob.Run(); err != nil {
+		return job.Error(err)
+	}
+	checkpointID := checkpointOut.Get("Id")
+
+	// The checkpoint returns once criu killed the container, before its
+	// monitor marks it as stopped, which the restore would refuse.
+	if _, err := container.WaitStop(10 * time.Second); err != nil {
+		return job.Errorf("Cannot restart container %s from checkpoint %s: %s", name, checkpointID, err)
+	}
+
+	restoreJob := job.Eng.Job("restore", name, checkpointID)
+	restoreJob.Setenv("cgroupParent", job.Getenv("cgroupParent"))
+	restoreJob.SetenvBool("skipMemoryCheck", job.GetenvBool("skipMemoryCheck"))
+	restoreOut, err := restoreJob.Stdout.AddEnv()
+	if err != nil {
+		return job.Error(err)
+	}
+	if err := restoreJob.Run(); err != nil {
+		return job.Errorf("Cannot restart container %s from checkpoint %s: %s", name, checkpointID, err)
+	}
+
+	out := &engine.Env{}
+	out.Set("Id", restoreOut.Get("Id"))
+	out.Set("CheckpointId", checkpointID)
+	out.SetInt("Pid", restoreOut.GetInt("Pid"))
+	out.SetInt64("DurationMs", int64(time.Since(startedAt)/time.Millisecond))
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// ContainerCheckpointVerify tells whether a checkpoint can actually be
+// restored on this host, by restoring a throwaway clone of the container
+// from it into an empty network namespace and removing it right after. A
+// checkpoint which fails to restore is reported rather than failing the job,
+// along with the errors logged by criu.
+func (daemon *Daemon) ContainerCheckpointVerify(job *engine.Job) engine.Status {
+	if len(job.Args) != 2 {
+		return job.Errorf("Usage: %s CONTAINER CHECKPOINT_ID", job.Name)
+	}
+	name, checkpointID := job.Args[0], job.Args[1]
+	startedAt := time.Now()
+
+	restoreJob := job.Eng.Job("restore", name, checkpointID)
+	restoreJob.SetenvBool("clone", true)
+	restoreJob.SetenvBool("emptyNetNs", true)
+	restoreJob.SetenvBool("skipMemoryCheck", job.GetenvBool("skipMemoryCheck"))
+	restoreOut, err := restoreJob.Stdout.AddEnv()
+	if err != nil {
+		return job.Error(err)
+	}
+
+	out := &engine.Env{}
+	out.Set("CheckpointId", checkpointID)
+	if err := restoreJob.Run(); err != nil {
+		out.SetBool("Restorable", false)
+		out.Set("Error", err.Error())
+	} else {
+		id := restoreOut.Get("Id")
+		pid := 0
+		if clone := daemon.Get(id); clone != nil && clone.IsRunning() {
+			pid = clone.GetPid()
+		}
+		out.SetBool("Restorable", pid > 0)
+		out.SetInt("Pid", pid)
+		if pid <= 0 {
+			out.Set("Error", fmt.Sprintf("container %s restored from checkpoint %s is not running", id, checkpointID))
+		}
+
+		rmJob := job.Eng.Job("rm", id)
+		rmJob.SetenvBool("forceRemove", true)
+		rmJob.SetenvBool("removeVolume", true)
+		if err := rmJob.Run(); err != nil {
+			log.Warnf("failed to remove container %s restored to verify checkpoint %s: %s", id, checkpointID, err)
+		}
+	}
+	out.SetInt64("DurationMs", int64(time.Since(startedAt)/time.Millisecond))
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// checkpointCheck is the outcome of one of the checks of a checkpoint run
+// by ContainerCheckpointCheck.
+type checkpointCheck struct {
+	Name   string
+	Passed bool
+	Error  string `json:",omitempty"`
+}
+
+// checkRestore has criu go through the checkpoint without restoring it,
+// against the rootfs and volumes of the container as they are now rather
+// than the snapshot or image the checkpoint was taken with.
+func (container *Container) checkRestore(checkpoint *ContainerCheckpoint) error {
+	if !container.Running {
+		if err := container.Mount(); err != nil {
+			return err
+		}
+		defer container.Unmount()
+		if err := populateCommand(container, nil); err != nil {
+			return err
+		}
+		if err := container.setupMounts(); err != nil {
+			return err
+		}
+	}
+	return container.daemon.execDriver.CheckRestore(checkpoint.execdriverCheckpoint())
+}
+
+// ContainerCheckpointCheck checks that a checkpoint is restorable, more
+// thoroughly than its listing does but without restoring it like
+// ContainerCheckpointVerify: the image files are checked, a sample of the
+// memory pages is checked against the pagemaps and the page store, and
+// criu restore is run with --check-only. The report lists the outcome of
+// each of them.
+func (daemon *Daemon) ContainerCheckpointCheck(job *engine.Job) engine.Status {
+	if len(job.Args) != 2 {
+		return job.Errorf("Usage: %s CONTAINER CHECKPOINT_ID", job.Name)
+	}
+	name, checkpointID := job.Args[0], job.Args[1]
+	container := daemon.Get(name)
+	if container == nil {
+		return job.Errorf("No such container: %s", name)
+	}
+	startedAt := time.Now()
+
+	container.Lock()
+	defer container.Unlock()
+	checkpoint := container.Checkpoints[checkpointID]
+	if checkpoint == nil {
+		return job.Errorf("No such checkpoint %s for container %s", checkpointID, name)
+	}
+
+	checks := []struct {
+		name  string
+		check func() error
+	}{
+		{"image", checkpoint.validateImage},
+		{"pages", checkpoint.checkPages},
+		{"criu", func() error { return container.checkRestore(checkpoint) }},
+	}
+	restorable := true
+	report := make([]*checkpointCheck, 0, len(checks))
+	for _, c := range checks {
+		result := &checkpointCheck{Name: c.name, Passed: true}
+		// criu would only fail obscurely on an incomplete image
+		if restorable || c.name != "criu" {
+			if err := c.check(); err != nil {
+				result.Passed = false
+				result.Error = err.Error()
+			}
+		} else {
+			result.Passed = false
+			result.Error = "skipped, the image is not restorable"
+		}
+		restorable = restorable && result.Passed
+		report = append(report, result)
+	}
+
+	out := &engine.Env{}
+	out.Set("CheckpointId", checkpointID)
+	out.SetBool("Restorable", restorable)
+	out.SetJson("Checks", report)
+	out.SetInt64("DurationMs", int64(time.Since(startedAt)/time.Millisecond))
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
 	return engine.StatusOK
 }
 
@@ -146,6 +1283,57 @@ func (daemon *Daemon) cloneContainer(container *Container, imgID string) (*Conta
 	return clonedContainer, nil
 }
 
+// forkContainer creates a new container, with its own ID and name, to
+// restore a checkpoint of container into without touching container. It
+// is lighter than cloneContainer: the image lookup and config merging of
+// daemon.Create are skipped, the config being the one the checkpoint was
+// taken with, and the volumes of container are shared rather than created
+// afresh, the restored processes expecting to find their files there.
+func (daemon *Daemon) forkContainer(container *Container, imgID, name string) (*Container, error) {
+	container.Lock()
+	configCopy := *container.Config
+	configCopy.Image = imgID
+	configCopy.MacAddress = ""
+	hostConfigCopy := *container.hostConfig
+	volumes := make(map[string]string, len(container.Volumes))
+	volumesRW := make(map[string]bool, len(container.VolumesRW))
+	for path, hostPath := range container.Volumes {
+		volumes[path] = hostPath
+		volumesRW[path] = container.VolumesRW[path]
+	}
+	container.Unlock()
+
+	forked, err := daemon.newContainer(name, &configCopy, imgID)
+	if err != nil {
+		return nil, err
+	}
+	forked.Volumes = volumes
+	forked.VolumesRW = volumesRW
+	if err := daemon.Register(forked); err != nil {
+		// Release the name reserved by newContainer
+		daemon.containerGraph.Purge(forked.ID)
+		return nil, err
+	}
+	// Don't leave a half built fork registered, with its name taken
+	destroy := func(err error) (*Container, error) {
+		if err := daemon.Destroy(forked); err != nil {
+			log.Warnf("failed to remove fork %s of %s: %s", forked.ID, container.ID, err)
+		}
+		return nil, err
+	}
+	if err := daemon.createRootfs(forked); err != nil {
+		return destroy(fmt.Errorf("Failed to create rootfs of fork of %s: %s", container.ID, err))
+	}
+	if err := daemon.setHostConfig(forked, &hostConfigCopy); err != nil {
+		return destroy(err)
+	}
+	forked.registerVolumes()
+	if err := forked.ToDisk(); err != nil {
+		return destroy(err)
+	}
+	return forked, nil
+}
+
 func (daemon *Daemon) ContainerRestore(job *engine.Job) engine.Status {
 	if len(job.Args) != 2 {
 		return job.Errorf("Usage: %s CONTAINER CHECKPOINT_ID", job.Name)
@@ -162,24 +1350,155 @@ func (daemon *Daemon) ContainerRestore(job *engine.Job) engine.Status {
 	if checkpoint == nil {
 		return job.Errorf("No such checkpoint %s for container %s", checkpointID, container.ID)
 	}
+	if checkpoint.PreDump {
+		return job.Errorf("Cannot restore container %s: checkpoint %s is a pre-dump of the memory, take a checkpoint on top of it to restore", name, checkpointID)
+	}
+	checkpoint.startRestoring()
+	defer checkpoint.doneRestoring()
+
+	// A fork is a clone restored into a lighter container shell
+	fork := job.GetenvBool("fork")
+	clone := job.GetenvBool("clone") || fork
+	if job.Getenv("name") != "" && !fork {
+		return job.Errorf("Cannot restore container %s: a name can only be given to a fork", name)
+	}
+	cloneStrategy := job.Getenv("cloneStrategy")
+	if cloneStrategy == "" {
+		cloneStrategy = daemon.config.CheckpointCloneStrategy
+	}
+	if err := validateCloneStrategy(cloneStrategy); err != nil {
+		return job.Errorf("Cannot restore container %s: %s", name, err)
+	}
+
+	if job.GetenvBool("natNetwork") && (clone || job.GetenvBool("emptyNetNs") || checkpoint.skipsNamespace("net")) {
+		return job.Errorf("Cannot restore container %s: NAT networking conflicts with cloning and empty network namespace", name)
+	}
+	if checkpoint.TcpEstablished && (clone || job.GetenvBool("emptyNetNs")) {
+		return job.Errorf("Cannot restore container %s: checkpoint %s has established TCP connections, which can only be restored with the container's own address", name, checkpointID)
+	}
+
+	// Restoring the container's identity while it is still running would
+	// leave two instances of it fighting over its address.
+	if container.IsRunning() && !clone && !job.GetenvBool("force") {
+		return job.Errorf("Cannot restore container %s: it is running, stop it first or force the restore", name)
+	}
 
-	containerClone, err := daemon.cloneContainer(container, checkpoint.ImageID)
+	entrypoint := job.GetenvList("entrypoint")
+	if len(entrypoint) > 0 && job.GetenvBool("natNetwork") {
+		return job.Errorf("Cannot restore container %s: NAT networking conflicts with running a new entrypoint", name)
+	}
+
+	// A new entrypoint only needs the rootfs of the checkpoint
+	if len(entrypoint) == 0 {
+		if err := checkpoint.validateImage(); err != nil {
+			return job.Errorf("Cannot restore container %s: checkpoint %s is incomplete: %s", name, checkpointID, err)
+		}
+	}
+
+	// There is no memory to restore when running a new entrypoint
+	if err := checkpoint.checkAvailableMemory(); err != nil && len(entrypoint) == 0 {
+		if !job.GetenvBool("skipMemoryCheck") {
+			return job.Errorf("Cannot restore container %s: %s, skip the check to restore anyway", name, err)
+		}
+		log.Warnf("Restoring container %s: %s", name, err)
+	}
+
+	// Only stopped once nothing is left to refuse the restore, not to
+	// leave it stopped for nothing
+	if container.IsRunning() && !clone {
+		if err := container.Stop(10); err != nil {
+			return job.Errorf("Cannot stop running container %s to restore it: %s", name, err)
+		}
+	}
+
+	startedAt := time.Now()
+	container.LogEventWithAttributes("restore-start", map[string]string{
+		"checkpointId": checkpointID,
+	})
+	restoreFailed := func(err error) {
+		attributes := map[string]string{
+			"checkpointId": checkpointID,
+			"error":        err.Error(),
+		}
+		if checkpoint.Origin != nil {
+			attributes["originHost"] = checkpoint.Origin.Hostname
+			attributes["originKernel"] = checkpoint.Origin.KernelVersion
+		}
+		container.LogEventWithAttributes("restore-failed", attributes)
+	}
+
+	var (
+		containerClone *Container
+		err            error
+	)
+	if fork {
+		containerClone, err = daemon.forkContainer(container, checkpoint.ImageID, job.Getenv("name"))
+	} else {
+		containerClone, err = daemon.cloneContainer(container, checkpoint.ImageID)
+	}
 	if err != nil {
+		restoreFailed(err)
 		return job.Error(err)
 	}
 	log.Infof("cloned container ID=%s", containerClone.ID)
+	// Don't leave the container behind if it can't be restored into
+	cloneFailed := func(err error) {
+		restoreFailed(err)
+		if err := daemon.Destroy(containerClone); err != nil {
+			log.Warnf("failed to remove container %s which failed to be restored: %s", containerClone.ID, err)
+		}
+	}
+
+	if checkpoint.SnapshotID != "" {
+		if err := checkpoint.restoreSnapshot(containerClone); err != nil {
+			cloneFailed(err)
+			return job.Errorf("Cannot restore rootfs snapshot %s: %s", checkpoint.SnapshotID, err)
+		}
+	}
 
-	checkpoint, err = checkpoint.clone(containerClone)
-	// defer checkpoint.cleanFiles()
+	checkpoint, err = checkpoint.clone(containerClone, cloneStrategy)
 	if err != nil {
+		cloneFailed(err)
 		return job.Error(err)
 	}
 
-	if err := containerClone.Restore(checkpoint, job.GetenvBool("clone")); err != nil {
+	opts := &RestoreOptions{
+		Clone:        clone,
+		CgroupParent: job.Getenv("cgroupParent"),
+		EmptyNetNs:   job.GetenvBool("emptyNetNs") || checkpoint.skipsNamespace("net"),
+		NatNetwork:   job.GetenvBool("natNetwork"),
+		Entrypoint:   entrypoint,
+		Attached:     job.GetenvBool("attached"),
+	}
+	if err := containerClone.Restore(checkpoint, opts); err != nil {
+		cloneFailed(err)
+		if checkpoint.Origin != nil {
+			return job.Errorf("Cannot restore container %s from checkpoint taken on %s: %s", name, checkpoint.Origin, err)
+		}
 		return job.Errorf("Cannot restore container %s: %s", name, err)
 	}
-	containerClone.LogEvent("restore")
-	job.Printf("%s\n", containerClone.ID)
+	// criu resumes the processes it restores, pause them again the way
+	// they were dumped, unless a new entrypoint was started instead
+	if checkpoint.Paused && len(entrypoint) == 0 {
+		if err := containerClone.Pause(); err != nil {
+			cloneFailed(err)
+			return job.Errorf("Cannot pause container %s restored from paused checkpoint %s: %s", name, checkpointID, err)
+		}
+		containerClone.LogEvent("pause")
+	}
+	duration := time.Since(startedAt)
+	attributes := checkpointEventAttributes(checkpoint, duration)
+	attributes["source"] = container.ID
+	containerClone.LogEventWithAttributes("restore", attributes)
+
+	out := &engine.Env{}
+	out.Set("Id", containerClone.ID)
+	out.SetInt("Pid", containerClone.GetPid())
+	out.SetInt64("DurationMs", int64(duration/time.Millisecond))
+	out.SetInt64("ImageSize", checkpoint.imageSize())
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
 
 	return engine.StatusOK
 }
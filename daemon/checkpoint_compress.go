@@ -0,0 +1,100 @@
+package daemon
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Memory pages make up most of a checkpoint image, and being mostly zeroes,
+// code and text, they compress well. The pages-*.img files can be gzipped
+// after the dump, to save disk and time transferring the checkpoint, and
+// are decompressed when the checkpoint is cloned for restoring, like the
+// deduplicated ones are assembled. The other images are small and are read
+// as is, by patch-criu and for the statistics, so they are left alone.
+
+const compressedPagesExtension = ".gz"
+
+// compressPages gzips the memory pages of the checkpoint, recording how
+// large they are uncompressed for the memory check before restoring.
+func (cp *ContainerCheckpoint) compressPages() error {
+	imagePath := cp.imagePath()
+	dirents, err := ioutil.ReadDir(imagePath)
+	if err != nil {
+		return err
+	}
+	for _, fi := range dirents {
+		if !fi.Mode().IsRegular() || !isPagesImage(fi.Name()) {
+			continue
+		}
+		pagesPath := filepath.Join(imagePath, fi.Name())
+		if err := compressFile(pagesPath, pagesPath+compressedPagesExtension); err != nil {
+			return fmt.Errorf("failed to compress %s: %s", pagesPath, err)
+		}
+		if err := os.Remove(pagesPath); err != nil {
+			return err
+		}
+		cp.UncompressedPagesSize += fi.Size()
+	}
+	return nil
+}
+
+func isCompressedPagesImage(name string) bool {
+	return strings.HasSuffix(name, compressedPagesExtension) && isPagesImage(strings.TrimSuffix(name, compressedPagesExtension))
+}
+
+func compressFile(src, dest string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(dest)
+		}
+	}()
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func decompressFile(src, dest string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	zr, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(dest)
+		}
+	}()
+	_, err = io.Copy(out, zr)
+	return err
+}
@@ -0,0 +1,342 @@
+package daemon
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Memory pages of checkpoints dumped from the same container, or of clones
+// forked from the same checkpoint, are mostly identical. Instead of keeping
+// the pages-*.img files of each checkpoint, they can be split into chunks
+// which are stored once in a content addressed page store per container,
+// leaving only a manifest of the chunks in the checkpoint image. The pages
+// files are assembled back from the store when the checkpoint is cloned
+// for restoring.
+
+const (
+	pageChunkSize         = 64 * 4096
+	pageManifestExtension = ".chunks"
+)
+
+func (container *Container) pageStorePath() string {
+	return filepath.Join(container.checkpointRoot(), "checkpoint-pages")
+}
+
+func isPagesImage(name string) bool {
+	return strings.HasPrefix(name, "pages-") && strings.HasSuffix(name, ".img")
+}
+
+// dedupPages moves the memory pages of the checkpoint into the page store.
+func (cp *ContainerCheckpoint) dedupPages() error {
+	storePath := cp.container.pageStorePath()
+	if err := os.MkdirAll(storePath, 0700); err != nil {
+		return err
+	}
+	imagePath := cp.imagePath()
+	dirents, err := ioutil.ReadDir(imagePath)
+	if err != nil {
+		return err
+	}
+	for _, fi := range dirents {
+		if !fi.Mode().IsRegular() || !isPagesImage(fi.Name()) {
+			continue
+		}
+		pagesPath := filepath.Join(imagePath, fi.Name())
+		if err := storePages(storePath, pagesPath, pagesPath+pageManifestExtension); err != nil {
+			return fmt.Errorf("failed to store pages of %s: %s", pagesPath, err)
+		}
+		if err := os.Remove(pagesPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pagesImageSize returns the size in bytes of the pages-*.img files of the
+// checkpoint image on disk, compressed or not.
+func (cp *ContainerCheckpoint) pagesImageSize() int64 {
+	dirents, err := ioutil.ReadDir(cp.imagePath())
+	if err != nil {
+		log.Warnf("failed to get size of the pages of checkpoint image %s: %s", cp.imagePath(), err)
+		return -1
+	}
+	var size int64
+	for _, fi := range dirents {
+		if name := fi.Name(); isPagesImage(name) || isCompressedPagesImage(name) {
+			size += fi.Size()
+		}
+	}
+	return size
+}
+
+// pagesSize returns the size of the memory pages stored in the checkpoint,
+// counting the pages of the checkpoints it's incremental to as well. As
+// later dumps may replace pages of earlier ones, it's an upper bound of the
+// memory the restored process is going to use.
+func (cp *ContainerCheckpoint) pagesSize() (int64, error) {
+	var size int64
+	for c := cp; c != nil; c = c.container.Checkpoints[c.ParentID] {
+		dirents, err := ioutil.ReadDir(c.imagePath())
+		if err != nil {
+			return -1, err
+		}
+		if c.Compressed {
+			size += c.UncompressedPagesSize
+		}
+		for _, fi := range dirents {
+			name := fi.Name()
+			if isPagesImage(name) {
+				size += fi.Size()
+			} else if isPagesImage(strings.TrimSuffix(name, pageManifestExtension)) {
+				// a line of hex encoded sha256 per chunk
+				size += fi.Size() / (sha256.Size*2 + 1) * pageChunkSize
+			}
+		}
+	}
+	return size, nil
+}
+
+// storePages splits the pages file into chunks, stores the ones which
+// aren't in the store yet and writes the list of chunks to manifestPath.
+func storePages(storePath, pagesPath, manifestPath string) error {
+	pages, err := os.Open(pagesPath)
+	if err != nil {
+		return err
+	}
+	defer pages.Close()
+
+	manifest, err := os.Create(manifestPath)
+	if err != nil {
+		return err
+	}
+	defer manifest.Close()
+
+	buf := make([]byte, pageChunkSize)
+	for {
+		n, err := io.ReadFull(pages, buf)
+		if err == io.EOF {
+			break
+		} else if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		chunk := buf[:n]
+		sum := sha256.Sum256(chunk)
+		hash := hex.EncodeToString(sum[:])
+
+		chunkPath := filepath.Join(storePath, hash)
+		if _, err := os.Stat(chunkPath); os.IsNotExist(err) {
+			// Write to a temporary file first so that a partially
+			// written chunk is never seen under its hash.
+			if err := ioutil.WriteFile(chunkPath+".tmp", chunk, 0600); err != nil {
+				return err
+			}
+			if err := os.Rename(chunkPath+".tmp", chunkPath); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(manifest, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assemblePages writes the pages file listed by the manifest at
+// manifestPath to pagesPath, reading the chunks from the page store.
+func assemblePages(storePath, manifestPath, pagesPath string) error {
+	manifest, err := os.Open(manifestPath)
+	if err != nil {
+		return err
+	}
+	defer manifest.Close()
+
+	pages, err := os.Create(pagesPath)
+	if err != nil {
+		return err
+	}
+	defer pages.Close()
+
+	scanner := bufio.NewScanner(manifest)
+	for scanner.Scan() {
+		chunk, err := os.Open(filepath.Join(storePath, scanner.Text()))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(pages, chunk)
+		chunk.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// gcPageStore removes the chunks which are no longer referenced by any
+// checkpoint of the container.
+func (container *Container) gcPageStore() error {
+	storePath := container.pageStorePath()
+	if _, err := os.Stat(storePath); os.IsNotExist(err) {
+		return nil
+	}
+
+	referenced := make(map[string]bool)
+	for _, checkpoint := range container.Checkpoints {
+		if !checkpoint.DedupPages {
+			continue
+		}
+		manifests, err := filepath.Glob(filepath.Join(checkpoint.imagePath(), "pages-*.img"+pageManifestExtension))
+		if err != nil {
+			return err
+		}
+		for _, manifestPath := range manifests {
+			data, err := ioutil.ReadFile(manifestPath)
+			if err != nil {
+				return err
+			}
+			for _, hash := range strings.Fields(string(data)) {
+				referenced[hash] = true
+			}
+		}
+	}
+
+	chunks, err := ioutil.ReadDir(storePath)
+	if err != nil {
+		return err
+	}
+	for _, fi := range chunks {
+		if !referenced[fi.Name()] {
+			if err := os.Remove(filepath.Join(storePath, fi.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Number of pagemaps, and of chunks of each deduplicated pages file,
+// checkPages looks into.
+const pageCheckSamples = 4
+
+// sample returns up to n of items, spread over them.
+func sample(items []string, n int) []string {
+	if len(items) <= n {
+		return items
+	}
+	sampled := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		sampled = append(sampled, items[i*len(items)/n])
+	}
+	return sampled
+}
+
+// checkPages checks the memory pages of a sample of the processes of the
+// checkpoint: their pages file has to hold as many pages as their pagemap
+// lists, and if it was deduplicated, the chunks it's made of have to match
+// their hashes. This catches truncated transfers and corrupted page stores
+// without reading all the pages.
+func (cp *ContainerCheckpoint) checkPages() error {
+	pagemaps, err := filepath.Glob(filepath.Join(cp.imagePath(), "pagemap-*.img"))
+	if err != nil {
+		return err
+	}
+	if len(pagemaps) == 0 {
+		return fmt.Errorf("no pagemap in the checkpoint image")
+	}
+	for _, pagemap := range sample(pagemaps, pageCheckSamples) {
+		if err := cp.checkPagemap(pagemap); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cp *ContainerCheckpoint) checkPagemap(path string) error {
+	entries, err := readCriuImage(path)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("%s has no header", filepath.Base(path))
+	}
+	// pagemap_head, then a pagemap_entry per range of pages, the ones
+	// unchanged since the parent checkpoint being in its pages file
+	head, _, err := decodeProtoFields(entries[0])
+	if err != nil {
+		return fmt.Errorf("malformed header of %s: %s", filepath.Base(path), err)
+	}
+	var nrPages uint64
+	for _, entry := range entries[1:] {
+		fields, _, err := decodeProtoFields(entry)
+		if err != nil {
+			return fmt.Errorf("malformed entry of %s: %s", filepath.Base(path), err)
+		}
+		if fields[3] == 0 {
+			nrPages += fields[2]
+		}
+	}
+	size := int64(nrPages) * int64(os.Getpagesize())
+
+	pagesPath := filepath.Join(cp.imagePath(), fmt.Sprintf("pages-%d.img", head[1]))
+	fi, err := os.Stat(pagesPath)
+	if err == nil {
+		if fi.Size() != size {
+			return fmt.Errorf("%s holds %d bytes of pages, %s lists %d", filepath.Base(pagesPath), fi.Size(), filepath.Base(path), size)
+		}
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	// Checking the size of compressed pages would mean reading them all
+	if cp.Compressed {
+		if _, err := os.Stat(pagesPath + compressedPagesExtension); err == nil {
+			return nil
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if _, err := os.Stat(pagesPath + pageManifestExtension); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s is missing from the checkpoint image", filepath.Base(pagesPath))
+		}
+		return err
+	}
+	return checkPageChunks(cp.container.pageStorePath(), pagesPath+pageManifestExtension, size)
+}
+
+// checkPageChunks checks that the manifest at manifestPath lists as many
+// chunks as a pages file of size bytes is split into, and that a sample of
+// them are in the page store with the right content.
+func checkPageChunks(storePath, manifestPath string, size int64) error {
+	data, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return err
+	}
+	hashes := strings.Fields(string(data))
+	if expected := int((size + pageChunkSize - 1) / pageChunkSize); len(hashes) != expected {
+		return fmt.Errorf("%s lists %d chunks of pages, expected %d", filepath.Base(manifestPath), len(hashes), expected)
+	}
+	for _, hash := range sample(hashes, pageCheckSamples) {
+		chunk, err := ioutil.ReadFile(filepath.Join(storePath, hash))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("chunk %s of %s is missing from the page store", hash, filepath.Base(manifestPath))
+			}
+			return err
+		}
+		if sum := sha256.Sum256(chunk); hex.EncodeToString(sum[:]) != hash {
+			return fmt.Errorf("chunk %s of %s is corrupted", hash, filepath.Base(manifestPath))
+		}
+	}
+	return nil
+}
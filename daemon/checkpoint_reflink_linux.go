@@ -0,0 +1,52 @@
+// +build linux
+
+package daemon
+
+import (
+	"os"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// FICLONE from linux/fs.h, formerly BTRFS_IOC_CLONE
+const ioctlFiClone = 0x40049409
+
+// reflinkFile creates dest as a copy-on-write clone of src, sharing its
+// blocks until either is written to. It falls back to copying src if the
+// filesystem doesn't support it, or if dest is on another one.
+func reflinkFile(src, dest string) error {
+	sf, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sf.Close()
+	fi, err := sf.Stat()
+	if err != nil {
+		return err
+	}
+	df, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode())
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, df.Fd(), ioctlFiClone, sf.Fd())
+	if errno == 0 {
+		// The mode given to OpenFile is masked by the umask
+		err := df.Chmod(fi.Mode().Perm())
+		df.Close()
+		if err != nil {
+			os.Remove(dest)
+		}
+		return err
+	}
+	df.Close()
+	if errno != syscall.EOPNOTSUPP && errno != syscall.ENOTTY && errno != syscall.EXDEV && errno != syscall.EINVAL {
+		os.Remove(dest)
+		return errno
+	}
+	log.Debugf("cannot reflink %s to %s, copying it: %s", src, dest, errno)
+	if err := os.Remove(dest); err != nil {
+		return err
+	}
+	return copyImageFile(src, dest)
+}
@@ -0,0 +1,9 @@
+// +build !linux
+
+package daemon
+
+// reflinkFile copies src to dest, copy-on-write clones being only
+// supported on linux.
+func reflinkFile(src, dest string) error {
+	return copyImageFile(src, dest)
+}
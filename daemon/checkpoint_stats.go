@@ -0,0 +1,177 @@
+package daemon
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// criu images start with a magic, preceded by the one of their class for
+// images other than the legacy ones, and hold a sequence of protobuf
+// encoded entries each prefixed by its size.
+const (
+	criuImgCommonMagic  = 0x54564319
+	criuImgServiceMagic = 0x55105940
+)
+
+// Images holding one entry per socket of the checkpointed processes.
+var socketImages = []string{"inetsk.img", "unixsk.img", "packetsk.img", "netlinksk.img"}
+
+// CheckpointStats summarizes what a checkpoint holds, from the statistics
+// criu dump leaves in the image and the entries of the other images.
+type CheckpointStats struct {
+	FreezingTime       time.Duration // time taken to freeze the processes
+	FrozenTime         time.Duration // time the processes were frozen for
+	MemdumpTime        time.Duration
+	MemwriteTime       time.Duration
+	PagesScanned       uint64
+	PagesSkippedParent uint64 // pages unchanged since the parent checkpoint, if incremental
+	PagesWritten       uint64
+	OpenFiles          int // file descriptors of all the processes
+	Sockets            int
+}
+
+// readCriuImage returns the entries of the criu image file at path.
+func readCriuImage(path string) ([][]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 {
+		return nil, fmt.Errorf("%s is too short to be a criu image", path)
+	}
+	switch binary.LittleEndian.Uint32(data) {
+	case criuImgCommonMagic, criuImgServiceMagic:
+		if len(data) < 8 {
+			return nil, fmt.Errorf("%s is too short to be a criu image", path)
+		}
+		data = data[8:]
+	default:
+		data = data[4:]
+	}
+
+	var entries [][]byte
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("truncated entry in %s", path)
+		}
+		size := binary.LittleEndian.Uint32(data)
+		data = data[4:]
+		if uint32(len(data)) < size {
+			return nil, fmt.Errorf("truncated entry in %s", path)
+		}
+		entries = append(entries, data[:size])
+		data = data[size:]
+	}
+	return entries, nil
+}
+
+// decodeProtoFields decodes the fields of a protobuf message, returning the
+// varint ones and the length delimited ones keyed by their field number.
+func decodeProtoFields(msg []byte) (map[int]uint64, map[int][]byte, error) {
+	varints := make(map[int]uint64)
+	delimited := make(map[int][]byte)
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return nil, nil, fmt.Errorf("malformed protobuf field key")
+		}
+		msg = msg[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			value, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return nil, nil, fmt.Errorf("malformed varint of field %d", field)
+			}
+			varints[field] = value
+			msg = msg[n:]
+		case 1:
+			if len(msg) < 8 {
+				return nil, nil, fmt.Errorf("truncated field %d", field)
+			}
+			msg = msg[8:]
+		case 2:
+			size, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < size {
+				return nil, nil, fmt.Errorf("truncated field %d", field)
+			}
+			delimited[field] = msg[n : n+int(size)]
+			msg = msg[n+int(size):]
+		case 5:
+			if len(msg) < 4 {
+				return nil, nil, fmt.Errorf("truncated field %d", field)
+			}
+			msg = msg[4:]
+		default:
+			return nil, nil, fmt.Errorf("unsupported wire type %d of field %d", key&7, field)
+		}
+	}
+	return varints, delimited, nil
+}
+
+// countCriuEntries returns the number of entries in the criu images at the
+// given paths, ignoring the ones which don't exist.
+func countCriuEntries(paths ...string) (int, error) {
+	count := 0
+	for _, path := range paths {
+		entries, err := readCriuImage(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return 0, err
+		}
+		count += len(entries)
+	}
+	return count, nil
+}
+
+// collectStats reads the statistics of the checkpoint from its image.
+func (cp *ContainerCheckpoint) collectStats() (*CheckpointStats, error) {
+	imagePath := cp.imagePath()
+	entries, err := readCriuImage(filepath.Join(imagePath, "stats-dump"))
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) != 1 {
+		return nil, fmt.Errorf("expected one entry in stats-dump, got %d", len(entries))
+	}
+	// stats_entry holds a dump_stats_entry as its first field
+	_, messages, err := decodeProtoFields(entries[0])
+	if err != nil {
+		return nil, err
+	}
+	dump, _, err := decodeProtoFields(messages[1])
+	if err != nil {
+		return nil, err
+	}
+	stats := &CheckpointStats{
+		FreezingTime:       time.Duration(dump[1]) * time.Microsecond,
+		FrozenTime:         time.Duration(dump[2]) * time.Microsecond,
+		MemdumpTime:        time.Duration(dump[3]) * time.Microsecond,
+		MemwriteTime:       time.Duration(dump[4]) * time.Microsecond,
+		PagesScanned:       dump[5],
+		PagesSkippedParent: dump[6],
+		PagesWritten:       dump[7],
+	}
+
+	names, err := filepath.Glob(filepath.Join(imagePath, "fdinfo-*.img"))
+	if err != nil {
+		return nil, err
+	}
+	if stats.OpenFiles, err = countCriuEntries(names...); err != nil {
+		return nil, err
+	}
+	names = names[:0]
+	for _, name := range socketImages {
+		names = append(names, filepath.Join(imagePath, name))
+	}
+	if stats.Sockets, err = countCriuEntries(names...); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,165 @@
+package daemon
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// criu streams memory pages to the page server as fast as the link allows,
+// which saturates it for the other traffic on a shared network. To cap the
+// bandwidth of a migration, criu is pointed at a local proxy instead, which
+// forwards the stream to the page server through a token bucket.
+
+// tokenBucket lets through at most rate bytes per second, in bursts of at
+// most a second's worth. It can be shared by several writers to cap their
+// total bandwidth.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   int64
+	tokens int64
+	last   time.Time
+	now    func() time.Time
+	sleep  func(time.Duration)
+}
+
+func newTokenBucket(rate int64) *tokenBucket {
+	return &tokenBucket{
+		rate:  rate,
+		last:  time.Now(),
+		now:   time.Now,
+		sleep: time.Sleep,
+	}
+}
+
+// take waits for tokens to be available and takes up to max of them,
+// returning how many it took.
+func (b *tokenBucket) take(max int64) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		now := b.now()
+		// No more than a second's worth fits in the bucket anyway, and
+		// a longer idle time times the rate would overflow.
+		elapsed := now.Sub(b.last)
+		if elapsed > time.Second {
+			elapsed = time.Second
+		}
+		b.tokens += int64(elapsed) * b.rate / int64(time.Second)
+		if b.tokens > b.rate {
+			b.tokens = b.rate
+		}
+		b.last = now
+		if b.tokens > 0 {
+			break
+		}
+		// wait for a tenth of a second's worth rather than byte by byte
+		b.sleep(time.Duration(b.rate/10+1-b.tokens) * time.Second / time.Duration(b.rate))
+	}
+	if max > b.tokens {
+		max = b.tokens
+	}
+	b.tokens -= max
+	return max
+}
+
+// rateLimitedWriter writes to w no faster than bucket lets it.
+type rateLimitedWriter struct {
+	w      io.Writer
+	bucket *tokenBucket
+}
+
+func (l *rateLimitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n, err := l.w.Write(p[written : written+int(l.bucket.take(int64(len(p)-written)))])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// throttledProxy forwards the connections it accepts on the loopback to
+// target, limiting what is sent to target to rate bytes per second over
+// all of them.
+type throttledProxy struct {
+	listener net.Listener
+	target   string
+	bucket   *tokenBucket
+	wg       sync.WaitGroup
+}
+
+func newThrottledProxy(target string, rate int64) (*throttledProxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p := &throttledProxy{
+		listener: listener,
+		target:   target,
+		bucket:   newTokenBucket(rate),
+	}
+	p.wg.Add(1)
+	go p.serve()
+	return p, nil
+}
+
+// Addr returns the host:port to connect to instead of target.
+func (p *throttledProxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// Close stops accepting connections and waits for the forwarded ones to
+// be done.
+func (p *throttledProxy) Close() error {
+	err := p.listener.Close()
+	p.wg.Wait()
+	return err
+}
+
+func (p *throttledProxy) serve() {
+	defer p.wg.Done()
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			if err := p.forward(conn); err != nil {
+				log.Errorf("failed to forward to %s: %s", p.target, err)
+			}
+		}()
+	}
+}
+
+func (p *throttledProxy) forward(conn net.Conn) error {
+	defer conn.Close()
+	remote, err := net.Dial("tcp", p.target)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+
+	// The page server hardly talks back, no need to throttle that way
+	replied := make(chan struct{})
+	go func() {
+		io.Copy(conn, remote)
+		close(replied)
+	}()
+	if _, err := io.Copy(&rateLimitedWriter{remote, p.bucket}, conn); err != nil {
+		return err
+	}
+	// Let the page server acknowledge the end of the stream
+	if tcp, ok := remote.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+	}
+	<-replied
+	return nil
+}
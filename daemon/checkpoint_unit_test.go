@@ -0,0 +1,1160 @@
+package daemon
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/execdriver"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/runconfig"
+)
+
+func TestCheckpointMatchLabels(t *testing.T) {
+	checkpoint := &ContainerCheckpoint{
+		Labels: map[string]string{
+			"stage": "pre-upgrade",
+			"owner": "",
+		},
+	}
+	for _, labels := range [][]string{
+		nil,
+		{"stage"},
+		{"stage=pre-upgrade"},
+		{"stage", "owner="},
+	} {
+		if !checkpoint.matchLabels(labels) {
+			t.Fatalf("expected checkpoint to match %v", labels)
+		}
+	}
+	for _, labels := range [][]string{
+		{"host"},
+		{"stage=post-upgrade"},
+		{"stage=pre-upgrade", "owner=me"},
+	} {
+		if checkpoint.matchLabels(labels) {
+			t.Fatalf("expected checkpoint not to match %v", labels)
+		}
+	}
+}
+
+func TestDeleteCheckpointWithChildren(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-checkpoint-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	container := &Container{
+		root:        root,
+		Checkpoints: make(map[string]*ContainerCheckpoint),
+	}
+	container.Checkpoints["parent"] = &ContainerCheckpoint{ID: "parent", container: container}
+	container.Checkpoints["child"] = &ContainerCheckpoint{ID: "child", ParentID: "parent", container: container}
+
+	if err := container.deleteCheckpoint("parent"); err == nil {
+		t.Fatal("expected deleting a checkpoint with children to fail")
+	}
+	if err := container.deleteCheckpoint("child"); err != nil {
+		t.Fatal(err)
+	}
+	if err := container.deleteCheckpoint("parent"); err != nil {
+		t.Fatal(err)
+	}
+	if len(container.Checkpoints) != 0 {
+		t.Fatalf("expected all checkpoints to be deleted, %d left", len(container.Checkpoints))
+	}
+}
+
+func TestDeleteCheckpointBeingRestored(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-checkpoint-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	container := &Container{
+		State:       NewState(),
+		root:        root,
+		Checkpoints: make(map[string]*ContainerCheckpoint),
+	}
+	checkpoint := &ContainerCheckpoint{ID: "checkpoint", container: container}
+	container.Checkpoints[checkpoint.ID] = checkpoint
+
+	checkpoint.startRestoring()
+	if err := container.deleteCheckpoint("checkpoint"); err == nil {
+		t.Fatal("expected deleting a checkpoint being restored to fail")
+	}
+	checkpoint.doneRestoring()
+	if err := container.deleteCheckpoint("checkpoint"); err != nil {
+		t.Fatal(err)
+	}
+	if err := container.deleteCheckpoint("checkpoint"); err == nil {
+		t.Fatal("expected deleting a missing checkpoint to fail")
+	}
+}
+
+func TestCheckpointPagesRoundTrip(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-checkpoint-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	container := &Container{
+		root:        root,
+		Checkpoints: make(map[string]*ContainerCheckpoint),
+	}
+	// Two chunks which are the same and a short trailing one
+	pages := append(bytes.Repeat([]byte{0xaa}, 2*pageChunkSize), []byte("tail")...)
+	for _, id := range []string{"first", "second"} {
+		checkpoint := &ContainerCheckpoint{ID: id, DedupPages: true, container: container}
+		container.Checkpoints[id] = checkpoint
+		if err := os.MkdirAll(checkpoint.imagePath(), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(checkpoint.imagePath(), "pages-1.img"), pages, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := checkpoint.dedupPages(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	chunks, err := ioutil.ReadDir(container.pageStorePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 2 {
+		t.Fatalf("expected 2 chunks in the page store, got %d", len(chunks))
+	}
+
+	assembled := filepath.Join(root, "pages-1.img")
+	manifest := filepath.Join(container.Checkpoints["second"].imagePath(), "pages-1.img"+pageManifestExtension)
+	if err := assemblePages(container.pageStorePath(), manifest, assembled); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(assembled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, pages) {
+		t.Fatal("assembled pages differ from the original ones")
+	}
+
+	if err := container.deleteCheckpoint("first"); err != nil {
+		t.Fatal(err)
+	}
+	if chunks, _ := ioutil.ReadDir(container.pageStorePath()); len(chunks) != 2 {
+		t.Fatalf("expected chunks still referenced to be kept, got %d", len(chunks))
+	}
+	if err := container.deleteCheckpoint("second"); err != nil {
+		t.Fatal(err)
+	}
+	if chunks, _ := ioutil.ReadDir(container.pageStorePath()); len(chunks) != 0 {
+		t.Fatalf("expected unreferenced chunks to be removed, got %d", len(chunks))
+	}
+}
+
+func TestCheckpointPagesSize(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-checkpoint-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	container := &Container{
+		root:        root,
+		Checkpoints: make(map[string]*ContainerCheckpoint),
+	}
+	parent := &ContainerCheckpoint{ID: "parent", DedupPages: true, container: container}
+	child := &ContainerCheckpoint{ID: "child", ParentID: "parent", container: container}
+	container.Checkpoints["parent"] = parent
+	container.Checkpoints["child"] = child
+
+	for _, checkpoint := range []*ContainerCheckpoint{parent, child} {
+		if err := os.MkdirAll(checkpoint.imagePath(), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(checkpoint.imagePath(), "pages-1.img"), make([]byte, 3*pageChunkSize), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(checkpoint.imagePath(), "core-1.img"), make([]byte, 4096), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := parent.dedupPages(); err != nil {
+		t.Fatal(err)
+	}
+
+	size, err := child.pagesSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 6*pageChunkSize {
+		t.Fatalf("expected pages size of %d, got %d", 6*pageChunkSize, size)
+	}
+}
+
+func TestCheckpointStatusSizes(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-checkpoint-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	container := &Container{
+		root:        root,
+		Checkpoints: make(map[string]*ContainerCheckpoint),
+	}
+	now := time.Now()
+	files := map[string]map[string]int{
+		"older": {"pages-1.img": 8192, "core-1.img": 4096},
+		"newer": {"pages-1.img.gz": 100, "pages-2.img": 4096, "inventory.img": 32},
+	}
+	for i, id := range []string{"newer", "older"} {
+		checkpoint := &ContainerCheckpoint{ID: id, CreatedAt: now.Add(-time.Duration(i) * time.Second), container: container}
+		container.Checkpoints[id] = checkpoint
+		if err := os.MkdirAll(checkpoint.imagePath(), 0700); err != nil {
+			t.Fatal(err)
+		}
+		for name, size := range files[id] {
+			if err := ioutil.WriteFile(filepath.Join(checkpoint.imagePath(), name), make([]byte, size), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	statuses := container.checkpointStatuses()
+	if len(statuses) != 2 || statuses[0].ID != "older" || statuses[1].ID != "newer" {
+		t.Fatalf("expected the statuses of the checkpoints in creation order, got %v", statuses)
+	}
+	for _, c := range []struct {
+		status     *checkpointStatus
+		size       int64
+		pagesBytes int64
+	}{
+		{statuses[0], 8192 + 4096, 8192},
+		{statuses[1], 100 + 4096 + 32, 100 + 4096},
+	} {
+		if c.status.SizeBytes != c.size {
+			t.Fatalf("expected checkpoint %s to take %d bytes, got %d", c.status.ID, c.size, c.status.SizeBytes)
+		}
+		if c.status.PagesImageBytes != c.pagesBytes {
+			t.Fatalf("expected the pages of checkpoint %s to take %d bytes, got %d", c.status.ID, c.pagesBytes, c.status.PagesImageBytes)
+		}
+	}
+}
+
+func TestCleanCheckpoints(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-checkpoint-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, checkpointRoot := range []string{"", filepath.Join(root, "checkpoints")} {
+		daemon := &Daemon{
+			repository: filepath.Join(root, "containers"),
+			config:     &Config{CheckpointRoot: checkpointRoot},
+		}
+		container := &Container{
+			ID:          "container",
+			root:        filepath.Join(daemon.repository, "container"),
+			daemon:      daemon,
+			Checkpoints: make(map[string]*ContainerCheckpoint),
+		}
+		for _, id := range []string{"parent", "child"} {
+			checkpoint := &ContainerCheckpoint{ID: id, container: container}
+			container.Checkpoints[id] = checkpoint
+			if err := os.MkdirAll(checkpoint.imagePath(), 0700); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(checkpoint.imagePath(), "pages-1.img"), make([]byte, 4096), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		container.Checkpoints["child"].ParentID = "parent"
+		if err := os.MkdirAll(container.pageStorePath(), 0700); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := container.cleanCheckpoints(); err != nil {
+			t.Fatal(err)
+		}
+		if len(container.Checkpoints) != 0 {
+			t.Fatalf("expected all checkpoints to be removed, %d left", len(container.Checkpoints))
+		}
+		for _, path := range []string{container.checkpointsPath(), container.pageStorePath()} {
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Fatalf("expected %s to be removed, got %v", path, err)
+			}
+		}
+		if checkpointRoot != "" {
+			if _, err := os.Stat(container.checkpointRoot()); !os.IsNotExist(err) {
+				t.Fatalf("expected %s to be removed, got %v", container.checkpointRoot(), err)
+			}
+		}
+	}
+}
+
+func TestEnforceCheckpointQuota(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-checkpoint-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	daemon := &Daemon{
+		repository: root,
+		config:     &Config{CheckpointQuota: 3 * 4096},
+	}
+	container := &Container{
+		ID:          "container",
+		root:        filepath.Join(root, "container"),
+		daemon:      daemon,
+		Checkpoints: make(map[string]*ContainerCheckpoint),
+	}
+	now := time.Now()
+	for i, id := range []string{"oldest", "parent", "child"} {
+		checkpoint := &ContainerCheckpoint{ID: id, CreatedAt: now.Add(time.Duration(i) * time.Second), container: container}
+		if id == "child" {
+			checkpoint.ParentID = "parent"
+		}
+		container.Checkpoints[id] = checkpoint
+		if err := os.MkdirAll(checkpoint.imagePath(), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(checkpoint.imagePath(), "pages-1.img"), make([]byte, 4096), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := container.enforceCheckpointQuota(""); err == nil {
+		t.Fatal("expected checkpointing over the quota to fail")
+	}
+	if len(container.Checkpoints) != 3 {
+		t.Fatalf("expected no checkpoints to be removed, %d left", len(container.Checkpoints))
+	}
+
+	daemon.config.CheckpointQuotaPrune = true
+	if err := container.enforceCheckpointQuota("child"); err != nil {
+		t.Fatal(err)
+	}
+	if container.Checkpoints["oldest"] != nil {
+		t.Fatal("expected the oldest checkpoint to be removed")
+	}
+	if container.Checkpoints["parent"] == nil || container.Checkpoints["child"] == nil {
+		t.Fatal("expected the checkpoint to keep and its parent to be kept")
+	}
+
+	saved := &Container{root: container.root}
+	if err := saved.FromDisk(); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Checkpoints["oldest"] != nil || saved.Checkpoints["parent"] == nil {
+		t.Fatalf("expected the container to be saved without the pruned checkpoint, got %v", saved.Checkpoints)
+	}
+}
+
+func TestCloneCheckpoint(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-checkpoint-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	container := &Container{
+		root:        filepath.Join(root, "container"),
+		Checkpoints: make(map[string]*ContainerCheckpoint),
+	}
+	clone := &Container{
+		root:        filepath.Join(root, "clone"),
+		Checkpoints: make(map[string]*ContainerCheckpoint),
+	}
+	checkpoint := &ContainerCheckpoint{ID: "checkpoint", NetworkSettings: &NetworkSettings{}, container: container}
+	container.Checkpoints[checkpoint.ID] = checkpoint
+	if err := os.MkdirAll(checkpoint.imagePath(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3*cloneWorkers; i++ {
+		name := filepath.Join(checkpoint.imagePath(), fmt.Sprintf("pages-%d.img", i))
+		if err := ioutil.WriteFile(name, []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cloned, err := checkpoint.clone(clone, CloneHardlink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3*cloneWorkers; i++ {
+		data, err := ioutil.ReadFile(filepath.Join(cloned.imagePath(), fmt.Sprintf("pages-%d.img", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := filepath.Join(checkpoint.imagePath(), fmt.Sprintf("pages-%d.img", i)); string(data) != expected {
+			t.Fatalf("expected cloned image file to contain %q, got %q", expected, data)
+		}
+	}
+
+	// cloning again fails on every file which already exists
+	if _, err := checkpoint.clone(clone, CloneHardlink); err == nil {
+		t.Fatal("expected cloning into existing image files to fail")
+	}
+}
+
+func TestCheckpointCheckCloneAddress(t *testing.T) {
+	source := &Container{
+		ID:              "source",
+		State:           NewState(),
+		NetworkSettings: &NetworkSettings{IPAddress: "172.17.0.2"},
+	}
+	source.SetRunning(1)
+	checkpoint := &ContainerCheckpoint{ID: "checkpoint", NetworkSettings: &NetworkSettings{IPAddress: "172.17.0.3"}, container: source}
+
+	for _, c := range []struct {
+		ip    string
+		valid bool
+	}{
+		{"172.17.0.4", true},
+		{"", true},
+		{"172.17.0.2", false}, // the running source's
+		{"172.17.0.3", false}, // the checkpointed one
+	} {
+		clone := &Container{ID: "clone", NetworkSettings: &NetworkSettings{IPAddress: c.ip}}
+		cloned := &ContainerCheckpoint{ID: "checkpoint", container: clone, original: checkpoint}
+		if err := cloned.checkCloneAddress(); (err == nil) != c.valid {
+			t.Fatalf("expected a clone with address %q to be valid: %v, got %v", c.ip, c.valid, err)
+		}
+	}
+
+	// The source doesn't hold its address anymore once stopped
+	source.SetStopped(&execdriver.ExitStatus{})
+	clone := &Container{ID: "clone", NetworkSettings: &NetworkSettings{IPAddress: "172.17.0.2"}}
+	cloned := &ContainerCheckpoint{ID: "checkpoint", container: clone, original: checkpoint}
+	if err := cloned.checkCloneAddress(); err != nil {
+		t.Fatalf("expected a clone to be allowed the address of the stopped source: %s", err)
+	}
+}
+
+func TestCheckpointPausedRoundTrip(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-checkpoint-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	container := &Container{
+		root:        filepath.Join(root, "container"),
+		Checkpoints: make(map[string]*ContainerCheckpoint),
+	}
+	checkpoint := &ContainerCheckpoint{ID: "checkpoint", Paused: true, NetworkSettings: &NetworkSettings{}, container: container}
+	if err := os.MkdirAll(checkpoint.imagePath(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if !checkpoint.execdriverCheckpoint().Frozen {
+		t.Fatal("expected a paused container to be dumped frozen")
+	}
+
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded ContainerCheckpoint
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Paused {
+		t.Fatal("expected the paused flag to be saved along with the checkpoint")
+	}
+
+	clone := &Container{
+		root:        filepath.Join(root, "clone"),
+		Checkpoints: make(map[string]*ContainerCheckpoint),
+	}
+	cloned, err := checkpoint.clone(clone, CloneHardlink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cloned.Paused {
+		t.Fatal("expected the checkpoint cloned to restore from to keep the paused flag")
+	}
+}
+
+func TestCheckpointTcpEstablished(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-checkpoint-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	container := &Container{
+		root:        filepath.Join(root, "container"),
+		Checkpoints: make(map[string]*ContainerCheckpoint),
+	}
+	checkpoint := &ContainerCheckpoint{ID: "checkpoint", TcpEstablished: true, NetworkSettings: &NetworkSettings{}, container: container}
+	if !checkpoint.execdriverCheckpoint().TcpEstablished {
+		t.Fatal("expected the established TCP connections to be dumped")
+	}
+
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded ContainerCheckpoint
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	loaded.container = container
+	if !loaded.execdriverCheckpoint().TcpEstablished {
+		t.Fatal("expected a loaded checkpoint to restore the established TCP connections")
+	}
+}
+
+func TestCheckpointShellJobFileLocks(t *testing.T) {
+	container := &Container{Checkpoints: make(map[string]*ContainerCheckpoint)}
+	checkpoint := &ContainerCheckpoint{ID: "checkpoint", ShellJob: true, FileLocks: true, NetworkSettings: &NetworkSettings{}, container: container}
+
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded ContainerCheckpoint
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	loaded.container = container
+	// The restore has to be given the options the dump was
+	for _, cp := range []*ContainerCheckpoint{checkpoint, &loaded} {
+		driverCheckpoint := cp.execdriverCheckpoint()
+		if !driverCheckpoint.ShellJob || !driverCheckpoint.FileLocks {
+			t.Fatalf("expected a shell job with file locks, got shell job %v and file locks %v", driverCheckpoint.ShellJob, driverCheckpoint.FileLocks)
+		}
+	}
+}
+
+func TestRebaseRootResourcePaths(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-checkpoint-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// Received from a daemon with the default root, the hosts file being
+	// the one of the container whose network namespace is joined
+	container := &Container{
+		ID:             "abc",
+		root:           filepath.Join(root, "containers", "abc"),
+		ResolvConfPath: "/var/lib/docker/containers/abc/resolv.conf",
+		HostnamePath:   "/var/lib/docker/containers/abc/hostname",
+		HostsPath:      filepath.Join(root, "containers", "def", "hosts"),
+	}
+	if err := os.MkdirAll(container.root, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := container.rebaseRootResourcePaths(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct{ path, expected string }{
+		{container.ResolvConfPath, filepath.Join(container.root, "resolv.conf")},
+		{container.HostnamePath, filepath.Join(container.root, "hostname")},
+		{container.HostsPath, filepath.Join(root, "containers", "def", "hosts")},
+	} {
+		if c.path != c.expected {
+			t.Fatalf("expected %s, got %s", c.expected, c.path)
+		}
+	}
+}
+
+func TestCheckCheckpointable(t *testing.T) {
+	container := &Container{hostConfig: &runconfig.HostConfig{NetworkMode: "bridge"}}
+	if err := container.checkCheckpointable(); err != nil {
+		t.Fatal(err)
+	}
+	container.hostConfig.PidMode = "host"
+	if err := container.checkCheckpointable(); err == nil {
+		t.Fatal("expected a container sharing the host PID namespace not to be checkpointable")
+	}
+	container.hostConfig.PidMode = ""
+	container.hostConfig.NetworkMode = "host"
+	if err := container.checkCheckpointable(); err == nil {
+		t.Fatal("expected a container sharing the host network namespace not to be checkpointable")
+	}
+}
+
+// writeCriuImage writes the entries into a criu image at path.
+func writeCriuImage(t *testing.T, path string, magics []uint32, entries ...[]byte) {
+	var buf bytes.Buffer
+	for _, magic := range magics {
+		binary.Write(&buf, binary.LittleEndian, magic)
+	}
+	for _, entry := range entries {
+		binary.Write(&buf, binary.LittleEndian, uint32(len(entry)))
+		buf.Write(entry)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func protoVarint(field int, value uint64) []byte {
+	buf := make([]byte, 2*binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, uint64(field<<3))
+	n += binary.PutUvarint(buf[n:], value)
+	return buf[:n]
+}
+
+func protoBytes(field int, value []byte) []byte {
+	buf := make([]byte, 2*binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, uint64(field<<3|2))
+	n += binary.PutUvarint(buf[n:], uint64(len(value)))
+	return append(buf[:n], value...)
+}
+
+func TestCheckpointCollectStats(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-checkpoint-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	container := &Container{root: root}
+	checkpoint := &ContainerCheckpoint{ID: "checkpoint", container: container}
+	imagePath := checkpoint.imagePath()
+	if err := os.MkdirAll(imagePath, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// freezing, frozen, memdump and memwrite times in microseconds, and
+	// the pages scanned, skipped and written
+	var dump []byte
+	for i, value := range []uint64{10, 2000, 300, 400, 1024, 512, 256} {
+		dump = append(dump, protoVarint(i+1, value)...)
+	}
+	writeCriuImage(t, filepath.Join(imagePath, "stats-dump"), []uint32{criuImgServiceMagic, 0x57093306}, protoBytes(1, dump))
+	writeCriuImage(t, filepath.Join(imagePath, "fdinfo-2.img"), []uint32{criuImgCommonMagic, 0x56213732}, protoVarint(1, 0), protoVarint(1, 1), protoVarint(1, 2))
+	writeCriuImage(t, filepath.Join(imagePath, "fdinfo-3.img"), []uint32{criuImgCommonMagic, 0x56213732}, protoVarint(1, 0))
+	writeCriuImage(t, filepath.Join(imagePath, "inetsk.img"), []uint32{criuImgCommonMagic, 0x56443851}, protoVarint(1, 1))
+	writeCriuImage(t, filepath.Join(imagePath, "unixsk.img"), []uint32{criuImgCommonMagic, 0x54373943}, protoVarint(1, 1), protoVarint(1, 2))
+
+	stats, err := checkpoint.collectStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &CheckpointStats{
+		FreezingTime:       10 * time.Microsecond,
+		FrozenTime:         2 * time.Millisecond,
+		MemdumpTime:        300 * time.Microsecond,
+		MemwriteTime:       400 * time.Microsecond,
+		PagesScanned:       1024,
+		PagesSkippedParent: 512,
+		PagesWritten:       256,
+		OpenFiles:          4,
+		Sockets:            3,
+	}
+	if *stats != *expected {
+		t.Fatalf("expected %+v, got %+v", expected, stats)
+	}
+}
+
+func TestCheckpointValidateImage(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-checkpoint-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	container := &Container{root: root}
+	checkpoint := &ContainerCheckpoint{ID: "checkpoint", container: container}
+	imagePath := checkpoint.imagePath()
+	if err := os.MkdirAll(imagePath, 0700); err != nil {
+		t.Fatal(err)
+	}
+	container.Checkpoints = map[string]*ContainerCheckpoint{checkpoint.ID: checkpoint}
+
+	// a dump which failed before writing the core of the processes
+	for _, name := range []string{"inventory.img", "pstree.img"} {
+		if err := ioutil.WriteFile(filepath.Join(imagePath, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := checkpoint.validateImage(); err == nil {
+		t.Fatal("expected a checkpoint without core images to be invalid")
+	}
+	if statuses := container.checkpointStatuses(); statuses[0].Valid || statuses[0].ValidationError == "" {
+		t.Fatalf("expected the checkpoint to be flagged invalid, got %+v", statuses[0])
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(imagePath, "core-1.img"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkpoint.validateImage(); err != nil {
+		t.Fatal(err)
+	}
+	if statuses := container.checkpointStatuses(); !statuses[0].Valid {
+		t.Fatalf("expected the checkpoint to be valid, got %+v", statuses[0])
+	}
+
+	if err := os.Remove(filepath.Join(imagePath, "inventory.img")); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkpoint.validateImage(); err == nil {
+		t.Fatal("expected a checkpoint without inventory to be invalid")
+	}
+}
+
+func TestCheckpointTokenBucket(t *testing.T) {
+	now := time.Unix(0, 0)
+	var slept time.Duration
+	bucket := newTokenBucket(1000)
+	bucket.last = now
+	bucket.now = func() time.Time { return now }
+	bucket.sleep = func(d time.Duration) {
+		slept += d
+		now = now.Add(d)
+	}
+
+	var buf bytes.Buffer
+	w := &rateLimitedWriter{w: &buf, bucket: bucket}
+	if n, err := w.Write(make([]byte, 2500)); err != nil || n != 2500 {
+		t.Fatalf("expected 2500 bytes written, got %d: %v", n, err)
+	}
+	if buf.Len() != 2500 {
+		t.Fatalf("expected 2500 bytes to get through, got %d", buf.Len())
+	}
+	// 1000 bytes per second and no burst to start with
+	if slept < 2500*time.Millisecond || slept > 2700*time.Millisecond {
+		t.Fatalf("expected about 2.5s waited for 2500 bytes at 1000B/s, got %s", slept)
+	}
+
+	// an idle second refills the bucket, but no more than a second's worth
+	now = now.Add(10 * time.Second)
+	slept = 0
+	if taken := bucket.take(5000); taken < 1000 || taken > 1100 || slept != 0 {
+		t.Fatalf("expected a second's worth taken without waiting, got %d after %s", taken, slept)
+	}
+
+	// idle long enough at a high rate for the refill to overflow
+	bucket = newTokenBucket(1 << 30)
+	bucket.last = now
+	bucket.now = func() time.Time { return now }
+	bucket.sleep = func(d time.Duration) {
+		t.Fatalf("expected no wait after a long idle time, waiting %s", d)
+	}
+	now = now.Add(10 * time.Second)
+	if taken := bucket.take(1 << 30); taken != 1<<30 {
+		t.Fatalf("expected a second's worth taken after a long idle time, got %d", taken)
+	}
+}
+
+func TestCheckpointThrottledProxy(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan []byte)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(received)
+			return
+		}
+		data, _ := ioutil.ReadAll(conn)
+		conn.Write([]byte("ack"))
+		conn.Close()
+		received <- data
+	}()
+
+	proxy, err := newThrottledProxy(listener.Addr().String(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", proxy.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("pages")); err != nil {
+		t.Fatal(err)
+	}
+	conn.(*net.TCPConn).CloseWrite()
+	ack, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if data := <-received; string(data) != "pages" || string(ack) != "ack" {
+		t.Fatalf("expected pages forwarded and acknowledged, got %q and %q", data, ack)
+	}
+	if err := proxy.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRenameCheckpoint(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-checkpoint-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	container := &Container{
+		root:        root,
+		Checkpoints: make(map[string]*ContainerCheckpoint),
+	}
+	parent := &ContainerCheckpoint{ID: "auto-123", container: container}
+	child := &ContainerCheckpoint{ID: "child", ParentID: "auto-123", container: container}
+	container.Checkpoints[parent.ID] = parent
+	container.Checkpoints[child.ID] = child
+	for _, cp := range []*ContainerCheckpoint{parent, child} {
+		if err := os.MkdirAll(cp.imagePath(), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("../auto-123", filepath.Join(child.imagePath(), "parent")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := container.renameCheckpoint("auto-123", "child"); err == nil {
+		t.Fatal("expected renaming to an existing checkpoint to fail")
+	}
+	if err := container.renameCheckpoint("auto-123", "../golden"); err == nil {
+		t.Fatal("expected renaming to an invalid name to fail")
+	}
+	// Restoring the child reads the image of its parent
+	child.restoring++
+	if err := container.renameCheckpoint("auto-123", "golden"); err == nil {
+		t.Fatal("expected renaming a checkpoint being restored to fail")
+	}
+	child.restoring--
+	if err := container.renameCheckpoint("auto-123", "golden"); err != nil {
+		t.Fatal(err)
+	}
+
+	if container.Checkpoints["auto-123"] != nil || container.Checkpoints["golden"] != parent || parent.ID != "golden" {
+		t.Fatalf("expected the checkpoint to be keyed by its new name, got %v", container.Checkpoints)
+	}
+	if _, err := os.Stat(filepath.Join(container.checkpointsPath(), "golden")); err != nil {
+		t.Fatalf("expected the image to be moved: %s", err)
+	}
+	if child.ParentID != "golden" {
+		t.Fatalf("expected the child to point to the renamed parent, got %s", child.ParentID)
+	}
+	if target, err := os.Readlink(filepath.Join(child.imagePath(), "parent")); err != nil || target != "../golden" {
+		t.Fatalf("expected the parent link of the child image to point to ../golden, got %s: %v", target, err)
+	}
+}
+
+func TestCheckpointCheckPages(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-checkpoint-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	container := &Container{root: root}
+	checkpoint := &ContainerCheckpoint{ID: "checkpoint", container: container}
+	imagePath := checkpoint.imagePath()
+	if err := os.MkdirAll(imagePath, 0700); err != nil {
+		t.Fatal(err)
+	}
+	pageSize := os.Getpagesize()
+
+	if err := checkpoint.checkPages(); err == nil {
+		t.Fatal("expected a checkpoint without pagemap to fail the check")
+	}
+
+	// 3 pages dumped, 2 more left in the parent checkpoint
+	writeCriuImage(t, filepath.Join(imagePath, "pagemap-1.img"), []uint32{criuImgCommonMagic, 0x56084025},
+		protoVarint(1, 7),
+		append(protoVarint(1, 0x400000), protoVarint(2, 3)...),
+		append(append(protoVarint(1, 0x600000), protoVarint(2, 2)...), protoVarint(3, 1)...))
+	pagesPath := filepath.Join(imagePath, "pages-7.img")
+	if err := ioutil.WriteFile(pagesPath, make([]byte, 2*pageSize), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkpoint.checkPages(); err == nil {
+		t.Fatal("expected truncated pages to fail the check")
+	}
+	if err := ioutil.WriteFile(pagesPath, make([]byte, 3*pageSize), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkpoint.checkPages(); err != nil {
+		t.Fatal(err)
+	}
+
+	// deduplicated into the page store
+	if err := os.MkdirAll(container.pageStorePath(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := storePages(container.pageStorePath(), pagesPath, pagesPath+pageManifestExtension); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(pagesPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkpoint.checkPages(); err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := ioutil.ReadDir(container.pageStorePath())
+	if err != nil || len(chunks) != 1 {
+		t.Fatalf("expected a single chunk in the page store, got %d: %v", len(chunks), err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(container.pageStorePath(), chunks[0].Name()), []byte("corrupted"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkpoint.checkPages(); err == nil {
+		t.Fatal("expected a corrupted chunk to fail the check")
+	}
+}
+
+func TestCloneCheckpointStrategies(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-checkpoint-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	container := &Container{
+		root:        filepath.Join(root, "container"),
+		Checkpoints: make(map[string]*ContainerCheckpoint),
+	}
+	// Incremental to a parent, linked from the image the way criu does
+	parent := &ContainerCheckpoint{ID: "parent", NetworkSettings: &NetworkSettings{}, container: container}
+	checkpoint := &ContainerCheckpoint{ID: "checkpoint", ParentID: "parent", NetworkSettings: &NetworkSettings{}, container: container}
+	for _, cp := range []*ContainerCheckpoint{parent, checkpoint} {
+		container.Checkpoints[cp.ID] = cp
+		if err := os.MkdirAll(cp.imagePath(), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(parent.imagePath(), "pages-1.img"), []byte("parent pages"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../parent", filepath.Join(checkpoint.imagePath(), "parent")); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(checkpoint.imagePath(), "pages-1.img")
+	if err := ioutil.WriteFile(src, []byte("pages"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Wider than the umask lets a new file be created with
+	if err := os.Chmod(src, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(checkpoint.imagePath(), "restore.pid"), []byte("1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validateCloneStrategy("symlink"); err == nil {
+		t.Fatal("expected an unknown clone strategy to be invalid")
+	}
+	for _, strategy := range []string{CloneHardlink, CloneCopy, CloneReflink} {
+		clone := &Container{
+			root:        filepath.Join(root, strategy),
+			Checkpoints: make(map[string]*ContainerCheckpoint),
+		}
+		cloned, err := checkpoint.clone(clone, strategy)
+		if err != nil {
+			t.Fatalf("%s: %s", strategy, err)
+		}
+		dest := filepath.Join(cloned.imagePath(), "pages-1.img")
+		if data, err := ioutil.ReadFile(dest); err != nil || string(data) != "pages" {
+			t.Fatalf("%s: expected the cloned file to hold the pages, got %q: %v", strategy, data, err)
+		}
+		srcInfo, _ := os.Stat(src)
+		destInfo, _ := os.Stat(dest)
+		if linked := os.SameFile(srcInfo, destInfo); linked != (strategy == CloneHardlink) {
+			t.Fatalf("%s: expected the cloned file to be linked only if hardlinked, linked=%v", strategy, linked)
+		}
+		if mode := destInfo.Mode().Perm(); mode != 0666 {
+			t.Fatalf("%s: expected the cloned file to keep mode 0666, got %#o", strategy, mode)
+		}
+		if _, err := os.Stat(filepath.Join(cloned.imagePath(), "restore.pid")); !os.IsNotExist(err) {
+			t.Fatalf("%s: expected restore.pid not to be cloned: %v", strategy, err)
+		}
+		if target, err := os.Readlink(filepath.Join(cloned.imagePath(), "parent")); err != nil || target != "../parent" {
+			t.Fatalf("%s: expected the parent link to be recreated, got %q: %v", strategy, target, err)
+		}
+		if data, err := ioutil.ReadFile(filepath.Join(cloned.imagePath(), "parent", "pages-1.img")); err != nil || string(data) != "parent pages" {
+			t.Fatalf("%s: expected the parent link to lead to the cloned parent, got %q: %v", strategy, data, err)
+		}
+	}
+}
+
+func TestCheckpointMigrationArchive(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-checkpoint-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	container := &Container{
+		root:        filepath.Join(root, "container"),
+		State:       NewState(),
+		Checkpoints: make(map[string]*ContainerCheckpoint),
+	}
+	for _, id := range []string{"migrated", "other"} {
+		checkpoint := &ContainerCheckpoint{ID: id, container: container}
+		container.Checkpoints[id] = checkpoint
+		if err := os.MkdirAll(checkpoint.imagePath(), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(checkpoint.imagePath(), "pstree.img"), []byte(id), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(container.root, "hostconfig.json"), []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := container.Checkpoints["migrated"].writeMigrationArchive(buf); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(root, "received")
+	if err := archive.Untar(buf, dest, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := ioutil.ReadFile(filepath.Join(dest, "hostconfig.json")); err != nil || string(data) != "{}" {
+		t.Fatalf("expected hostconfig.json to be transferred, got %q: %v", data, err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dest, "checkpoints", "migrated", "pstree.img")); err != nil || string(data) != "migrated" {
+		t.Fatalf("expected the image of the migrated checkpoint to be transferred, got %q: %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "checkpoints", "other")); !os.IsNotExist(err) {
+		t.Fatalf("expected the image of the other checkpoint to be left behind, got %v", err)
+	}
+	received := &Container{root: dest}
+	data, err := ioutil.ReadFile(filepath.Join(dest, "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, received); err != nil {
+		t.Fatal(err)
+	}
+	if len(received.Checkpoints) != 1 || received.Checkpoints["migrated"] == nil {
+		t.Fatalf("expected only the migrated checkpoint in the configuration, got %v", received.Checkpoints)
+	}
+}
+
+func TestUnpackMigrationStreamedPages(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-checkpoint-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, checkpointRoot := range []string{"", filepath.Join(root, "checkpoints")} {
+		daemon := &Daemon{
+			repository: filepath.Join(root, "containers"),
+			config:     &Config{CheckpointRoot: checkpointRoot},
+		}
+		// The pages received by the page server before the rest
+		imagePath := filepath.Join(daemon.checkpointRoot("container"), "checkpoints", "migrated")
+		if err := os.MkdirAll(imagePath, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(imagePath, "pages-1.img"), []byte("pages"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		buf := &bytes.Buffer{}
+		tw := tar.NewWriter(buf)
+		for name, data := range map[string]string{
+			"config.json":                     "{}",
+			"checkpoints/migrated/pstree.img": "pstree",
+		} {
+			if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tw.Write([]byte(data)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := daemon.unpackMigration("container", buf); err != nil {
+			t.Fatal(err)
+		}
+		for name, expected := range map[string]string{"pages-1.img": "pages", "pstree.img": "pstree"} {
+			if data, err := ioutil.ReadFile(filepath.Join(imagePath, name)); err != nil || string(data) != expected {
+				t.Fatalf("expected %s in the checkpoint image, got %q: %v", name, data, err)
+			}
+		}
+		if checkpointRoot != "" {
+			if _, err := os.Stat(filepath.Join(daemon.containerRoot("container"), "checkpoints")); !os.IsNotExist(err) {
+				t.Fatalf("expected the checkpoints to be moved out of the container root, got %v", err)
+			}
+		}
+		os.RemoveAll(daemon.repository)
+	}
+}
+
+func TestCheckpointCompressedPages(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-checkpoint-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	container := &Container{
+		root:        filepath.Join(root, "container"),
+		Checkpoints: make(map[string]*ContainerCheckpoint),
+	}
+	checkpoint := &ContainerCheckpoint{ID: "checkpoint", Compressed: true, NetworkSettings: &NetworkSettings{}, container: container}
+	container.Checkpoints[checkpoint.ID] = checkpoint
+	if err := os.MkdirAll(checkpoint.imagePath(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	pages := bytes.Repeat([]byte{0xaa}, 4*pageChunkSize)
+	if err := ioutil.WriteFile(filepath.Join(checkpoint.imagePath(), "pages-1.img"), pages, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(checkpoint.imagePath(), "pstree.img"), []byte("pstree"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkpoint.compressPages(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(checkpoint.imagePath(), "pages-1.img")); !os.IsNotExist(err) {
+		t.Fatalf("expected the uncompressed pages to be removed, got %v", err)
+	}
+	if size := checkpoint.imageSize(); size >= int64(len(pages)) {
+		t.Fatalf("expected the image to shrink below %d bytes, got %d", len(pages), size)
+	}
+	if size, err := checkpoint.pagesSize(); err != nil || size != int64(len(pages)) {
+		t.Fatalf("expected the pages to be counted uncompressed as %d bytes, got %d: %v", len(pages), size, err)
+	}
+
+	clone := &Container{
+		root:        filepath.Join(root, "clone"),
+		Checkpoints: make(map[string]*ContainerCheckpoint),
+	}
+	cloned, err := checkpoint.clone(clone, CloneHardlink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cloned.Compressed {
+		t.Fatal("expected the cloned checkpoint not to be compressed")
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(cloned.imagePath(), "pages-1.img")); err != nil || !bytes.Equal(data, pages) {
+		t.Fatalf("expected the cloned pages to be decompressed: %v", err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(cloned.imagePath(), "pstree.img")); err != nil || string(data) != "pstree" {
+		t.Fatalf("expected the other images to be cloned as is, got %q: %v", data, err)
+	}
+}
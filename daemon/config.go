@@ -42,6 +42,12 @@ type Config struct {
 	Context                     map[string][]string
 	TrustKeyPath                string
 	Labels                      []string
+	CheckpointRoot              string
+	CheckpointQuota             int64
+	CheckpointQuotaPrune        bool
+	CheckpointCloneStrategy     string
+	CriuBinary                  string
+	RestoreTimeout              int
 }
 
 // InstallFlags adds command-line options to the top-level flag parser for
@@ -70,6 +76,12 @@ func (config *Config) InstallFlags() {
 	// FIXME: why the inconsistency between "hosts" and "sockets"?
 	opts.IPListVar(&config.Dns, []string{"#dns", "-dns"}, "Force Docker to use specific DNS servers")
 	opts.DnsSearchListVar(&config.DnsSearch, []string{"-dns-search"}, "Force Docker to use specific DNS search domains")
+	flag.StringVar(&config.CheckpointRoot, []string{"-checkpoint-root"}, "", "Path to store container checkpoints under, defaults to the directory of each container")
+	flag.Int64Var(&config.CheckpointQuota, []string{"-checkpoint-quota"}, 0, "Maximum size in bytes of the checkpoints of a container to take another one, 0 for no limit")
+	flag.BoolVar(&config.CheckpointQuotaPrune, []string{"-checkpoint-quota-prune"}, false, "Remove the oldest checkpoints of a container exceeding --checkpoint-quota instead of refusing to checkpoint")
+	flag.StringVar(&config.CheckpointCloneStrategy, []string{"-checkpoint-clone-strategy"}, CloneHardlink, "How the image files of a checkpoint are cloned to restore it into another container: hardlink, copy or reflink")
+	flag.StringVar(&config.CriuBinary, []string{"-criu-binary"}, "", "Path of the criu binary to checkpoint and restore containers with, defaults to looking up criu in $PATH")
+	flag.IntVar(&config.RestoreTimeout, []string{"-restore-timeout"}, 0, "Number of seconds criu is given to restore a container before it is killed, no limit if 0")
 	opts.LabelListVar(&config.Labels, []string{"-label"}, "Set key=value labels to the daemon (displayed in `docker info`)")
 }
 
@@ -196,8 +196,21 @@ func (container *Container) WriteHostConfig() error {
 }
 
 func (container *Container) LogEvent(action string) {
+	container.LogEventWithAttributes(action, nil)
+}
+
+// LogEventWithAttributes logs an event carrying details of the action
+// in addition to the container and its image.
+func (container *Container) LogEventWithAttributes(action string, attributes map[string]string) {
 	d := container.daemon
-	if err := d.eng.Job("log", action, container.ID, d.Repositories().ImageName(container.ImageID)).Run(); err != nil {
+	job := d.eng.Job("log", action, container.ID, d.Repositories().ImageName(container.ImageID))
+	if attributes != nil {
+		if err := job.SetenvJson("attributes", attributes); err != nil {
+			log.Errorf("Error logging event %s for %s: %s", action, container.ID, err)
+			return
+		}
+	}
+	if err := job.Run(); err != nil {
 		log.Errorf("Error logging event %s for %s: %s", action, container.ID, err)
 	}
 }
@@ -212,6 +225,28 @@ func (container *Container) getRootResourcePath(path string) (string, error) {
 	return symlink.FollowSymlinkInScope(filepath.Join(container.root, cleanPath), container.root)
 }
 
+// rebaseRootResourcePaths points the paths of the resolv.conf, hosts and
+// hostname files of the container at its root on this daemon. They were
+// recorded under the root of the daemon which created the container, which
+// may be another, e.g. with another -g or for a migrated container, while
+// the files came along with the container. The paths to the files of a
+// container whose network namespace is joined are left alone.
+func (container *Container) rebaseRootResourcePaths() error {
+	for _, path := range []*string{&container.ResolvConfPath, &container.HostsPath, &container.HostnamePath} {
+		dir := filepath.Dir(*path)
+		if *path == "" || dir == container.root || filepath.Base(dir) != container.ID {
+			continue
+		}
+		rebased, err := container.getRootResourcePath(filepath.Base(*path))
+		if err != nil {
+			return err
+		}
+		log.Debugf("Rebasing %s of container %s to %s", *path, container.ID, rebased)
+		*path = rebased
+	}
+	return nil
+}
+
 func populateCommand(c *Container, env []string) error {
 	en := &execdriver.Network{
 		Mtu:       c.daemon.config.Mtu,
@@ -305,6 +340,7 @@ func populateCommand(c *Container, env []string) error {
 		ID:                 c.ID,
 		Rootfs:             c.RootfsPath(),
 		ReadonlyRootfs:     c.hostConfig.ReadonlyRootfs,
+		DisableOomNotify:   c.hostConfig.DisableOomNotify,
 		InitPath:           "/.dockerinit",
 		WorkingDir:         c.Config.WorkingDir,
 		Network:            en,
@@ -684,6 +720,37 @@ func (container *Container) Unpause() error {
 	return container.daemon.Unpause(container)
 }
 
+// pause is Pause for callers already holding the state lock, which
+// IsPaused and SetPaused would take again.
+func (container *Container) pause() error {
+	if container.Paused {
+		return fmt.Errorf("Container %s is already paused", container.ID)
+	}
+	if !container.Running {
+		return fmt.Errorf("Container %s is not running", container.ID)
+	}
+	if err := container.daemon.execDriver.Pause(container.command); err != nil {
+		return err
+	}
+	container.Paused = true
+	return nil
+}
+
+// unpause is Unpause for callers already holding the state lock.
+func (container *Container) unpause() error {
+	if !container.Paused {
+		return fmt.Errorf("Container %s is not paused", container.ID)
+	}
+	if !container.Running {
+		return fmt.Errorf("Container %s is not running", container.ID)
+	}
+	if err := container.daemon.execDriver.Unpause(container.command); err != nil {
+		return err
+	}
+	container.Paused = false
+	return nil
+}
+
 func (container *Container) Kill() error {
 	if !container.IsRunning() {
 		return nil
@@ -796,11 +863,11 @@ func (container *Container) Export() (archive.Archive, error) {
 func (container *Container) commitForCheckpoint(stop bool) (*image.Image, error) {
 	config := *container.Config
 	if !stop {
-		if err := container.Pause(); err != nil {
+		if err := container.pause(); err != nil {
 			return nil, fmt.Errorf("failed to pause %s: %s", container.ID, err)
 		}
 		defer func() {
-			if err := container.Unpause(); err != nil {
+			if err := container.unpause(); err != nil {
 				log.Errorf("failed to unpause %s: %s", container.ID, err)
 			}
 		}()
@@ -808,44 +875,179 @@ func (container *Container) commitForCheckpoint(stop bool) (*image.Image, error)
 	return container.daemon.Commit(container, "", "", "", "", false, &config)
 }
 
-func (container *Container) Checkpoint(stop bool) error {
+func (container *Container) Checkpoint(opts *CheckpointOptions) (*ContainerCheckpoint, error) {
 	log.Debugf("Checkpointing %s", container.ID)
 	container.Lock()
 	defer container.Unlock()
 
 	if !container.Running {
-		return fmt.Errorf("Container %s is not running.", container.ID)
+		return nil, fmt.Errorf("Container %s is not running.", container.ID)
+	}
+
+	if err := container.checkCheckpointable(); err != nil {
+		return nil, err
+	}
+
+	// criu dumps the process tree of the container's init, the processes
+	// run by docker exec aren't part of it and would be missing once
+	// restored, while the ones of the tree which talk to them are.
+	if execIDs := container.execCommands.ListRunning(); len(execIDs) > 0 && !opts.Force {
+		return nil, fmt.Errorf("Container %s has exec sessions running (%s), which would be left out of the checkpoint. Wait for them to exit or force the checkpoint.", container.ID, strings.Join(execIDs, ", "))
+	}
+
+	// A paused container is dumped frozen as is, left paused and restored
+	// paused as well.
+	paused := container.Paused
+	if opts.Frozen && !paused {
+		return nil, fmt.Errorf("Container %s must be paused to be checkpointed frozen.", container.ID)
+	}
+	if paused && opts.Stop {
+		return nil, fmt.Errorf("Container %s is paused. Unpause the container before checkpointing and stopping it", container.ID)
+	}
+
+	if opts.ParentID != "" && container.Checkpoints[opts.ParentID] == nil {
+		return nil, fmt.Errorf("No such parent checkpoint %s for container %s", opts.ParentID, container.ID)
+	}
+	if opts.PreDump && opts.Stop {
+		return nil, fmt.Errorf("A pre-dump leaves the container running, it can't be stopped")
+	}
+	if opts.TcpEstablished {
+		for _, ns := range opts.SkipNamespaces {
+			if ns == "net" {
+				return nil, fmt.Errorf("Established TCP connections can't be dumped without the network namespace")
+			}
+		}
+	}
+
+	if err := container.enforceCheckpointQuota(opts.ParentID); err != nil {
+		return nil, err
+	}
+
+	if opts.PageServer != "" {
+		if opts.ID == "" {
+			return nil, fmt.Errorf("Streaming to a page server requires the checkpoint ID prepared by the receiving daemon")
+		}
+		if opts.DedupPages {
+			return nil, fmt.Errorf("Cannot deduplicate memory pages streamed to a page server")
+		}
+	} else if opts.PageServerBandwidth != 0 {
+		return nil, fmt.Errorf("Bandwidth can only be capped when streaming to a page server")
+	}
+	if opts.CriuTimeout < 0 {
+		return nil, fmt.Errorf("Invalid criu timeout %s", opts.CriuTimeout)
+	}
+	if opts.PageServerBandwidth < 0 {
+		return nil, fmt.Errorf("Invalid page server bandwidth %d", opts.PageServerBandwidth)
+	}
+	id := opts.ID
+	if id == "" {
+		id = utils.GenerateRandomID()
+	} else if !validCheckpointIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("Invalid checkpoint name %q, only %s are allowed", id, validContainerNameChars)
+	} else if container.Checkpoints[id] != nil {
+		return nil, fmt.Errorf("Checkpoint %s already exists for container %s", id, container.ID)
 	}
 
 	checkpoint := &ContainerCheckpoint{
-		ID:              utils.GenerateRandomID(),
+		ID:              id,
 		NetworkSettings: container.NetworkSettings,
 		CreatedAt:       time.Now().UTC(),
+		Labels:          opts.Labels,
+		Description:     opts.Description,
+		ParentID:        opts.ParentID,
+		PreDump:         opts.PreDump,
+		Paused:          paused,
+		TcpEstablished:  opts.TcpEstablished,
+		ShellJob:        opts.ShellJob,
+		FileLocks:       opts.FileLocks,
+		SkipNamespaces:  opts.SkipNamespaces,
 		container:       container,
+		pageServer:      opts.PageServer,
+		progress:        opts.Progress,
+		timeout:         opts.CriuTimeout,
+		Origin:          newCheckpointOrigin(),
+	}
+	progress := func(format string, a ...interface{}) {
+		if opts.Progress != nil {
+			opts.Progress(fmt.Sprintf(format, a...))
+		}
 	}
 
 	imagePath := checkpoint.imagePath()
 	os.RemoveAll(imagePath)
 	if err := os.MkdirAll(imagePath, 0755); err != nil {
-		return err
+		return nil, err
 	}
 
-	if err := container.daemon.Checkpoint(checkpoint, stop); err != nil {
-		return err
+	if opts.PageServerBandwidth > 0 {
+		proxy, err := newThrottledProxy(opts.PageServer, opts.PageServerBandwidth)
+		if err != nil {
+			return nil, err
+		}
+		defer proxy.Close()
+		checkpoint.pageServer = proxy.Addr()
+	}
+
+	progress("Dumping container %s", container.ID)
+	if err := container.daemon.Checkpoint(checkpoint, opts.Stop); err != nil {
+		return nil, err
+	}
+	if opts.Stop {
+		// criu kills the processes once dumped, the rootfs is captured
+		// only when they are gone.
+		if err := container.daemon.execDriver.Wait(container.command, 10*time.Second); err != nil {
+			return nil, fmt.Errorf("Container %s didn't exit after being checkpointed: %s", container.ID, err)
+		}
 	}
 	log.Debugf("checkpoint = %s", checkpoint)
 
-	img, err := container.commitForCheckpoint(stop)
-	if err != nil {
-		return err
+	if stats, err := checkpoint.collectStats(); err != nil {
+		log.Warnf("failed to collect stats of checkpoint %s: %s", checkpoint.ID, err)
+	} else {
+		checkpoint.Stats = stats
+	}
+
+	if opts.DedupPages {
+		checkpoint.DedupPages = true
+		progress("Deduplicating memory pages")
+		if err := checkpoint.dedupPages(); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Compress {
+		checkpoint.Compressed = true
+		progress("Compressing memory pages")
+		if err := checkpoint.compressPages(); err != nil {
+			return nil, err
+		}
+	}
+
+	// The rootfs is captured with the processes paused, unless they are
+	// gone or already paused by the caller.
+	quiesced := opts.Stop || paused
+	if opts.PreDump {
+		// Not restorable, the rootfs is captured by the checkpoint
+		// taken on top of it
+		checkpoint.ImageID = container.ImageID
+	} else if opts.Snapshot && checkpoint.snapshotSupported() {
+		progress("Snapshotting the rootfs")
+		if err := checkpoint.takeSnapshot(quiesced); err != nil {
+			return nil, err
+		}
+	} else {
+		progress("Committing the rootfs")
+		img, err := container.commitForCheckpoint(quiesced)
+		if err != nil {
+			return nil, err
+		}
+		checkpoint.ImageID = img.ID
 	}
-	checkpoint.ImageID = img.ID
 
 	container.Checkpoints[checkpoint.ID] = checkpoint
-	return nil
+	return checkpoint, nil
 }
 
-func (container *Container) Restore(checkpoint *ContainerCheckpoint, clone bool) error {
+func (container *Container) Restore(checkpoint *ContainerCheckpoint, opts *RestoreOptions) error {
 	log.Debugf("Restoring %s", container.ID)
 	container.Lock()
 	defer container.Unlock()
@@ -853,17 +1055,50 @@ func (container *Container) Restore(checkpoint *ContainerCheckpoint, clone bool)
 	if container.Running {
 		return fmt.Errorf("Container %s already running.", container.ID)
 	}
+	// criu binds the files at these paths into the restored container
+	if err := container.rebaseRootResourcePaths(); err != nil {
+		return err
+	}
 
 	runner := func(_ *Container, pipes *execdriver.Pipes, startCallback execdriver.StartCallback) (execdriver.ExitStatus, error) {
-		if clone {
+		if opts.Clone {
 			if err := checkpoint.patchImage(); err != nil {
 				log.Errorf("failed to patch: %s", err)
 				return execdriver.ExitStatus{ExitCode: -1}, err
 			}
 		}
-		return container.daemon.execDriver.Restore(checkpoint.execdriverCheckpoint(), pipes, startCallback)
+		container.command.CgroupParent = opts.CgroupParent
+		driverCheckpoint := checkpoint.execdriverCheckpoint()
+		driverCheckpoint.EmptyNetNs = opts.EmptyNetNs
+		driverCheckpoint.Attached = opts.Attached
+		driverCheckpoint.RestoreTimeout = time.Duration(container.daemon.config.RestoreTimeout) * time.Second
+		if opts.NatNetwork {
+			driverCheckpoint.NatNetwork = true
+			driverCheckpoint.NatPorts = container.NetworkSettings.Ports
+		}
+		return container.daemon.execDriver.Restore(driverCheckpoint, pipes, startCallback)
+	}
+	if len(opts.Entrypoint) > 0 {
+		// Recover the container's rootfs and identity but start the given
+		// command the normal way, e.g. a shell to look into a process
+		// which got wedged.
+		container.Path = opts.Entrypoint[0]
+		container.Args = opts.Entrypoint[1:]
+		runner = func(c *Container, pipes *execdriver.Pipes, startCallback execdriver.StartCallback) (execdriver.ExitStatus, error) {
+			container.command.CgroupParent = opts.CgroupParent
+			return container.daemon.Run(c, pipes, startCallback)
+		}
 	}
-	if clone {
+	if opts.EmptyNetNs {
+		container.Config.NetworkDisabled = true
+		container.NetworkSettings = &NetworkSettings{}
+		return container.spawn(runner, func() error { return nil })
+	} else if opts.NatNetwork {
+		// The address is kept but routed by the host instead of being
+		// allocated on the bridge, which might not even share its subnet.
+		container.NetworkSettings = checkpoint.NetworkSettings
+		return container.spawn(runner, func() error { return nil })
+	} else if opts.Clone {
 		return container.spawn(runner, container.AllocateNetwork)
 	} else {
 		container.NetworkSettings = checkpoint.NetworkSettings
@@ -109,35 +109,44 @@ type Daemon struct {
 func (daemon *Daemon) Install(eng *engine.Engine) error {
 	// FIXME: remove ImageDelete's dependency on Daemon, then move to graph/
 	for name, method := range map[string]engine.Handler{
-		"attach":            daemon.ContainerAttach,
-		"commit":            daemon.ContainerCommit,
-		"container_changes": daemon.ContainerChanges,
-		"container_copy":    daemon.ContainerCopy,
-		"container_rename":  daemon.ContainerRename,
-		"container_inspect": daemon.ContainerInspect,
-		"containers":        daemon.Containers,
-		"create":            daemon.ContainerCreate,
-		"rm":                daemon.ContainerRm,
-		"export":            daemon.ContainerExport,
-		"info":              daemon.CmdInfo,
-		"kill":              daemon.ContainerKill,
-		"logs":              daemon.ContainerLogs,
-		"pause":             daemon.ContainerPause,
-		"resize":            daemon.ContainerResize,
-		"restart":           daemon.ContainerRestart,
-		"start":             daemon.ContainerStart,
-		"stop":              daemon.ContainerStop,
-		"top":               daemon.ContainerTop,
-		"unpause":           daemon.ContainerUnpause,
-		"wait":              daemon.ContainerWait,
-		"image_delete":      daemon.ImageDelete, // FIXME: see above
-		"execCreate":        daemon.ContainerExecCreate,
-		"execStart":         daemon.ContainerExecStart,
-		"execResize":        daemon.ContainerExecResize,
-		"execInspect":       daemon.ContainerExecInspect,
-		"checkpoint":        daemon.ContainerCheckpoint,
-		"restore":           daemon.ContainerRestore,
-		"container_load":    daemon.ContainerLoad,
+		"attach":             daemon.ContainerAttach,
+		"commit":             daemon.ContainerCommit,
+		"container_changes":  daemon.ContainerChanges,
+		"container_copy":     daemon.ContainerCopy,
+		"container_rename":   daemon.ContainerRename,
+		"container_inspect":  daemon.ContainerInspect,
+		"containers":         daemon.Containers,
+		"create":             daemon.ContainerCreate,
+		"rm":                 daemon.ContainerRm,
+		"export":             daemon.ContainerExport,
+		"info":               daemon.CmdInfo,
+		"kill":               daemon.ContainerKill,
+		"logs":               daemon.ContainerLogs,
+		"pause":              daemon.ContainerPause,
+		"resize":             daemon.ContainerResize,
+		"restart":            daemon.ContainerRestart,
+		"start":              daemon.ContainerStart,
+		"stop":               daemon.ContainerStop,
+		"top":                daemon.ContainerTop,
+		"unpause":            daemon.ContainerUnpause,
+		"wait":               daemon.ContainerWait,
+		"image_delete":       daemon.ImageDelete, // FIXME: see above
+		"execCreate":         daemon.ContainerExecCreate,
+		"execStart":          daemon.ContainerExecStart,
+		"execResize":         daemon.ContainerExecResize,
+		"execInspect":        daemon.ContainerExecInspect,
+		"checkpoint":         daemon.ContainerCheckpoint,
+		"checkpoint_check":   daemon.ContainerCheckpointCheck,
+		"checkpoint_delete":  daemon.ContainerCheckpointDelete,
+		"checkpoint_list":    daemon.ContainerCheckpointList,
+		"checkpoint_receive": daemon.ContainerCheckpointReceive,
+		"checkpoint_rename":  daemon.ContainerCheckpointRename,
+		"checkpoint_restart": daemon.ContainerCheckpointRestart,
+		"checkpoint_verify":  daemon.ContainerCheckpointVerify,
+		"restore":            daemon.ContainerRestore,
+		"container_load":     daemon.ContainerLoad,
+		"container_migrate":  daemon.ContainerMigrate,
+		"container_receive":  daemon.ContainerReceive,
 	} {
 		if err := eng.Register(name, method); err != nil {
 			return err
@@ -288,6 +297,18 @@ func (daemon *Daemon) register(container *Container, updateSuffixarray bool) err
 	return nil
 }
 
+// unregister undoes register for a container which failed to be set up
+// afterwards, so that it can be registered again.
+func (daemon *Daemon) unregister(container *Container) {
+	daemon.idIndex.Delete(container.ID)
+	daemon.containers.Delete(container.ID)
+	container.derefVolumes()
+	if _, err := daemon.containerGraph.Purge(container.ID); err != nil {
+		log.Debugf("Unable to remove container from link graph: %s", err)
+	}
+	container.daemon = nil
+}
+
 func (daemon *Daemon) ensureName(container *Container) error {
 	if container.Name == "" {
 		name, err := daemon.generateNewName(container.ID)
@@ -419,7 +440,7 @@ func (daemon *Daemon) restoreSingleContainer(id string) error {
 	// FIXME: some logic are duplicating with restore()
 	container, err := daemon.load(id)
 	if err != nil {
-		return  fmt.Errorf("Failed to load container %v: %v", id, err)
+		return fmt.Errorf("Failed to load container %v: %v", id, err)
 	}
 
 	currentDriver := daemon.driver.String()
@@ -816,6 +837,9 @@ func NewDaemonFromDirectory(config *Config, eng *engine.Engine) (*Daemon, error)
 	if config.Mtu == 0 {
 		config.Mtu = getDefaultNetworkMtu()
 	}
+	if err := validateCloneStrategy(config.CheckpointCloneStrategy); err != nil {
+		return nil, err
+	}
 	// Check for mutually incompatible config options
 	if config.BridgeIface != "" && config.BridgeIP != "" {
 		return nil, fmt.Errorf("You specified -b & --bip, mutually exclusive options. Please specify only one.")
@@ -984,7 +1008,7 @@ func NewDaemonFromDirectory(config *Config, eng *engine.Engine) (*Daemon, error)
 	}
 
 	sysInfo := sysinfo.New(false)
-	ed, err := execdrivers.NewDriver(config.ExecDriver, config.Root, sysInitPath, sysInfo)
+	ed, err := execdrivers.NewDriver(config.ExecDriver, config.Root, sysInitPath, config.CriuBinary, sysInfo)
 	if err != nil {
 		return nil, err
 	}
@@ -102,6 +102,12 @@ func (daemon *Daemon) Destroy(container *Container) error {
 		log.Debugf("Unable to remove container from link graph: %s", err)
 	}
 
+	// Leftover checkpoint images only take disk space, the container is
+	// still to be removed
+	if err := container.cleanCheckpoints(); err != nil {
+		log.Errorf("Unable to remove checkpoints of %s: %s", container.ID, err)
+	}
+
 	if err := daemon.driver.Remove(container.ID); err != nil {
 		return fmt.Errorf("Driver %s failed to remove root filesystem %s: %s", daemon.driver, container.ID, err)
 	}
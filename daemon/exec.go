@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"sort"
 	"strings"
 	"sync"
 
@@ -69,6 +70,22 @@ func (e *execStore) List() []string {
 	return IDs
 }
 
+// ListRunning returns the IDs of the exec commands which are running.
+func (e *execStore) ListRunning() []string {
+	var IDs []string
+	e.RLock()
+	for id, execConfig := range e.s {
+		execConfig.Lock()
+		if execConfig.Running {
+			IDs = append(IDs, id)
+		}
+		execConfig.Unlock()
+	}
+	e.RUnlock()
+	sort.Strings(IDs)
+	return IDs
+}
+
 func (execConfig *execConfig) Resize(h, w int) error {
 	return execConfig.ProcessConfig.Terminal.Resize(h, w)
 }
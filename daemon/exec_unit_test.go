@@ -0,0 +1,20 @@
+package daemon
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExecStoreListRunning(t *testing.T) {
+	store := newExecStore()
+	store.Add("b", &execConfig{ID: "b", Running: true})
+	store.Add("exited", &execConfig{ID: "exited"})
+	store.Add("a", &execConfig{ID: "a", Running: true})
+
+	if running := store.ListRunning(); !reflect.DeepEqual(running, []string{"a", "b"}) {
+		t.Fatalf("expected the running exec commands to be [a b], got %v", running)
+	}
+	if running := newExecStore().ListRunning(); len(running) != 0 {
+		t.Fatalf("expected no running exec commands, got %v", running)
+	}
+}
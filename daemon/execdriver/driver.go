@@ -5,7 +5,9 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"time"
 
+	"github.com/docker/docker/nat"
 	"github.com/docker/libcontainer/devices"
 )
 
@@ -26,6 +28,9 @@ type StartCallback func(*ProcessConfig, int)
 // processes registered with the driver
 type Info interface {
 	IsRunning() bool
+	// CriuVersion is the version of criu checkpointing and restoring the
+	// container, empty if it can't be.
+	CriuVersion() string
 }
 
 // Terminal in an interface for drivers to implement
@@ -56,13 +61,17 @@ type Driver interface {
 	Kill(c *Command, sig int) error
 	Pause(c *Command) error
 	Unpause(c *Command) error
+	IsPaused(id string) (bool, error)             // Reports whether the container is actually frozen, whoever froze it
 	Name() string                                 // Driver name
 	Info(id string) Info                          // "temporary" hack (until we move state from core to plugins)
 	GetPidsForContainer(id string) ([]int, error) // Returns a list of pids for the given container.
 	Terminate(c *Command) error                   // kill it with fire
 	Clean(id string) error                        // clean all traces of container exec
+	Wait(c *Command, timeout time.Duration) error // Wait blocks until the container exits, or returns ErrWaitTimeoutReached after timeout. A negative timeout waits forever
 	Checkpoint(checkpoint *Checkpoint, stop bool) error
 	Restore(checkpoint *Checkpoint, pipes *Pipes, startCallback StartCallback) (ExitStatus, error)
+	ReceiveCheckpoint(imagePath string, port int) error // Starts a page server in the background storing the pages of a checkpoint dumped on another host into imagePath
+	CheckRestore(checkpoint *Checkpoint) error          // Checks whether the checkpoint could be restored, without restoring it
 }
 
 // Network settings of the container
@@ -109,6 +118,7 @@ type Mount struct {
 	Writable    bool   `json:"writable"`
 	Private     bool   `json:"private"`
 	Slave       bool   `json:"slave"`
+	Relabel     string `json:"relabel"` // relabel the source with the mount label, "z" if shared, "Z" if private
 }
 
 // Describes a process that will be run inside a container.
@@ -129,6 +139,7 @@ type Command struct {
 	ID                 string            `json:"id"`
 	Rootfs             string            `json:"rootfs"` // root fs of the container
 	ReadonlyRootfs     bool              `json:"readonly_rootfs"`
+	DisableOomNotify   bool              `json:"disable_oom_notify"`
 	InitPath           string            `json:"initpath"` // dockerinit
 	WorkingDir         string            `json:"working_dir"`
 	ConfigPath         string            `json:"config_path"` // this should be able to be removed when the lxc template is moved into the driver
@@ -147,11 +158,70 @@ type Command struct {
 	MountLabel         string            `json:"mount_label"`
 	LxcConfig          []string          `json:"lxc_config"`
 	AppArmorProfile    string            `json:"apparmor_profile"`
+	CgroupParent       string            `json:"cgroup_parent"` // cgroup parent to place the container under, defaults to the driver's one
 }
 
 // Checkpoint context
 type Checkpoint struct {
-	Command   *Command
-	ImagePath string
-	Volumes   map[string]string
+	Command       *Command
+	ImagePath     string
+	StorageDriver string // name of the graph driver backing the container's rootfs
+
+	// Images path of the checkpoint to dump incrementally on top of, if any
+	ParentImagePath string
+
+	// Only dump the memory pages, leaving the processes running, for a
+	// later dump on top of it to be quicker. A pre-dump can't be restored.
+	PreDump bool
+
+	EmptyNetNs bool // restore into an empty network namespace, without attaching to the bridge
+
+	// Namespaces whose state is left out of the checkpoint, e.g. "net".
+	// They are created empty on restore.
+	SkipNamespaces []string
+
+	// Restore behind the host NAT, routing the container's address to its
+	// veth and forwarding NatPorts to it, instead of attaching to the bridge
+	NatNetwork bool
+	NatPorts   nat.PortMap
+
+	// host:port of a page server to stream memory pages to instead of
+	// writing them into ImagePath
+	PageServer string
+
+	// criu stays the parent of the restored processes and exits with the
+	// status of the root one, instead of restoring them detached as
+	// siblings of criu, e.g. for foreground interactive containers.
+	Attached bool
+
+	// The container was frozen by the caller, e.g. along with other
+	// containers to dump them consistently. It is dumped through its
+	// freezer cgroup as is and left frozen, thawing it is up to the caller.
+	Frozen bool
+
+	// Dump the established TCP connections of the container, which criu
+	// refuses to otherwise, and restore them. It takes the TCP repair mode
+	// of linux >= 3.5, and a checkpoint dumped with it is only restorable
+	// with it, with the address the connections are bound to.
+	TcpEstablished bool
+
+	// The process tree was started by a shell, e.g. with a tty, its
+	// session and process group being outside of it.
+	ShellJob bool
+	// Dump the file locks held by the processes, which criu refuses to
+	// dump otherwise, and take them again on restore.
+	FileLocks bool
+
+	// Called with the progress of the dump as it proceeds, if not nil
+	Progress func(status string)
+
+	// How long criu waits for the tasks to be frozen when dumping, e.g.
+	// for processes with threads stuck in uninterruptible sleep. The
+	// default of criu is used if 0.
+	CriuTimeout time.Duration
+
+	// How long criu is given to restore the processes before it is killed
+	// and the restore fails with ErrWaitTimeoutReached, e.g. if it hangs
+	// on some kernels. No limit if 0.
+	RestoreTimeout time.Duration
 }
@@ -233,10 +233,33 @@ func (d *driver) Unpause(c *execdriver.Command) error {
 	return err
 }
 
+func (d *driver) IsPaused(id string) (bool, error) {
+	output, err := d.getInfo(id)
+	if err != nil {
+		return false, fmt.Errorf("Err: %s Output: %s", err, output)
+	}
+	info, err := parseLxcInfo(string(output))
+	if err != nil {
+		return false, err
+	}
+	return info.Frozen, nil
+}
+
 func (d *driver) Terminate(c *execdriver.Command) error {
 	return KillLxc(c.ID, 9)
 }
 
+func (d *driver) Wait(c *execdriver.Command, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for d.Info(c.ID).IsRunning() {
+		if timeout >= 0 && time.Now().After(deadline) {
+			return execdriver.ErrWaitTimeoutReached
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil
+}
+
 func (d *driver) Checkpoint(_ *execdriver.Checkpoint, _ bool) error {
 	return fmt.Errorf("NOT SUPPORTED")
 }
@@ -245,6 +268,14 @@ func (d *driver) Restore(_ *execdriver.Checkpoint, _ *execdriver.Pipes, _ execdr
 	return execdriver.ExitStatus{ExitCode: -1, OOMKilled: false}, fmt.Errorf("NOT SUPPORTED")
 }
 
+func (d *driver) ReceiveCheckpoint(_ string, _ int) error {
+	return fmt.Errorf("NOT SUPPORTED")
+}
+
+func (d *driver) CheckRestore(_ *execdriver.Checkpoint) error {
+	return fmt.Errorf("NOT SUPPORTED")
+}
+
 func (d *driver) version() string {
 	var (
 		version string
@@ -338,6 +369,11 @@ func (i *info) IsRunning() bool {
 	return running
 }
 
+// Checkpointing and restoring is only supported by the native driver
+func (i *info) CriuVersion() string {
+	return ""
+}
+
 func (d *driver) Info(id string) execdriver.Info {
 	return &info{
 		ID:     id,
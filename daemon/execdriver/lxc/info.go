@@ -13,6 +13,7 @@ var (
 
 type lxcInfo struct {
 	Running bool
+	Frozen  bool
 	Pid     int
 }
 
@@ -39,6 +40,7 @@ func parseLxcInfo(raw string) (*lxcInfo, error) {
 		switch strings.ToLower(strings.TrimSpace(parts[0])) {
 		case "state":
 			info.Running = strings.TrimSpace(parts[1]) == "RUNNING"
+			info.Frozen = strings.TrimSpace(parts[1]) == "FROZEN"
 		case "pid":
 			info.Pid, err = strconv.Atoi(strings.TrimSpace(parts[1]))
 			if err != nil {
@@ -21,6 +21,23 @@ func TestParseRunningInfo(t *testing.T) {
 	}
 }
 
+func TestParseFrozenInfo(t *testing.T) {
+	raw := `
+    state: FROZEN
+    pid:    50`
+
+	info, err := parseLxcInfo(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Frozen {
+		t.Fatal("info should return a frozen state")
+	}
+	if info.Running {
+		t.Fatal("info should not return a running state")
+	}
+}
+
 func TestEmptyInfo(t *testing.T) {
 	_, err := parseLxcInfo("")
 	if err == nil {
@@ -190,6 +190,9 @@ func (d *driver) setCapabilities(container *libcontainer.Config, c *execdriver.C
 }
 
 func (d *driver) setupCgroups(container *libcontainer.Config, c *execdriver.Command) error {
+	if c.CgroupParent != "" {
+		container.Cgroups.Parent = c.CgroupParent
+	}
 	if c.Resources != nil {
 		container.Cgroups.CpuShares = c.Resources.CpuShares
 		container.Cgroups.Memory = c.Resources.Memory
@@ -210,6 +213,7 @@ func (d *driver) setupMounts(container *libcontainer.Config, c *execdriver.Comma
 			Writable:    m.Writable,
 			Private:     m.Private,
 			Slave:       m.Slave,
+			Relabel:     m.Relabel,
 		})
 	}
 
@@ -0,0 +1,145 @@
+// +build linux,cgo
+
+package native
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Number of the last errors reported by criu, or of the last lines of its
+// log if it reported none, kept to explain a failure
+const maxCriuErrors = 10
+
+// Errors reported by criu, which may be prefixed by the time since criu
+// started like any line of its log.
+var criuErrorPattern = regexp.MustCompile(`^(?:\([0-9.]+\) )?Error`)
+
+// Stages of a dump reported by criu in its log, and how they are reported
+// as progress. The lines may be prefixed by the time since criu started.
+var criuDumpStages = []struct {
+	re     *regexp.Regexp
+	status string // formatted with the submatches of re
+}{
+	{regexp.MustCompile(`^(?:\([0-9.]+\) )?Dumping processes \(pid: (\d+)\)`), "Freezing the process tree of %s"},
+	{regexp.MustCompile(`^(?:\([0-9.]+\) )?Dumping task \(pid: (\d+)\)`), "Dumping process %s"},
+	{regexp.MustCompile(`^(?:\([0-9.]+\) )?Dumping pages \(type: \d+ pid: (\d+)\)`), "Dumping memory pages of process %s"},
+	{regexp.MustCompile(`^(?:\([0-9.]+\) )?Writing image inventory`), "Writing the image inventory"},
+	{regexp.MustCompile(`^(?:\([0-9.]+\) )?Dumping finished successfully`), "Dump finished"},
+}
+
+// criuDumpProgress returns the progress reported by a line of the criu log
+// while dumping, if any.
+func criuDumpProgress(line string) (string, bool) {
+	for _, stage := range criuDumpStages {
+		m := stage.re.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		args := make([]interface{}, len(m)-1)
+		for i, arg := range m[1:] {
+			args[i] = arg
+		}
+		return fmt.Sprintf(stage.status, args...), true
+	}
+	return "", false
+}
+
+// criuLogger is an io.Writer which sends each line of the criu log written
+// to it into the daemon log, tagged with the container and the phase of
+// criu. Errors reported by criu are logged at error level and anything
+// else, which is mostly progress, at debug level.
+type criuLogger struct {
+	entry    *log.Entry
+	buf      bytes.Buffer
+	errors   []string
+	tail     []string            // last lines logged, to explain a failure criu didn't report
+	progress func(status string) // called with the progress of a dump, if not nil
+}
+
+func newCriuLogger(id, phase string) *criuLogger {
+	return &criuLogger{
+		entry: log.WithFields(log.Fields{
+			"container": id,
+			"criu":      phase,
+		}),
+	}
+}
+
+func (l *criuLogger) Write(p []byte) (int, error) {
+	l.buf.Write(p)
+	for {
+		line, err := l.buf.ReadString('\n')
+		if err != nil {
+			// Keep the incomplete line until the rest of it is written
+			l.buf.WriteString(line)
+			break
+		}
+		l.logLine(strings.TrimRight(line, "\n"))
+	}
+	return len(p), nil
+}
+
+// Close flushes the last line if it wasn't terminated by a newline.
+func (l *criuLogger) Close() error {
+	if l.buf.Len() > 0 {
+		l.logLine(l.buf.String())
+		l.buf.Reset()
+	}
+	return nil
+}
+
+func (l *criuLogger) logLine(line string) {
+	if line == "" {
+		return
+	}
+	if criuErrorPattern.MatchString(line) {
+		l.entry.Errorf("%s", line)
+		if len(l.errors) == maxCriuErrors {
+			l.errors = l.errors[1:]
+		}
+		l.errors = append(l.errors, line)
+	} else {
+		l.entry.Debugf("%s", line)
+	}
+	if len(l.tail) == maxCriuErrors {
+		l.tail = l.tail[1:]
+	}
+	l.tail = append(l.tail, line)
+	if l.progress != nil {
+		if status, ok := criuDumpProgress(line); ok {
+			l.progress(status)
+		}
+	}
+}
+
+// errorExcerpt returns the last errors reported by criu, one per line, or
+// the last lines of its log if it reported none, e.g. when killed.
+func (l *criuLogger) errorExcerpt() string {
+	if len(l.errors) == 0 {
+		return strings.Join(l.tail, "\n")
+	}
+	return strings.Join(l.errors, "\n")
+}
+
+// logCriuLogFile sends the content of a criu log file into the daemon log,
+// returning the excerpt of the errors reported in it.
+func logCriuLogFile(path, id, phase string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	logger := newCriuLogger(id, phase)
+	_, err = io.Copy(logger, bufio.NewReader(f))
+	logger.Close()
+	return logger.errorExcerpt(), err
+}
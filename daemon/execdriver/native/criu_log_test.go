@@ -0,0 +1,35 @@
+// +build linux,cgo
+
+package native
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCriuLoggerErrorExcerpt(t *testing.T) {
+	logger := newCriuLogger("container", "restore")
+	fmt.Fprintf(logger, "(00.000123) Restoring processes\n")
+	fmt.Fprintf(logger, "(00.000456) Error (cr-restore.c:1234): Can't open file\n")
+	fmt.Fprintf(logger, "(00.000789) Restoring finished")
+	logger.Close()
+	if excerpt := logger.errorExcerpt(); excerpt != "(00.000456) Error (cr-restore.c:1234): Can't open file" {
+		t.Fatalf("expected the excerpt to be the error, got %q", excerpt)
+	}
+
+	logger = newCriuLogger("container", "restore")
+	for i := 0; i < maxCriuErrors+2; i++ {
+		fmt.Fprintf(logger, "line %d\n", i)
+	}
+	logger.Close()
+	expected := ""
+	for i := 2; i < maxCriuErrors+2; i++ {
+		if expected != "" {
+			expected += "\n"
+		}
+		expected += fmt.Sprintf("line %d", i)
+	}
+	if excerpt := logger.errorExcerpt(); excerpt != expected {
+		t.Fatalf("expected the excerpt to be the tail of the log %q, got %q", expected, excerpt)
+	}
+}
@@ -0,0 +1,115 @@
+// +build linux,cgo
+
+package native
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+var (
+	// The oldest criu with --manage-cgroups, --ext-mount-map and
+	// --evasive-devices, which every dump and restore is given
+	minCriuVersion = criuVersion{1, 3, 0}
+	// The oldest criu with --restore-sibling, to restore detached
+	restoreSiblingCriuVersion = criuVersion{1, 4, 0}
+)
+
+type criuVersion struct {
+	Major, Minor, Sublevel int
+}
+
+func (v criuVersion) String() string {
+	if v.Sublevel == 0 {
+		return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+	}
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Sublevel)
+}
+
+func (v criuVersion) atLeast(other criuVersion) bool {
+	if v.Major != other.Major {
+		return v.Major > other.Major
+	}
+	if v.Minor != other.Minor {
+		return v.Minor > other.Minor
+	}
+	return v.Sublevel >= other.Sublevel
+}
+
+// parseCriuVersion parses the output of criu --version, e.g.
+//
+//	Version: 1.4
+//	GitID: v1.4-rc1-12-gc2f3d1a
+func parseCriuVersion(output string) (criuVersion, error) {
+	var v criuVersion
+	s := bufio.NewScanner(strings.NewReader(output))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if !strings.HasPrefix(line, "Version:") {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(line, "Version:")), ".", 3)
+		if len(parts) < 2 {
+			return v, fmt.Errorf("cannot parse criu version %q", line)
+		}
+		levels := []*int{&v.Major, &v.Minor, &v.Sublevel}
+		for i, part := range parts {
+			// Release candidates are suffixed, e.g. 1.5-rc2
+			if end := strings.IndexFunc(part, func(r rune) bool { return r < '0' || r > '9' }); end >= 0 {
+				part = part[:end]
+			}
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return v, fmt.Errorf("cannot parse criu version %q", line)
+			}
+			*levels[i] = n
+		}
+		return v, nil
+	}
+	return v, fmt.Errorf("no version in the output of criu --version: %q", output)
+}
+
+// criuFeatures is what the criu found by the driver supports, beyond what
+// minCriuVersion guarantees.
+type criuFeatures struct {
+	Path           string
+	Version        criuVersion
+	RestoreSibling bool
+}
+
+// criuFeatures finds criu and checks that it is recent enough, once it is
+// found. Until then it is looked up again every time, so that criu can be
+// installed without restarting the daemon.
+func (d *driver) criuFeatures() (*criuFeatures, error) {
+	d.criuLock.Lock()
+	defer d.criuLock.Unlock()
+	if d.criu != nil {
+		return d.criu, nil
+	}
+
+	path, err := d.lookupCriu()
+	if err != nil {
+		return nil, err
+	}
+	output, err := exec.Command(path, "--version").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run %s --version: %s; %s", path, err, bytes.TrimSpace(output))
+	}
+	version, err := parseCriuVersion(string(output))
+	if err != nil {
+		return nil, err
+	}
+	if !version.atLeast(minCriuVersion) {
+		return nil, fmt.Errorf("criu >= %s required, %s is %s", minCriuVersion, path, version)
+	}
+	d.criu = &criuFeatures{
+		Path:           path,
+		Version:        version,
+		RestoreSibling: version.atLeast(restoreSiblingCriuVersion),
+	}
+	return d.criu, nil
+}
@@ -0,0 +1,48 @@
+// +build linux,cgo
+
+package native
+
+import (
+	"testing"
+)
+
+func TestParseCriuVersion(t *testing.T) {
+	for output, expected := range map[string]criuVersion{
+		"Version: 1.4\n":   {1, 4, 0},
+		"Version: 1.3.1\n": {1, 3, 1},
+		"Version: 1.5-rc2\nGitID: v1.5-rc2-3-gab12cd\n": {1, 5, 0},
+		"Version: 2.0\nGitID: v2.0\n":                   {2, 0, 0},
+	} {
+		v, err := parseCriuVersion(output)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %s", output, err)
+		}
+		if v != expected {
+			t.Fatalf("expected %q to be version %s, got %s", output, expected, v)
+		}
+	}
+
+	for _, output := range []string{"", "criu: unknown option\n", "Version: 1\n", "Version: x.y\n"} {
+		if v, err := parseCriuVersion(output); err == nil {
+			t.Fatalf("expected %q to fail parsing, got version %s", output, v)
+		}
+	}
+}
+
+func TestCriuVersionAtLeast(t *testing.T) {
+	for _, c := range []struct {
+		v, other criuVersion
+		atLeast  bool
+	}{
+		{criuVersion{1, 4, 0}, minCriuVersion, true},
+		{criuVersion{1, 3, 0}, minCriuVersion, true},
+		{criuVersion{1, 2, 9}, minCriuVersion, false},
+		{criuVersion{0, 9, 0}, minCriuVersion, false},
+		{criuVersion{2, 0, 0}, restoreSiblingCriuVersion, true},
+		{criuVersion{1, 3, 2}, restoreSiblingCriuVersion, false},
+	} {
+		if c.v.atLeast(c.other) != c.atLeast {
+			t.Fatalf("expected %s at least %s to be %v", c.v, c.other, c.atLeast)
+		}
+	}
+}
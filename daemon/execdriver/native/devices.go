@@ -0,0 +1,131 @@
+// +build linux,cgo
+
+package native
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// checkpointDevice describes a device file which a checkpointed process
+// had open, so that it can be declared to criu and verified on restore.
+type checkpointDevice struct {
+	Type  string `json:"type"` // "char" or "block", as in /sys/dev
+	Major uint32 `json:"major"`
+	Minor uint32 `json:"minor"`
+	Path  string `json:"path"` // path the device was opened with in the container
+}
+
+func (dev *checkpointDevice) id() string {
+	return fmt.Sprintf("%d:%d", dev.Major, dev.Minor)
+}
+
+// externalKey is the key used to refer to the device in criu's external
+// resource declarations.
+func (dev *checkpointDevice) externalKey() string {
+	return fmt.Sprintf("dev%d_%d", dev.Major, dev.Minor)
+}
+
+// findOpenDevices returns the devices which are opened by any of pids.
+func findOpenDevices(pids []int) ([]*checkpointDevice, error) {
+	var (
+		devices []*checkpointDevice
+		seen    = make(map[uint64]bool)
+	)
+	for _, pid := range pids {
+		fdDir := fmt.Sprintf("/proc/%d/fd", pid)
+		fds, err := ioutil.ReadDir(fdDir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// the process has gone away meanwhile
+				continue
+			}
+			return nil, err
+		}
+		for _, fd := range fds {
+			var st syscall.Stat_t
+			if err := syscall.Stat(filepath.Join(fdDir, fd.Name()), &st); err != nil {
+				continue
+			}
+			var devType string
+			switch st.Mode & syscall.S_IFMT {
+			case syscall.S_IFCHR:
+				devType = "char"
+			case syscall.S_IFBLK:
+				devType = "block"
+			default:
+				continue
+			}
+			if seen[st.Rdev] {
+				continue
+			}
+			if devType == "char" && isPtySlave(st.Rdev) {
+				// the console is declared along with the stdio
+				continue
+			}
+			seen[st.Rdev] = true
+			path, _ := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			devices = append(devices, &checkpointDevice{
+				Type:  devType,
+				Major: uint32((st.Rdev >> 8) & 0xfff),
+				Minor: uint32((st.Rdev & 0xff) | ((st.Rdev >> 12) & 0xfff00)),
+				Path:  path,
+			})
+		}
+	}
+	return devices, nil
+}
+
+func writeCheckpointDevices(imagePath string, devices []*checkpointDevice) error {
+	data, err := json.Marshal(devices)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(imagePath, "devices.json"), data, 0644)
+}
+
+func readCheckpointDevices(imagePath string) ([]*checkpointDevice, error) {
+	data, err := ioutil.ReadFile(filepath.Join(imagePath, "devices.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var devices []*checkpointDevice
+	if err := json.Unmarshal(data, &devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// deviceDumpArgs declares the block devices as external to criu, which
+// otherwise refuses to dump them.
+func deviceDumpArgs(devices []*checkpointDevice) []string {
+	var args []string
+	for _, dev := range devices {
+		if dev.Type == "block" {
+			args = append(args, "--external", fmt.Sprintf("dev[%d/%d]:%s", dev.Major, dev.Minor, dev.externalKey()))
+		}
+	}
+	return args
+}
+
+// deviceRestoreArgs verifies that all the devices exist on this host and
+// returns the criu arguments to resolve the external ones.
+func deviceRestoreArgs(devices []*checkpointDevice) ([]string, error) {
+	var args []string
+	for _, dev := range devices {
+		if _, err := os.Stat(filepath.Join("/sys/dev", dev.Type, dev.id())); err != nil {
+			return nil, fmt.Errorf("%s device %s (%s) doesn't exist on this host", dev.Type, dev.id(), dev.Path)
+		}
+		if dev.Type == "block" {
+			args = append(args, "--external", fmt.Sprintf("dev[%s]:%s", dev.externalKey(), filepath.Join("/dev/block", dev.id())))
+		}
+	}
+	return args, nil
+}
@@ -3,31 +3,37 @@
 package native
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/daemon/execdriver"
 	"github.com/docker/docker/pkg/term"
 	"github.com/docker/libcontainer"
 	"github.com/docker/libcontainer/apparmor"
+	"github.com/docker/libcontainer/cgroups"
 	"github.com/docker/libcontainer/cgroups/fs"
 	"github.com/docker/libcontainer/cgroups/systemd"
 	consolepkg "github.com/docker/libcontainer/console"
+	"github.com/docker/libcontainer/label"
 	"github.com/docker/libcontainer/namespaces"
 	_ "github.com/docker/libcontainer/namespaces/nsenter"
+	"github.com/docker/libcontainer/netlink"
+	"github.com/docker/libcontainer/network"
 	"github.com/docker/libcontainer/system"
 	"github.com/docker/libcontainer/utils"
-	"github.com/docker/libcontainer/network"
 )
 
 const (
@@ -43,11 +49,19 @@ type activeContainer struct {
 type driver struct {
 	root             string
 	initPath         string
+	criuBinary       string
 	activeContainers map[string]*activeContainer
+	reaper           *orphanReaper
 	sync.Mutex
+
+	// serializes freezing and thawing the cgroups of the containers
+	freezerLock sync.Mutex
+
+	criuLock sync.Mutex
+	criu     *criuFeatures // nil until a recent enough criu is found
 }
 
-func NewDriver(root, initPath string) (*driver, error) {
+func NewDriver(root, initPath, criuBinary string) (*driver, error) {
 	if err := os.MkdirAll(root, 0700); err != nil {
 		return nil, err
 	}
@@ -57,11 +71,70 @@ func NewDriver(root, initPath string) (*driver, error) {
 		return nil, err
 	}
 
-	return &driver{
+	d := &driver{
 		root:             root,
 		initPath:         initPath,
+		criuBinary:       criuBinary,
 		activeContainers: make(map[string]*activeContainer),
-	}, nil
+	}
+	if err := d.loadActiveContainers(); err != nil {
+		log.Warnf("Cannot reload the containers left running: %s", err)
+	}
+	reaper, err := newOrphanReaper(d.GetPidsForContainer)
+	if err != nil {
+		log.Warnf("Cannot become a child subreaper, orphans of restored containers will not be reaped: %s", err)
+	}
+	d.reaper = reaper
+	if criu, err := d.criuFeatures(); err != nil {
+		log.Warnf("Containers cannot be checkpointed or restored: %s", err)
+	} else {
+		log.Debugf("Using criu %s at %s", criu.Version, criu.Path)
+	}
+	return d, nil
+}
+
+// loadActiveContainers rebuilds the active containers from the ones left
+// running by a previous daemon, so that they can still be paused, inspected
+// and so on. Containers whose init is gone, or whose pid was reused by
+// another process, are skipped.
+func (d *driver) loadActiveContainers() error {
+	dirs, err := ioutil.ReadDir(d.root)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		id := dir.Name()
+		container, err := d.readContainerFile(id)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Warnf("Cannot reload container %s: %s", id, err)
+			}
+			continue
+		}
+		state, err := libcontainer.GetState(filepath.Join(d.root, id))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Warnf("Cannot reload container %s: %s", id, err)
+			}
+			continue
+		}
+		if startTime, err := system.GetProcessStartTime(state.InitPid); err != nil || startTime != state.InitStartTime {
+			continue
+		}
+		process, err := os.FindProcess(state.InitPid)
+		if err != nil {
+			continue
+		}
+		d.activeContainers[id] = &activeContainer{
+			container: container,
+			cmd:       &exec.Cmd{Process: process},
+		}
+		log.Debugf("Reloaded running container %s with pid %d", id, state.InitPid)
+	}
+	return nil
 }
 
 type execOutput struct {
@@ -141,6 +214,19 @@ func (d *driver) run(c *execdriver.Command, pipes *execdriver.Pipes, executer ex
 		return execdriver.ExitStatus{ExitCode: -1}, err
 	}
 
+	// Arm the OOM notification before the process is started if we can,
+	// otherwise it would miss OOM kills right after the start.
+	var (
+		oomKillNotification <-chan struct{}
+		memoryPath          string
+	)
+	if !c.DisableOomNotify {
+		oomKillNotification, memoryPath, err = notifyOnOOMEarly(container.Cgroups)
+		if err != nil {
+			log.Debugf("Cannot set up OOM notification before starting %s: %s", c.ID, err)
+		}
+	}
+
 	execOutputChan := make(chan execOutput, 1)
 	waitForStart := make(chan struct{})
 
@@ -151,23 +237,41 @@ func (d *driver) run(c *execdriver.Command, pipes *execdriver.Pipes, executer ex
 
 	select {
 	case execOutput := <-execOutputChan:
-		return execdriver.ExitStatus{ExitCode: execOutput.exitCode}, execOutput.err
+		oomKill := false
+		if oomKillNotification != nil {
+			// the cgroup may not have been used at all if it failed early
+			os.Remove(memoryPath)
+			oomKill = receiveOOMKill(oomKillNotification)
+		}
+		return execdriver.ExitStatus{ExitCode: execOutput.exitCode, OOMKilled: oomKill}, execOutput.err
 	case <-waitForStart:
 		break
 	}
 
-	oomKill := false
-	// TODO have to create and save state manually when restoring
-	state, err := libcontainer.GetState(filepath.Join(d.root, c.ID))
-	if err == nil {
-		oomKillNotification, err := libcontainer.NotifyOnOOM(state)
+	// A restored container is put back into the cgroups it was dumped
+	// from, which may not be the one the notification was armed on.
+	if oomKillNotification != nil {
+		if state, err := libcontainer.GetState(dataPath); err == nil {
+			if path := state.CgroupPaths["memory"]; path != "" && path != memoryPath {
+				os.Remove(memoryPath)
+				oomKillNotification = nil
+			}
+		}
+	}
+	if oomKillNotification == nil && !c.DisableOomNotify {
+		state, err := libcontainer.GetState(dataPath)
 		if err == nil {
-			_, oomKill = <-oomKillNotification
+			oomKillNotification, err = libcontainer.NotifyOnOOM(state)
+			if err != nil {
+				log.Warnf("WARNING: Your kernel does not support OOM notifications: %s", err)
+			}
 		} else {
-			log.Warnf("WARNING: Your kernel does not support OOM notifications: %s", err)
+			log.Warnf("Failed to get container state, oom notify will not work: %s", err)
 		}
-	} else {
-		log.Warnf("Failed to get container state, oom notify will not work: %s", err)
+	}
+	oomKill := false
+	if oomKillNotification != nil {
+		_, oomKill = <-oomKillNotification
 	}
 	// wait for the container to exit.
 	execOutput := <-execOutputChan
@@ -187,27 +291,47 @@ func (d *driver) Kill(p *execdriver.Command, sig int) error {
 }
 
 func (d *driver) Pause(c *execdriver.Command) error {
-	active := d.activeContainers[c.ID]
-	if active == nil {
-		return fmt.Errorf("active container for %s does not exist", c.ID)
-	}
-	active.container.Cgroups.Freezer = "FROZEN"
-	if systemd.UseSystemd() {
-		return systemd.Freeze(active.container.Cgroups, active.container.Cgroups.Freezer)
-	}
-	return fs.Freeze(active.container.Cgroups, active.container.Cgroups.Freezer)
+	return d.setFreezerState(c.ID, cgroups.Frozen)
 }
 
 func (d *driver) Unpause(c *execdriver.Command) error {
-	active := d.activeContainers[c.ID]
+	return d.setFreezerState(c.ID, cgroups.Thawed)
+}
+
+// setFreezerState freezes or thaws the cgroup of the container. Freezing
+// waits for the cgroup to be reported frozen, so the transitions are
+// serialized: an unpause interleaved with a pause could otherwise thaw the
+// cgroup before the pause writes FROZEN, leaving it frozen, or leave the
+// pause waiting forever for a state the cgroup was moved out of.
+func (d *driver) setFreezerState(id string, state cgroups.FreezerState) error {
+	d.freezerLock.Lock()
+	defer d.freezerLock.Unlock()
+
+	d.Lock()
+	active := d.activeContainers[id]
+	d.Unlock()
 	if active == nil {
-		return fmt.Errorf("active container for %s does not exist", c.ID)
+		return fmt.Errorf("active container for %s does not exist", id)
 	}
-	active.container.Cgroups.Freezer = "THAWED"
-	if systemd.UseSystemd() {
-		return systemd.Freeze(active.container.Cgroups, active.container.Cgroups.Freezer)
+	return freeze(active.container.Cgroups, state)
+}
+
+// IsPaused reads the state of the container's freezer cgroup rather than
+// trusting the one last set by Pause, so that freezes made from outside or
+// before the daemon restarted are reported as well.
+func (d *driver) IsPaused(id string) (bool, error) {
+	state, err := libcontainer.GetState(filepath.Join(d.root, id))
+	if err != nil {
+		return false, err
+	}
+	path, ok := state.CgroupPaths["freezer"]
+	if !ok {
+		// there is no freezer subsystem in the unified hierarchy
+		if path, err = freezerCgroupPath(state.InitPid); err != nil {
+			return false, err
+		}
 	}
-	return fs.Freeze(active.container.Cgroups, active.container.Cgroups.Freezer)
+	return isFrozen(path)
 }
 
 func (d *driver) Terminate(p *execdriver.Command) error {
@@ -231,14 +355,26 @@ func (d *driver) Terminate(p *execdriver.Command) error {
 		state = &libcontainer.State{InitStartTime: string(data)}
 	}
 
-	currentStartTime, err := system.GetProcessStartTime(p.ProcessConfig.Process.Pid)
+	// The process is unknown if the container never fully started or its
+	// state was lost in a crash, fall back to the one persisted.
+	pid := state.InitPid
+	if p.ProcessConfig.Process != nil {
+		pid = p.ProcessConfig.Process.Pid
+	}
+	if pid == 0 {
+		d.cleanContainer(p.ID)
+		return nil
+	}
+
+	currentStartTime, err := system.GetProcessStartTime(pid)
 	if err != nil {
+		d.cleanContainer(p.ID)
 		return err
 	}
 
 	if state.InitStartTime == currentStartTime {
-		err = syscall.Kill(p.ProcessConfig.Process.Pid, 9)
-		syscall.Wait4(p.ProcessConfig.Process.Pid, nil, 0, nil)
+		err = syscall.Kill(pid, 9)
+		syscall.Wait4(pid, nil, 0, nil)
 	}
 	d.cleanContainer(p.ID)
 
@@ -246,6 +382,24 @@ func (d *driver) Terminate(p *execdriver.Command) error {
 
 }
 
+func (d *driver) Wait(c *execdriver.Command, timeout time.Duration) error {
+	// The process is reaped by the goroutine which started it, so just
+	// watch for the container to be unregistered once it has exited.
+	deadline := time.Now().Add(timeout)
+	for {
+		d.Lock()
+		active := d.activeContainers[c.ID]
+		d.Unlock()
+		if active == nil {
+			return nil
+		}
+		if timeout >= 0 && time.Now().After(deadline) {
+			return execdriver.ErrWaitTimeoutReached
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 func (d *driver) Info(id string) execdriver.Info {
 	return &info{
 		ID:     id,
@@ -281,6 +435,18 @@ func (d *driver) writeContainerFile(container *libcontainer.Config, id string) e
 	return ioutil.WriteFile(filepath.Join(d.root, id, "container.json"), data, 0655)
 }
 
+func (d *driver) readContainerFile(id string) (*libcontainer.Config, error) {
+	data, err := ioutil.ReadFile(filepath.Join(d.root, id, "container.json"))
+	if err != nil {
+		return nil, err
+	}
+	var container *libcontainer.Config
+	if err := json.Unmarshal(data, &container); err != nil {
+		return nil, err
+	}
+	return container, nil
+}
+
 func (d *driver) cleanContainer(id string) error {
 	d.Lock()
 	delete(d.activeContainers, id)
@@ -303,85 +469,426 @@ func (d *driver) Checkpoint(checkpoint *execdriver.Checkpoint, stop bool) error
 		return fmt.Errorf("active container for %s does not exist", c.ID)
 	}
 
-	pipesMap := make(map[string]string)
-	for i := 0; i < 3; i++ {
-		path, err := os.Readlink(fmt.Sprintf("/proc/%d/fd/%d", c.ContainerPid, i))
+	criu, err := d.criuPath()
+	if err != nil {
+		return err
+	}
+
+	if err := checkTmpfsDump(c.ContainerPid); err != nil {
+		return err
+	}
+
+	stdioArgs, err := checkpointStdio(checkpoint.ImagePath, c.ContainerPid)
+	if err != nil {
+		return err
+	}
+
+	// A pre-dump only dumps the memory, leaving the processes running
+	op := "dump"
+	if checkpoint.PreDump {
+		op = "pre-dump"
+	}
+	workDir, err := d.criuWorkDir(c.ID, op)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	cmdArgs := []string{
+		op,
+		"-v4",
+		"-o", "/dev/stdout",
+		"--work-dir", workDir,
+		"--manage-cgroups",
+		"--evasive-devices",
+		// keyed by its path in the container, the dockerinit of the
+		// daemon restoring it is bound there whatever its version
+		"--ext-mount-map", c.InitPath + ":" + c.InitPath,
+		"-D", checkpoint.ImagePath,
+		// Always the whole tree: criu can't dump a part of a PID
+		// namespace without its init, and restoring a subtree would
+		// mean joining the PID and mount namespaces of the running
+		// container, which criu doesn't support either.
+		"-t", fmt.Sprintf("%d", c.ContainerPid),
+		"--root", c.Rootfs,
+	}
+	cmdArgs = append(cmdArgs, extMountMapArgs(c.Mounts, false)...)
+	cmdArgs = append(cmdArgs, dumpRestoreArgs(checkpoint)...)
+	cmdArgs = append(cmdArgs, stdioArgs...)
+	nsArgs, err := skipNamespaceArgs(checkpoint.SkipNamespaces)
+	if err != nil {
+		return err
+	}
+	cmdArgs = append(cmdArgs, nsArgs...)
+	cmdArgs = append(cmdArgs, storageDriverDumpArgs(checkpoint.StorageDriver)...)
+	if c.ProcessConfig.Privileged {
+		// Privileged containers have access to any device on the host,
+		// find the ones actually in use to let criu know about them.
+		pids, err := d.GetPidsForContainer(c.ID)
+		if err != nil {
+			return err
+		}
+		devices, err := findOpenDevices(pids)
 		if err != nil {
 			return err
 		}
-		if strings.HasPrefix(path, "pipe:") {
-			pipesMap[fmt.Sprintf("%d", i)] = path
+		if err := writeCheckpointDevices(checkpoint.ImagePath, devices); err != nil {
+			return err
+		}
+		cmdArgs = append(cmdArgs, deviceDumpArgs(devices)...)
+	}
+	incrementalArgs, err := incrementalDumpArgs(checkpoint)
+	if err != nil {
+		return err
+	}
+	cmdArgs = append(cmdArgs, incrementalArgs...)
+	if checkpoint.Frozen {
+		// criu leaves the cgroup frozen after dumping it when it was
+		// frozen beforehand, instead of freezing and thawing it itself.
+		freezerPath, err := freezerCgroupPath(c.ContainerPid)
+		if err != nil {
+			return err
+		}
+		frozen, err := isFrozen(freezerPath)
+		if err != nil {
+			return err
+		}
+		if !frozen {
+			return fmt.Errorf("container %s is not frozen", c.ID)
+		}
+		cmdArgs = append(cmdArgs, "--freeze-cgroup", freezerPath)
+	}
+	if checkpoint.TcpEstablished && !checkpoint.PreDump {
+		if err := checkTcpRepair(); err != nil {
+			return fmt.Errorf("cannot dump the established TCP connections of %s: %s", c.ID, err)
+		}
+		cmdArgs = append(cmdArgs, "--tcp-established")
+	}
+	if !stop && !checkpoint.PreDump {
+		// criu kills the dumped processes unless told otherwise
+		cmdArgs = append(cmdArgs, "--leave-running")
+	}
+	if checkpoint.CriuTimeout > 0 {
+		// criu takes whole seconds
+		timeout := int64((checkpoint.CriuTimeout + time.Second - 1) / time.Second)
+		cmdArgs = append(cmdArgs, "--timeout", strconv.FormatInt(timeout, 10))
+	}
+	if checkpoint.PageServer != "" {
+		host, port, err := net.SplitHostPort(checkpoint.PageServer)
+		if err != nil {
+			return fmt.Errorf("invalid page server address %s: %s", checkpoint.PageServer, err)
 		}
+		cmdArgs = append(cmdArgs, "--page-server", "--address", host, "--port", port)
 	}
-	data, err := json.Marshal(pipesMap)
+
+	// The log of the last dump is kept along with the container
+	logPath := d.criuLogPath(c.ID, op)
+	logFile, err := os.Create(logPath)
 	if err != nil {
 		return err
 	}
-	if err := ioutil.WriteFile(filepath.Join(checkpoint.ImagePath, "pipesfd.json"), data, 0644); err != nil {
+	defer logFile.Close()
+	logger := newCriuLogger(c.ID, op)
+	logger.progress = checkpoint.Progress
+	cmd := exec.Command(criu, cmdArgs...)
+	cmd.Stdout = io.MultiWriter(logFile, logger)
+	cmd.Stderr = cmd.Stdout
+	err = cmd.Run()
+	logger.Close()
+
+	if err != nil {
+		return fmt.Errorf("failed checkpointing container %s: %s; %s (full log in %s)", c.ID, err, logger.errorExcerpt(), logPath)
+	}
+	return nil
+}
+
+func (d *driver) ReceiveCheckpoint(imagePath string, port int) error {
+	// criu forks the page server into the background once it is listening,
+	// which then exits after the dumping criu closes the connection.
+	pidFile := filepath.Join(imagePath, "page-server.pid")
+	defer os.Remove(pidFile)
+
+	criu, err := d.criuPath()
+	if err != nil {
+		return err
+	}
+	var output bytes.Buffer
+	cmd := exec.Command(criu, "page-server", "--daemon",
+		"-v4",
+		"-o", "page-server.log",
+		"--pidfile", pidFile,
+		"-D", imagePath,
+		"--port", strconv.Itoa(port))
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed starting page server on port %d: %s; %s", port, err, output.String())
+	}
+
+	// the page server gets reparented to the daemon as we're a subreaper
+	if sPid, err := ioutil.ReadFile(pidFile); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(sPid))); err == nil {
+			d.reaper.adopt(pid)
+		}
+	}
+	return nil
+}
+
+// CheckRestore runs criu restore with --check-only, which goes through
+// the images and the environment they are to be restored into without
+// creating any process. Networking is left out, the veth being set up by
+// the actual restore only.
+func (d *driver) CheckRestore(checkpoint *execdriver.Checkpoint) error {
+	c := checkpoint.Command
+
+	if err := checkStorageDriverRootfs(checkpoint.StorageDriver, c.Rootfs); err != nil {
+		return err
+	}
+	if err := checkTmpfsRestore(checkpoint.ImagePath); err != nil {
 		return err
 	}
 
+	workDir, err := d.criuWorkDir(c.ID, "check")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
 	cmdArgs := []string{
-		"dump",
-		"-v4",
+		"restore", "--check-only", "-v4",
 		"-o", "/dev/stdout",
-		"--manage-cgroups",
+		"--work-dir", workDir,
 		"--evasive-devices",
-		"--ext-mount-map", "/etc/resolv.conf:/etc/resolv.conf",
-		"--ext-mount-map", "/etc/hosts:/etc/hosts",
-		"--ext-mount-map", "/etc/hostname:/etc/hostname",
-		"--ext-mount-map", "/.dockerinit:/.dockerinit",
 		"-D", checkpoint.ImagePath,
-		"-t", fmt.Sprintf("%d", c.ContainerPid),
 		"--root", c.Rootfs,
 	}
-	for hostPath, guestPath := range checkpoint.Volumes {
-		cmdArgs = append(cmdArgs, "--ext-mount-map", hostPath+":"+guestPath)
+	if c.InitPath != "" {
+		cmdArgs = append(cmdArgs, "--ext-mount-map", c.InitPath+":"+d.initPath)
+	}
+	cmdArgs = append(cmdArgs, extMountMapArgs(c.Mounts, true)...)
+	cmdArgs = append(cmdArgs, dumpRestoreArgs(checkpoint)...)
+	skipNamespaces := checkpoint.SkipNamespaces
+	if !skipsNamespace(skipNamespaces, "net") {
+		skipNamespaces = append([]string{"net"}, skipNamespaces...)
+	}
+	nsArgs, err := skipNamespaceArgs(skipNamespaces)
+	if err != nil {
+		return err
+	}
+	cmdArgs = append(cmdArgs, nsArgs...)
+	devices, err := readCheckpointDevices(checkpoint.ImagePath)
+	if err != nil {
+		return err
 	}
-	output, err := exec.Command("criu", cmdArgs...).CombinedOutput()
-	log.Warnf("Rootfs = %s", c.Rootfs)
+	deviceArgs, err := deviceRestoreArgs(devices)
+	if err != nil {
+		return err
+	}
+	cmdArgs = append(cmdArgs, deviceArgs...)
+
+	criu, err := d.criuPath()
+	if err != nil {
+		return err
+	}
+	logger := newCriuLogger(c.ID, "check")
+	cmd := exec.Command(criu, cmdArgs...)
+	cmd.Stdout = logger
+	cmd.Stderr = logger
+	err = cmd.Run()
+	logger.Close()
 
 	if err != nil {
-		return fmt.Errorf("failed checkpointing container %s: %s; %s", c.ID, err, string(output))
+		return fmt.Errorf("criu can't restore %s: %s; %s", c.ID, err, logger.errorExcerpt())
 	}
 	return nil
 }
 
+// mountedCgroupPaths returns the paths of the cgroup c in each of the
+// cgroup hierarchies mounted on the host, keyed by the subsystems mounted
+// together on it.
+func mountedCgroupPaths(c *cgroups.Cgroup) (map[string]string, error) {
+	mounts, err := cgroups.GetCgroupMounts()
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[string]string, len(mounts))
+	for _, m := range mounts {
+		path := filepath.Join(m.Mountpoint, c.Parent, c.Name)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		paths[strings.Join(m.Subsystems, ",")] = path
+	}
+	return paths, nil
+}
+
+// removeCgroup removes the cgroup at path along with its child cgroups,
+// deepest first as a cgroup can't be removed while it has any. Removing is
+// retried for a while, a cgroup stays busy until the tasks which were in it
+// are gone.
+func removeCgroup(path string) error {
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if err := removeCgroup(filepath.Join(path, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	delay := 10 * time.Millisecond
+	for i := 0; ; i++ {
+		err := syscall.Rmdir(path)
+		if err == nil || err == syscall.ENOENT {
+			return nil
+		}
+		if err != syscall.EBUSY || i == 4 {
+			return fmt.Errorf("failed to remove cgroup %s: %s", path, err)
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
 func (d *driver) execRestore(checkpoint *execdriver.Checkpoint, startCallback execdriver.StartCallback, container *libcontainer.Config, dataPath string, args []string, waitForStart chan struct{}) (int, error) {
 	c := checkpoint.Command
 
-	pidFile := filepath.Join(checkpoint.ImagePath, "restore.pid")
+	criu, err := d.criuFeatures()
+	if err != nil {
+		return -1, err
+	}
+	if !checkpoint.Attached && !criu.RestoreSibling {
+		return -1, fmt.Errorf("criu >= %s required to restore %s detached, %s is %s", restoreSiblingCriuVersion, c.ID, criu.Path, criu.Version)
+	}
+
+	// Keep the transient restore state out of the image, which may be
+	// shared with other restores of the same checkpoint.
+	pidFile := filepath.Join(dataPath, "restore.pid")
 	defer os.Remove(pidFile)
 
+	if err := checkStorageDriverRootfs(checkpoint.StorageDriver, c.Rootfs); err != nil {
+		return -1, err
+	}
+	if err := checkTmpfsRestore(checkpoint.ImagePath); err != nil {
+		return -1, err
+	}
+
+	workDir, err := d.criuWorkDir(c.ID, "restore")
+	if err != nil {
+		return -1, err
+	}
+	defer os.RemoveAll(workDir)
+	// The log of the last restore is kept along with the container
+	logFile := d.criuLogPath(c.ID, "restore")
+
 	vethName, _ := utils.GenerateRandomName("veth", 7)
 
-	c.ProcessConfig.Path = "/usr/local/sbin/criu"
+	c.ProcessConfig.Path = criu.Path
 	c.ProcessConfig.Args = []string{
 		"criu", "restore", "-v4",
-		"-o", "/tmp/restore.log",
-		"--restore-detached",
-		"--restore-sibling",
+		"-o", logFile,
+		"--work-dir", workDir,
 		"--manage-cgroups",
 		"--evasive-devices",
-		"--ext-mount-map", fmt.Sprintf("/etc/resolv.conf:/var/lib/docker/containers/%s/resolv.conf", c.ID),
-		"--ext-mount-map", fmt.Sprintf("/etc/hosts:/var/lib/docker/containers/%s/hosts", c.ID),
-		"--ext-mount-map", fmt.Sprintf("/etc/hostname:/var/lib/docker/containers/%s/hostname", c.ID),
-		"--ext-mount-map", "/.dockerinit:/var/lib/docker/init/dockerinit-1.0.1",
-		"--veth-pair", fmt.Sprintf("eth0=%s", vethName),
+		"--ext-mount-map", c.InitPath + ":" + d.initPath,
 		"--pidfile", pidFile,
 		"-D", checkpoint.ImagePath,
 		"--root", c.Rootfs,
 	}
-	// TODO take care of volumes
+	for _, m := range c.Mounts {
+		// criu binds the sources as they are, relabel them the way
+		// libcontainer does when mounting them for a new container.
+		if m.Relabel != "" {
+			if err := label.Relabel(m.Source, c.MountLabel, m.Relabel); err != nil {
+				return -1, fmt.Errorf("relabeling %s to %s %s", m.Source, c.MountLabel, err)
+			}
+		}
+	}
+	c.ProcessConfig.Args = append(c.ProcessConfig.Args, extMountMapArgs(c.Mounts, true)...)
+	c.ProcessConfig.Args = append(c.ProcessConfig.Args, dumpRestoreArgs(checkpoint)...)
+	if !checkpoint.Attached {
+		c.ProcessConfig.Args = append(c.ProcessConfig.Args, "--restore-detached", "--restore-sibling")
+	}
+	if checkpoint.TcpEstablished {
+		if err := checkTcpRepair(); err != nil {
+			return -1, fmt.Errorf("cannot restore the established TCP connections of %s: %s", c.ID, err)
+		}
+		c.ProcessConfig.Args = append(c.ProcessConfig.Args, "--tcp-established")
+	}
+	if c.CgroupParent != "" {
+		c.ProcessConfig.Args = append(c.ProcessConfig.Args,
+			"--cgroup-root", filepath.Join("/", container.Cgroups.Parent, container.Cgroups.Name))
+	}
+	// The namespaces left out of the checkpoint have to be restored empty
+	// the same way, an empty network namespace is also requested for
+	// restoring without networking.
+	skipNamespaces := checkpoint.SkipNamespaces
+	emptyNetNs := checkpoint.EmptyNetNs || skipsNamespace(skipNamespaces, "net")
+	if emptyNetNs && !skipsNamespace(skipNamespaces, "net") {
+		skipNamespaces = append([]string{"net"}, skipNamespaces...)
+	}
+	if emptyNetNs && checkpoint.NatNetwork {
+		return -1, fmt.Errorf("cannot restore %s behind NAT without its network namespace", c.ID)
+	}
+	nsArgs, err := skipNamespaceArgs(skipNamespaces)
+	if err != nil {
+		return -1, err
+	}
+	c.ProcessConfig.Args = append(c.ProcessConfig.Args, nsArgs...)
+	// criu creates the veth pair, whose host end is left behind if the
+	// restore fails before the restored processes took over the other end.
+	restored := false
+	if !emptyNetNs {
+		c.ProcessConfig.Args = append(c.ProcessConfig.Args, "--veth-pair", fmt.Sprintf("eth0=%s", vethName))
+		defer func() {
+			if restored {
+				return
+			}
+			if err := removeVeth(vethName); err != nil {
+				log.Warnf("failed to remove veth %s of %s which failed to be restored: %s", vethName, c.ID, err)
+			}
+		}()
+	}
+	devices, err := readCheckpointDevices(checkpoint.ImagePath)
+	if err != nil {
+		return -1, err
+	}
+	deviceArgs, err := deviceRestoreArgs(devices)
+	if err != nil {
+		return -1, err
+	}
+	c.ProcessConfig.Args = append(c.ProcessConfig.Args, deviceArgs...)
+	joinNsArgs, err := joinNamespaceArgs(c, container)
+	if err != nil {
+		return -1, err
+	}
+	c.ProcessConfig.Args = append(c.ProcessConfig.Args, joinNsArgs...)
 
-	data, err := ioutil.ReadFile(filepath.Join(checkpoint.ImagePath, "pipesfd.json"))
-	var pipesMap map[string]string
-	if err := json.Unmarshal(data, &pipesMap); err != nil {
+	stdioArgs, tty, err := readCheckpointStdio(checkpoint.ImagePath)
+	if err != nil {
 		return -1, err
 	}
-	for fd, pipe := range pipesMap {
-		c.ProcessConfig.Args = append(c.ProcessConfig.Args,
-			"--inherit-fd", fmt.Sprintf("fd[%s]:%s", fd, pipe))
+	c.ProcessConfig.Args = append(c.ProcessConfig.Args, stdioArgs...)
+	if tty {
+		if !c.ProcessConfig.Tty {
+			return -1, fmt.Errorf("checkpoint of %s has a terminal but the container is not a TTY one", c.ID)
+		}
+		// The console is otherwise opened by the container's init, hand
+		// it to criu instead to be inherited by the restored process.
+		// The monitor closes it once the process started.
+		console, err := os.OpenFile(c.ProcessConfig.Console, os.O_RDWR|syscall.O_NOCTTY, 0)
+		if err != nil {
+			return -1, err
+		}
+		c.ProcessConfig.Stdin = console
+		c.ProcessConfig.Stdout = console
+		c.ProcessConfig.Stderr = console
 	}
 	log.Warnf("%s", c.ProcessConfig.Args)
 
@@ -390,45 +897,101 @@ func (d *driver) execRestore(checkpoint *execdriver.Checkpoint, startCallback ex
 	c.ProcessConfig.Dir = container.RootFs
 
 	defer func() {
-		for _, subsys := range []string{
-			"devices",
-			"memory",
-			"cpu",
-			"cpuset",
-			"cpuacct",
-			"blkio",
-			"perf_event",
-			"freezer",
-		} {
-			path := fmt.Sprintf("/sys/fs/cgroup/%s/docker/%s", subsys, c.ID)
-			if _, err := os.Stat(path); err == nil {
-				os.Remove(path)
+		paths, err := mountedCgroupPaths(container.Cgroups)
+		if err != nil {
+			log.Warnf("failed to find cgroups of restored container %s: %s", c.ID, err)
+			return
+		}
+		for subsystems, path := range paths {
+			if err := removeCgroup(path); err != nil {
+				log.Warnf("failed to remove %s cgroup of restored container %s: %s", subsystems, c.ID, err)
 			}
 		}
 	}()
 
-	if err := c.ProcessConfig.Start(); err != nil {
-		return -1, err
+	if checkpoint.RestoreTimeout > 0 {
+		// To kill criu along with what it forked if it hangs
+		if c.ProcessConfig.SysProcAttr == nil {
+			c.ProcessConfig.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		c.ProcessConfig.SysProcAttr.Setpgid = true
 	}
-	log.Warnf("criu pid = %d", c.ProcessConfig.Process.Pid)
 
-	var waitStatus syscall.WaitStatus
-	if _, err := syscall.Wait4(c.ProcessConfig.Process.Pid, &waitStatus, 0, nil); err != nil {
-		return waitStatus.ExitStatus(), err
+	// criu's own stdio is the container's, inherited by the restored
+	// processes, what it has to say goes to its log instead.
+	if err := c.ProcessConfig.Start(); err != nil {
+		return -1, fmt.Errorf("failed restoring %s: %s", c.ID, err)
 	}
+	criuPid := c.ProcessConfig.Process.Pid
+	log.Warnf("criu pid = %d", criuPid)
 
-	// TODO there's possibly more than one network configs
-	if err := network.SetInterfaceMaster(vethName, "docker0"); err != nil {
-		return -1, err
+	var (
+		waitStatus syscall.WaitStatus
+		criuErrors string // errors criu logged, to be reported if restoring failed
+	)
+	waitCriu := func() error {
+		_, err := syscall.Wait4(criuPid, &waitStatus, 0, nil)
+		excerpt, logErr := logCriuLogFile(logFile, c.ID, "restore")
+		if logErr != nil {
+			log.Warnf("failed to read criu restore log of %s: %s", c.ID, logErr)
+		}
+		criuErrors = excerpt
+		return err
 	}
-	if err := network.InterfaceUp(vethName); err != nil {
-		return -1, err
+	var timeout *time.Timer
+	if checkpoint.RestoreTimeout > 0 {
+		timeout = time.AfterFunc(checkpoint.RestoreTimeout, func() {
+			log.Errorf("criu did not restore %s within %s, killing it", c.ID, checkpoint.RestoreTimeout)
+			syscall.Kill(-criuPid, syscall.SIGKILL)
+		})
+	}
+	// Restoring detached, criu exits once the processes are restored and
+	// they are handed over to the daemon as its siblings. Restoring
+	// attached, criu stays their parent and exits with the status of the
+	// root one, so it's only known to be done once it wrote the pidfile.
+	var restoreErr error
+	criuExited := make(chan error, 1)
+	if checkpoint.Attached {
+		go func() { criuExited <- waitCriu() }()
+		restoreErr = waitForRestorePidFile(pidFile, criuExited)
+	} else if restoreErr = waitCriu(); restoreErr == nil && waitStatus.ExitStatus() != 0 {
+		restoreErr = fmt.Errorf("criu exited with %d", waitStatus.ExitStatus())
+	}
+	// Stop fails if the timeout already fired and killed criu
+	if timeout != nil && !timeout.Stop() && restoreErr != nil {
+		log.Errorf("failed restoring %s: %s; %s (full log in %s)", c.ID, restoreErr, criuErrors, logFile)
+		return -1, execdriver.ErrWaitTimeoutReached
+	}
+	if restoreErr != nil {
+		return waitStatus.ExitStatus(), fmt.Errorf("failed restoring %s: %s; %s (full log in %s)", c.ID, restoreErr, criuErrors, logFile)
+	}
+
+	if checkpoint.NatNetwork {
+		if c.Network.Interface == nil {
+			return -1, fmt.Errorf("container %s has no network address to restore behind NAT", c.ID)
+		}
+		rules, err := setupNatNetwork(vethName, c.Network.Interface.IPAddress, checkpoint.NatPorts)
+		if err != nil {
+			return -1, err
+		}
+		defer teardownNatNetwork(rules)
+	} else if !emptyNetNs {
+		// TODO there's possibly more than one network configs
+		bridge := "docker0"
+		if c.Network.Interface != nil && c.Network.Interface.Bridge != "" {
+			bridge = c.Network.Interface.Bridge
+		}
+		if err := network.SetInterfaceMaster(vethName, bridge); err != nil {
+			return -1, err
+		}
+		if err := network.InterfaceUp(vethName); err != nil {
+			return -1, err
+		}
 	}
 
-	close(waitForStart)
 	sPid, err := ioutil.ReadFile(pidFile)
 	if err != nil {
-		return -1, err
+		return -1, fmt.Errorf("failed restoring %s: %s; %s (full log in %s)", c.ID, err, criuErrors, logFile)
 	}
 
 	pid, _ := strconv.Atoi(string(sPid))
@@ -437,12 +1000,43 @@ func (d *driver) execRestore(checkpoint *execdriver.Checkpoint, startCallback ex
 		return -1, err
 	}
 
+	// Save the state libcontainer saves for the containers it starts, so
+	// that the restored one is found in the cgroups criu restored it into,
+	// e.g. for OOM notifications, and reloaded after a daemon restart.
+	state := &libcontainer.State{InitPid: pid}
+	if !emptyNetNs {
+		state.NetworkState.VethHost = vethName
+	}
+	if state.InitStartTime, err = system.GetProcessStartTime(pid); err != nil {
+		return -1, err
+	}
+	if state.CgroupPaths, err = processCgroupPaths(pid); err != nil {
+		return -1, err
+	}
+	if err := libcontainer.SaveState(dataPath, state); err != nil {
+		return -1, err
+	}
+	defer libcontainer.DeleteState(dataPath)
+	close(waitForStart)
+
 	c.ProcessConfig.Process = proc
+	restored = true
 	if startCallback != nil {
 		c.ContainerPid = pid
 		startCallback(&c.ProcessConfig, c.ContainerPid)
 	}
 
+	if checkpoint.Attached {
+		// criu waits for the restored processes itself
+		if err := <-criuExited; err != nil {
+			return -1, err
+		}
+		return waitStatus.ExitStatus(), nil
+	}
+
+	d.reaper.watch(c.ID, pid)
+	defer d.reaper.unwatch(c.ID)
+
 	log.Warnf("PROC = %s", proc)
 	pState, err := proc.Wait()
 	if err != nil {
@@ -457,12 +1051,180 @@ func (d *driver) execRestore(checkpoint *execdriver.Checkpoint, startCallback ex
 	return exitCode, nil
 }
 
+// extMountMapArgs returns the criu arguments mapping the bind mounts of a
+// container, volumes and single files such as logs alike. They are external
+// to the container and keyed by their destination when dumping, so that
+// they can be bound again from wherever the sources are on restore.
+func extMountMapArgs(mounts []execdriver.Mount, restore bool) []string {
+	args := make([]string, 0, 2*len(mounts))
+	for _, m := range mounts {
+		source := m.Destination
+		if restore {
+			source = m.Source
+		}
+		args = append(args, "--ext-mount-map", m.Destination+":"+source)
+	}
+	return args
+}
+
+// incrementalDumpArgs returns the criu arguments to dump the checkpoint on
+// top of its parent, a pre-dump or a full dump, only dumping the memory
+// pages which changed since. Memory changes are tracked from a pre-dump on,
+// for the dump taken on top of it.
+func incrementalDumpArgs(checkpoint *execdriver.Checkpoint) ([]string, error) {
+	if checkpoint.ParentImagePath == "" {
+		if checkpoint.PreDump {
+			return []string{"--track-mem"}, nil
+		}
+		return nil, nil
+	}
+	// criu requires the path to be relative to the images directory
+	// and links it from there as "parent", which is followed on restore
+	prevImagesDir, err := filepath.Rel(checkpoint.ImagePath, checkpoint.ParentImagePath)
+	if err != nil {
+		return nil, err
+	}
+	return []string{"--track-mem", "--prev-images-dir", prevImagesDir}, nil
+}
+
+// criuPath returns the path of the criu binary to run, the configured one
+// or else the one found in $PATH, as cached by criuFeatures. It fails if
+// criu is missing or too old.
+func (d *driver) criuPath() (string, error) {
+	criu, err := d.criuFeatures()
+	if err != nil {
+		return "", err
+	}
+	return criu.Path, nil
+}
+
+func (d *driver) lookupCriu() (string, error) {
+	name := d.criuBinary
+	if name == "" {
+		name = "criu"
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", fmt.Errorf("criu binary not found, install criu or set its path with --criu-binary: %s", err)
+	}
+	return path, nil
+}
+
+// criuLogPath returns the path of the log of the last operation of criu on
+// the container, kept until the container is removed to diagnose it.
+func (d *driver) criuLogPath(id, operation string) string {
+	return filepath.Join(d.root, id, "criu-"+operation+".log")
+}
+
+// criuWorkDir creates the directory criu keeps the logs and scratch state of
+// the operation on the container in, so that concurrent operations don't
+// share any. Leftovers of an interrupted operation are cleared first, and
+// it's to be removed once the operation is done.
+func (d *driver) criuWorkDir(id, operation string) (string, error) {
+	dir := filepath.Join(d.root, id, "criu-"+operation)
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// removeVeth deletes the host end of a veth pair, if it exists. Deleting it
+// deletes the other end as well.
+func removeVeth(name string) error {
+	if _, err := net.InterfaceByName(name); err != nil {
+		// never created, or gone along with the namespace of the other end
+		return nil
+	}
+	return netlink.NetworkLinkDel(name)
+}
+
+// waitForRestorePidFile waits for criu restoring attached to write the pid
+// of the restored root process into pidFile, or to exit without doing so.
+func waitForRestorePidFile(pidFile string, criuExited chan error) error {
+	for {
+		if data, err := ioutil.ReadFile(pidFile); err == nil && len(data) > 0 {
+			return nil
+		}
+		select {
+		case err := <-criuExited:
+			if err == nil {
+				err = fmt.Errorf("criu exited without restoring the processes")
+			}
+			return err
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
 func (d *driver) Restore(checkpoint *execdriver.Checkpoint, pipes *execdriver.Pipes, startCallback execdriver.StartCallback) (execdriver.ExitStatus, error) {
 	return d.run(checkpoint.Command, pipes, func(container *libcontainer.Config, dataPath string, args []string, waitForStart chan struct{}) (int, error) {
 		return d.execRestore(checkpoint, startCallback, container, dataPath, args, waitForStart)
 	})
 }
 
+// dumpRestoreArgs returns the criu arguments for what the processes of the
+// checkpoint hold beyond what criu dumps by default. criu refuses to dump
+// them without, and the restore has to be given the same ones.
+func dumpRestoreArgs(checkpoint *execdriver.Checkpoint) []string {
+	var args []string
+	if checkpoint.ShellJob {
+		args = append(args, "--shell-job")
+	}
+	if checkpoint.FileLocks {
+		args = append(args, "--file-locks")
+	}
+	return args
+}
+
+// skipNamespaceArgs returns the criu arguments to leave the state of the
+// namespaces out of a dump, which also makes restore create them empty.
+// criu only supports it for the network namespace.
+func skipNamespaceArgs(namespaces []string) ([]string, error) {
+	var args []string
+	for _, ns := range namespaces {
+		if ns != "net" {
+			return nil, fmt.Errorf("the state of the %s namespace can't be left out of a checkpoint", ns)
+		}
+		args = append(args, "--empty-ns", ns)
+	}
+	return args, nil
+}
+
+func skipsNamespace(namespaces []string, ns string) bool {
+	for _, n := range namespaces {
+		if n == ns {
+			return true
+		}
+	}
+	return false
+}
+
+// joinNamespaceArgs returns criu restore arguments to make the restored
+// process tree join the namespaces it shares with other running containers,
+// which were resolved into namespace paths by createContainer.
+func joinNamespaceArgs(c *execdriver.Command, container *libcontainer.Config) ([]string, error) {
+	if c.Pid != nil && c.Pid.HostPid {
+		return nil, fmt.Errorf("cannot restore %s into the host PID namespace", c.ID)
+	}
+	var args []string
+	for _, ns := range container.Namespaces {
+		if ns.Path == "" {
+			continue
+		}
+		switch ns.Type {
+		case libcontainer.NEWIPC:
+			args = append(args, "--join-ns", "ipc:"+ns.Path)
+		case libcontainer.NEWUTS:
+			args = append(args, "--join-ns", "uts:"+ns.Path)
+		default:
+			return nil, fmt.Errorf("cannot restore %s into an existing %s namespace", c.ID, ns.Type)
+		}
+	}
+	return args, nil
+}
 
 func getEnv(key string, env []string) string {
 	for _, pair := range env {
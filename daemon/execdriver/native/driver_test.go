@@ -0,0 +1,109 @@
+// +build linux,cgo
+
+package native
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/execdriver"
+)
+
+func TestExtMountMapArgs(t *testing.T) {
+	mounts := []execdriver.Mount{
+		{Source: "/var/lib/docker/vfs/dir/data", Destination: "/data", Writable: true},
+		{Source: "/srv/logs", Destination: "/var/log/app"},
+	}
+
+	dump := extMountMapArgs(mounts, false)
+	expected := []string{
+		"--ext-mount-map", "/data:/data",
+		"--ext-mount-map", "/var/log/app:/var/log/app",
+	}
+	if !reflect.DeepEqual(dump, expected) {
+		t.Fatalf("expected dump args %v, got %v", expected, dump)
+	}
+
+	restore := extMountMapArgs(mounts, true)
+	expected = []string{
+		"--ext-mount-map", "/data:/var/lib/docker/vfs/dir/data",
+		"--ext-mount-map", "/var/log/app:/srv/logs",
+	}
+	if !reflect.DeepEqual(restore, expected) {
+		t.Fatalf("expected restore args %v, got %v", expected, restore)
+	}
+}
+
+func TestIncrementalDumpArgs(t *testing.T) {
+	checkpoints := "/var/lib/docker/containers/abc/checkpoints"
+	for _, c := range []struct {
+		checkpoint *execdriver.Checkpoint
+		expected   []string
+	}{
+		{
+			&execdriver.Checkpoint{ImagePath: checkpoints + "/full"},
+			nil,
+		},
+		{
+			&execdriver.Checkpoint{ImagePath: checkpoints + "/pre", PreDump: true},
+			[]string{"--track-mem"},
+		},
+		{
+			&execdriver.Checkpoint{ImagePath: checkpoints + "/pre2", ParentImagePath: checkpoints + "/pre", PreDump: true},
+			[]string{"--track-mem", "--prev-images-dir", "../pre"},
+		},
+		{
+			&execdriver.Checkpoint{ImagePath: checkpoints + "/final", ParentImagePath: checkpoints + "/pre2"},
+			[]string{"--track-mem", "--prev-images-dir", "../pre2"},
+		},
+	} {
+		args, err := incrementalDumpArgs(c.checkpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(args, c.expected) {
+			t.Fatalf("expected args %v for %s, got %v", c.expected, c.checkpoint.ImagePath, args)
+		}
+	}
+}
+
+func TestDumpRestoreArgs(t *testing.T) {
+	for _, c := range []struct {
+		checkpoint *execdriver.Checkpoint
+		expected   []string
+	}{
+		{&execdriver.Checkpoint{}, nil},
+		{&execdriver.Checkpoint{ShellJob: true}, []string{"--shell-job"}},
+		{&execdriver.Checkpoint{FileLocks: true}, []string{"--file-locks"}},
+		{&execdriver.Checkpoint{ShellJob: true, FileLocks: true}, []string{"--shell-job", "--file-locks"}},
+	} {
+		// The same checkpoint is given to the dump and to the restore
+		if args := dumpRestoreArgs(c.checkpoint); !reflect.DeepEqual(args, c.expected) {
+			t.Fatalf("expected args %v for shell job %v and file locks %v, got %v", c.expected, c.checkpoint.ShellJob, c.checkpoint.FileLocks, args)
+		}
+	}
+}
+
+func TestWait(t *testing.T) {
+	d := &driver{activeContainers: make(map[string]*activeContainer)}
+	c := &execdriver.Command{ID: "container"}
+	d.activeContainers[c.ID] = &activeContainer{}
+
+	if err := d.Wait(c, 50*time.Millisecond); err != execdriver.ErrWaitTimeoutReached {
+		t.Fatalf("expected the wait to time out, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		d.Lock()
+		delete(d.activeContainers, c.ID)
+		d.Unlock()
+	}()
+	if err := d.Wait(c, -1); err != nil {
+		t.Fatalf("expected the wait to return once the container exited, got %s", err)
+	}
+	if err := d.Wait(c, 0); err != nil {
+		t.Fatalf("expected the wait to return right away for an exited container, got %s", err)
+	}
+}
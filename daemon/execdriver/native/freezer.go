@@ -0,0 +1,141 @@
+// +build linux,cgo
+
+package native
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/docker/libcontainer/cgroups"
+	"github.com/docker/libcontainer/cgroups/fs"
+	"github.com/docker/libcontainer/cgroups/systemd"
+)
+
+const (
+	cgroupMountpoint  = "/sys/fs/cgroup"
+	cgroup2SuperMagic = 0x63677270
+)
+
+// isCgroupUnified returns true if the host mounts the cgroup v2 unified
+// hierarchy at /sys/fs/cgroup, in which case there is no freezer subsystem
+// and freezing is controlled by the cgroup.freeze interface file.
+func isCgroupUnified() bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(cgroupMountpoint, &st); err != nil {
+		return false
+	}
+	return st.Type == cgroup2SuperMagic
+}
+
+// unifiedCgroupPath returns the path of the container's cgroup in the
+// unified hierarchy, following the same naming as the fs and systemd
+// cgroup managers.
+func unifiedCgroupPath(c *cgroups.Cgroup) string {
+	if systemd.UseSystemd() {
+		slice := "system.slice"
+		if c.Slice != "" {
+			slice = c.Slice
+		}
+		return filepath.Join(cgroupMountpoint, slice, fmt.Sprintf("%s-%s.scope", c.Parent, c.Name))
+	}
+	return filepath.Join(cgroupMountpoint, c.Parent, c.Name)
+}
+
+func freezeUnified(c *cgroups.Cgroup, state cgroups.FreezerState) error {
+	var value string
+	switch state {
+	case cgroups.Frozen:
+		value = "1"
+	case cgroups.Thawed:
+		value = "0"
+	default:
+		return fmt.Errorf("invalid freezer state: %s", state)
+	}
+
+	dir := unifiedCgroupPath(c)
+	if err := ioutil.WriteFile(filepath.Join(dir, "cgroup.freeze"), []byte(value), 0); err != nil {
+		return err
+	}
+
+	// Writing cgroup.freeze only requests the transition, wait for the
+	// kernel to report it through cgroup.events like the v1 freezer does.
+	for {
+		events, err := ioutil.ReadFile(filepath.Join(dir, "cgroup.events"))
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(string(events), "\n") {
+			if line == "frozen "+value {
+				return nil
+			}
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+}
+
+// freeze sets the freezer state of the container's cgroup using the
+// mechanism appropriate for the host's cgroup hierarchy.
+func freeze(c *cgroups.Cgroup, state cgroups.FreezerState) error {
+	c.Freezer = state
+	if isCgroupUnified() {
+		return freezeUnified(c, state)
+	}
+	if systemd.UseSystemd() {
+		return systemd.Freeze(c, state)
+	}
+	return fs.Freeze(c, state)
+}
+
+// freezerCgroupPath returns the path of the freezer cgroup pid is in, or
+// of its cgroup in the unified hierarchy, as seen from the host.
+func freezerCgroupPath(pid int) (string, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if isCgroupUnified() {
+		// the unified hierarchy is listed without any subsystem
+		dir, err := cgroups.ParseCgroupFile("", f)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(cgroupMountpoint, dir), nil
+	}
+	mountpoint, err := cgroups.FindCgroupMountpoint("freezer")
+	if err != nil {
+		return "", err
+	}
+	dir, err := cgroups.ParseCgroupFile("freezer", f)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(mountpoint, dir), nil
+}
+
+// isFrozen returns whether the cgroup at path is frozen.
+func isFrozen(path string) (bool, error) {
+	if isCgroupUnified() {
+		events, err := ioutil.ReadFile(filepath.Join(path, "cgroup.events"))
+		if err != nil {
+			return false, err
+		}
+		for _, line := range strings.Split(string(events), "\n") {
+			if line == "frozen 1" {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	state, err := ioutil.ReadFile(filepath.Join(path, "freezer.state"))
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(state)) == string(cgroups.Frozen), nil
+}
@@ -28,3 +28,11 @@ func (i *info) IsRunning() bool {
 	}
 	return false
 }
+
+func (i *info) CriuVersion() string {
+	criu, err := i.driver.criuFeatures()
+	if err != nil {
+		return ""
+	}
+	return criu.Version.String()
+}
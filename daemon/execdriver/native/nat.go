@@ -0,0 +1,113 @@
+// +build linux,cgo
+
+package native
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/nat"
+	"github.com/docker/docker/pkg/iptables"
+	"github.com/docker/libcontainer/netlink"
+	"github.com/docker/libcontainer/network"
+)
+
+// natRule is an iptables rule without its action, so that it can be both
+// inserted and deleted.
+type natRule struct {
+	table iptables.Table
+	chain string
+	args  []string
+}
+
+func (r natRule) run(action iptables.Action) error {
+	args := append([]string{"-t", string(r.table), string(action), r.chain}, r.args...)
+	if output, err := iptables.Raw(args...); err != nil {
+		return err
+	} else if len(output) != 0 {
+		return &iptables.ChainError{Chain: r.chain, Output: output}
+	}
+	return nil
+}
+
+// natRules returns the rules masquerading the traffic from ip and
+// forwarding the published ports of the host to it.
+func natRules(vethName, ip string, ports nat.PortMap) []natRule {
+	rules := []natRule{
+		{iptables.Nat, "POSTROUTING", []string{"-s", ip, "!", "-o", vethName, "-j", "MASQUERADE"}},
+		{iptables.Filter, "FORWARD", []string{"-i", vethName, "-j", "ACCEPT"}},
+		{iptables.Filter, "FORWARD", []string{"-o", vethName, "-j", "ACCEPT"}},
+	}
+	for port, bindings := range ports {
+		for _, b := range bindings {
+			if b.HostPort == "" {
+				continue
+			}
+			hostIP := b.HostIp
+			if hostIP == "" || hostIP == "0.0.0.0" {
+				hostIP = "0/0"
+			}
+			dnat := []string{
+				"-p", port.Proto(),
+				"-d", hostIP,
+				"--dport", b.HostPort,
+				"-m", "addrtype", "--dst-type", "LOCAL",
+				"-j", "DNAT",
+				"--to-destination", net.JoinHostPort(ip, port.Port()),
+			}
+			rules = append(rules,
+				natRule{iptables.Nat, "PREROUTING", dnat},
+				natRule{iptables.Nat, "OUTPUT", dnat})
+		}
+	}
+	return rules
+}
+
+// setupNatNetwork makes the host route ip to the restored container
+// through vethName and puts it behind the host NAT, for hosts where the
+// container can't be reached by attaching it to a bridge. The host answers
+// ARP requests on the veth, so that the container can keep the gateway it
+// was checkpointed with. The returned rules are to be removed with
+// teardownNatNetwork once the container exited.
+func setupNatNetwork(vethName, ip string, ports nat.PortMap) ([]natRule, error) {
+	if net.ParseIP(ip) == nil {
+		return nil, fmt.Errorf("invalid container address %q", ip)
+	}
+	if err := network.InterfaceUp(vethName); err != nil {
+		return nil, err
+	}
+	proxyArp := fmt.Sprintf("/proc/sys/net/ipv4/conf/%s/proxy_arp", vethName)
+	if err := ioutil.WriteFile(proxyArp, []byte("1"), 0644); err != nil {
+		return nil, err
+	}
+	if err := netlink.AddRoute(ip+"/32", "", "", vethName); err != nil {
+		return nil, fmt.Errorf("failed to route %s to %s: %s", ip, vethName, err)
+	}
+
+	var added []natRule
+	for _, rule := range natRules(vethName, ip, ports) {
+		action := iptables.Append
+		if rule.table == iptables.Filter {
+			// go ahead of the rules dropping the traffic of the bridge
+			action = iptables.Insert
+		}
+		if err := rule.run(action); err != nil {
+			teardownNatNetwork(added)
+			return nil, err
+		}
+		added = append(added, rule)
+	}
+	return added, nil
+}
+
+// teardownNatNetwork removes the rules added by setupNatNetwork. The route
+// goes away along with the veth.
+func teardownNatNetwork(rules []natRule) {
+	for _, rule := range rules {
+		if err := rule.run(iptables.Delete); err != nil {
+			log.Warnf("failed to remove NAT rule %v: %s", rule.args, err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// +build linux,cgo
+
+package native
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/libcontainer"
+	"github.com/docker/libcontainer/cgroups"
+	"github.com/docker/libcontainer/cgroups/systemd"
+)
+
+// oomDrainTimeout bounds how long to wait for a pending OOM event of a
+// container which has already exited.
+const oomDrainTimeout = 100 * time.Millisecond
+
+// memoryCgroupPath returns the path the fs cgroup manager is going to put
+// the container into in the memory hierarchy.
+func memoryCgroupPath(c *cgroups.Cgroup) (string, error) {
+	mountpoint, err := cgroups.FindCgroupMountpoint("memory")
+	if err != nil {
+		return "", err
+	}
+	cgroup := c.Name
+	if c.Parent != "" {
+		cgroup = filepath.Join(c.Parent, cgroup)
+	}
+	if filepath.IsAbs(cgroup) {
+		return filepath.Join(mountpoint, cgroup), nil
+	}
+	initPath, err := cgroups.GetInitCgroupDir("memory")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(mountpoint, initPath, cgroup), nil
+}
+
+// processCgroupPaths returns the paths of the cgroups pid is in as seen
+// from the host, keyed by subsystem like the ones of libcontainer state.
+func processCgroupPaths(pid int) (map[string]string, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := cgroups.GetCgroupMounts()
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[string]string)
+	for _, m := range mounts {
+		for _, subsystem := range m.Subsystems {
+			dir, err := cgroups.ParseCgroupFile(subsystem, bytes.NewReader(data))
+			if err != nil {
+				continue
+			}
+			paths[subsystem] = filepath.Join(m.Mountpoint, dir)
+		}
+	}
+	return paths, nil
+}
+
+// notifyOnOOMEarly creates the memory cgroup of the container ahead of
+// libcontainer, which just joins it later, and arms the OOM notification on
+// it. With systemd the cgroup is created along with the scope unit, so
+// the notification can only be armed after the container started.
+func notifyOnOOMEarly(c *cgroups.Cgroup) (<-chan struct{}, string, error) {
+	if systemd.UseSystemd() {
+		return nil, "", fmt.Errorf("the cgroup is managed by systemd")
+	}
+	path, err := memoryCgroupPath(c)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, "", err
+	}
+	state := &libcontainer.State{
+		CgroupPaths: map[string]string{"memory": path},
+	}
+	notification, err := libcontainer.NotifyOnOOM(state)
+	if err != nil {
+		os.Remove(path)
+		return nil, "", err
+	}
+	return notification, path, nil
+}
+
+// receiveOOMKill reports whether an OOM event is pending on the
+// notification of a container which has already exited.
+func receiveOOMKill(notification <-chan struct{}) bool {
+	select {
+	case _, ok := <-notification:
+		return ok
+	case <-time.After(oomDrainTimeout):
+		return false
+	}
+}
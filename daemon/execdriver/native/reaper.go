@@ -0,0 +1,249 @@
+// +build linux,cgo
+
+package native
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	prSetChildSubreaper = 36
+	reapInterval        = 1 * time.Second
+	// How long a zombie child of the daemon is left for the exec.Cmd which
+	// started it to wait for, before it is taken for an orphan
+	orphanGracePeriod = 30 * time.Second
+)
+
+// orphanReaper makes the daemon a child subreaper and reaps the processes
+// of restored containers which get reparented to it. Restoring with
+// --restore-sibling makes the restored tree hang off the daemon instead of
+// off an init process waiting for it, so its orphans would stay zombies.
+//
+// Being a subreaper, the daemon also gets the orphans of any other of its
+// descendants, e.g. of double-forking helpers or of the processes of
+// containers which weren't restored. The daemon waits for its own children
+// by pid, so the processes seen with another parent while alive are reaped
+// right away, and any other zombie child once nobody waited for it during
+// the grace period.
+type orphanReaper struct {
+	sync.Mutex
+	getPids    func(id string) ([]int, error)
+	containers map[string]int // restored container ID -> pid of its init, waited by execRestore
+	seen       map[int]uint64 // pid -> start time of descendants seen under another parent
+	zombies    map[int]zombie // pid -> zombie child of the daemon left unwaited
+	grace      time.Duration
+}
+
+type zombie struct {
+	startTime uint64
+	since     time.Time
+}
+
+type procStat struct {
+	state     byte
+	ppid      int
+	startTime uint64
+}
+
+func readProcStat(pid int) (*procStat, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	// the command name may contain spaces and parens, skip past it
+	i := strings.LastIndex(string(data), ")")
+	if i < 0 {
+		return nil, fmt.Errorf("malformed stat of %d", pid)
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 20 {
+		return nil, fmt.Errorf("malformed stat of %d", pid)
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	startTime, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &procStat{state: fields[0][0], ppid: ppid, startTime: startTime}, nil
+}
+
+func newOrphanReaper(getPids func(id string) ([]int, error)) (*orphanReaper, error) {
+	if _, _, err := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0); err != 0 {
+		return nil, err
+	}
+	r := &orphanReaper{
+		getPids:    getPids,
+		containers: make(map[string]int),
+		seen:       make(map[int]uint64),
+		zombies:    make(map[int]zombie),
+		grace:      orphanGracePeriod,
+	}
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGCHLD)
+	go r.loop(sigc)
+	return r, nil
+}
+
+// watch starts tracking the processes of the restored container id.
+func (r *orphanReaper) watch(id string, initPid int) {
+	if r == nil {
+		return
+	}
+	r.Lock()
+	r.containers[id] = initPid
+	r.Unlock()
+}
+
+func (r *orphanReaper) unwatch(id string) {
+	if r == nil {
+		return
+	}
+	r.Lock()
+	delete(r.containers, id)
+	r.Unlock()
+}
+
+// adopt makes the reaper reap pid once it exits, for the daemonized helper
+// processes the daemon starts, e.g. the criu page server.
+func (r *orphanReaper) adopt(pid int) {
+	if r == nil {
+		return
+	}
+	st, err := readProcStat(pid)
+	if err != nil {
+		return
+	}
+	r.Lock()
+	r.seen[pid] = st.startTime
+	r.Unlock()
+}
+
+func (r *orphanReaper) loop(sigc chan os.Signal) {
+	ticker := time.NewTicker(reapInterval)
+	for {
+		select {
+		case <-sigc:
+		case <-ticker.C:
+		}
+		r.reap()
+	}
+}
+
+func (r *orphanReaper) reap() {
+	r.Lock()
+	defer r.Unlock()
+
+	self := os.Getpid()
+	for id, initPid := range r.containers {
+		pids, err := r.getPids(id)
+		if err != nil {
+			continue
+		}
+		for _, pid := range pids {
+			if pid == initPid {
+				continue
+			}
+			if _, ok := r.seen[pid]; ok {
+				continue
+			}
+			st, err := readProcStat(pid)
+			if err != nil || st.ppid == self {
+				continue
+			}
+			r.seen[pid] = st.startTime
+		}
+	}
+
+	for pid, startTime := range r.seen {
+		st, err := readProcStat(pid)
+		if err != nil || st.startTime != startTime {
+			// reaped by its original parent, possibly already reused
+			delete(r.seen, pid)
+			continue
+		}
+		if st.ppid != self || st.state != 'Z' {
+			continue
+		}
+		reapPid(pid)
+		delete(r.seen, pid)
+	}
+
+	zombies := make(map[int]zombie)
+	now := time.Now()
+	for _, pid := range childPids(self) {
+		st, err := readProcStat(pid)
+		if err != nil || st.ppid != self || st.state != 'Z' {
+			continue
+		}
+		z, ok := r.zombies[pid]
+		if !ok || z.startTime != st.startTime {
+			z = zombie{startTime: st.startTime, since: now}
+		}
+		if now.Sub(z.since) < r.grace {
+			zombies[pid] = z
+			continue
+		}
+		log.Debugf("reaping process %d left unwaited for %s", pid, now.Sub(z.since))
+		reapPid(pid)
+	}
+	r.zombies = zombies
+}
+
+func reapPid(pid int) {
+	var status syscall.WaitStatus
+	if _, err := syscall.Wait4(pid, &status, syscall.WNOHANG, nil); err != nil {
+		log.Debugf("failed to reap orphaned process %d: %s", pid, err)
+	}
+}
+
+// childPids returns the pids of the children of the process pid, zombies
+// included, from the children lists of its threads if the kernel has them
+// (linux >= 3.5 with CONFIG_CHECKPOINT_RESTORE, which criu requires), and
+// by going through the parents of all the processes otherwise.
+func childPids(pid int) []int {
+	var pids []int
+	lists, _ := filepath.Glob(fmt.Sprintf("/proc/%d/task/*/children", pid))
+	for _, list := range lists {
+		data, err := ioutil.ReadFile(list)
+		if err != nil {
+			continue
+		}
+		for _, field := range strings.Fields(string(data)) {
+			if child, err := strconv.Atoi(field); err == nil {
+				pids = append(pids, child)
+			}
+		}
+	}
+	if len(lists) > 0 {
+		return pids
+	}
+
+	dirents, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	for _, fi := range dirents {
+		child, err := strconv.Atoi(fi.Name())
+		if err != nil {
+			continue
+		}
+		if st, err := readProcStat(child); err == nil && st.ppid == pid {
+			pids = append(pids, child)
+		}
+	}
+	return pids
+}
@@ -0,0 +1,43 @@
+// +build linux,cgo
+
+package native
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOrphanReaperReapsUnwatchedOrphans(t *testing.T) {
+	r, err := newOrphanReaper(func(id string) ([]int, error) { return nil, nil })
+	if err != nil {
+		t.Skipf("cannot become a child subreaper: %s", err)
+	}
+	r.Lock()
+	r.grace = 100 * time.Millisecond
+	r.Unlock()
+
+	// The shell exits right away, leaving sleep to be reparented to the
+	// test as the subreaper, outside of any watched container
+	out, err := exec.Command("sh", "-c", "sleep 0.2 >/dev/null & echo $!").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := readProcStat(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if current, err := readProcStat(pid); err != nil || current.startTime != st.startTime {
+			return
+		}
+	}
+	t.Fatalf("expected the orphaned process %d to be reaped", pid)
+}
@@ -0,0 +1,101 @@
+// +build linux,cgo
+
+package native
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// stdioFile records how criu identifies the stdio of the checkpointed
+// process which was provided by the daemon, keyed by fd. These are replaced
+// by the streams of the restoring daemon so that attaching works after
+// restore.
+const stdioFile = "pipesfd.json"
+
+// isPtySlave returns true if rdev is a unix98 pty slave, as allocated for
+// the console of TTY containers.
+func isPtySlave(rdev uint64) bool {
+	major := (rdev >> 8) & 0xfff
+	return major >= 136 && major <= 143
+}
+
+// stdioExternal returns the criu identifier of the file behind fd of the
+// process if it's a pipe or a terminal set up by the daemon, or "" otherwise.
+func stdioExternal(pid, fd int) (string, error) {
+	fdPath := fmt.Sprintf("/proc/%d/fd/%d", pid, fd)
+	path, err := os.Readlink(fdPath)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(path, "pipe:") {
+		return path, nil
+	}
+	var st syscall.Stat_t
+	if err := syscall.Stat(fdPath, &st); err != nil {
+		return "", err
+	}
+	if st.Mode&syscall.S_IFMT == syscall.S_IFCHR && isPtySlave(st.Rdev) {
+		return fmt.Sprintf("tty[%x:%x]", st.Rdev, st.Dev), nil
+	}
+	return "", nil
+}
+
+// checkpointStdio records the stdio of the process into the checkpoint
+// and returns the arguments declaring the terminal, if any, as external
+// to criu dump.
+func checkpointStdio(imagePath string, pid int) ([]string, error) {
+	var args []string
+	stdio := make(map[string]string)
+	for i := 0; i < 3; i++ {
+		external, err := stdioExternal(pid, i)
+		if err != nil {
+			return nil, err
+		}
+		if external == "" {
+			continue
+		}
+		stdio[fmt.Sprintf("%d", i)] = external
+		if strings.HasPrefix(external, "tty[") {
+			args = append(args, "--external", external)
+		}
+	}
+	data, err := json.Marshal(stdio)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(imagePath, stdioFile), data, 0644); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+// readCheckpointStdio reads the stdio recorded by checkpointStdio, and
+// returns the arguments to have criu restore replace them with its own
+// stdio and whether the process had a terminal.
+func readCheckpointStdio(imagePath string) ([]string, bool, error) {
+	data, err := ioutil.ReadFile(filepath.Join(imagePath, stdioFile))
+	if err != nil {
+		return nil, false, err
+	}
+	var stdio map[string]string
+	if err := json.Unmarshal(data, &stdio); err != nil {
+		return nil, false, err
+	}
+	var (
+		args []string
+		tty  bool
+	)
+	for fd, external := range stdio {
+		args = append(args, "--inherit-fd", fmt.Sprintf("fd[%s]:%s", fd, external))
+		if strings.HasPrefix(external, "tty[") {
+			tty = true
+		}
+	}
+	return args, tty, nil
+}
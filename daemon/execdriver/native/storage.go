@@ -0,0 +1,49 @@
+// +build linux,cgo
+
+package native
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/docker/docker/pkg/mount"
+)
+
+// storageDriverDumpArgs returns extra criu dump arguments required to
+// checkpoint a container whose rootfs is provided by the given graph driver.
+func storageDriverDumpArgs(driver string) []string {
+	switch driver {
+	case "overlay":
+		// Overlayfs doesn't support file handles, so files which are
+		// opened but unlinked inside the container can't be dumped as
+		// ghost files by handle. Let criu link-remap them into the upper
+		// layer instead, which is carried over with the committed image.
+		// The layers themselves need no declaration since criu sees only
+		// the merged directory as the root mount, and that is rebuilt on
+		// restore by mounting the restored container's rootfs.
+		return []string{"--link-remap"}
+	}
+	return nil
+}
+
+// checkStorageDriverRootfs verifies that the rootfs of a container provided
+// by the given graph driver is ready to be restored into.
+func checkStorageDriverRootfs(driver, rootfs string) error {
+	switch driver {
+	case "devicemapper":
+		// The rootfs is a directory on the filesystem of the container's
+		// thin device, which is mounted at its parent. criu restores the
+		// root mount by binding whatever is there, so make sure the thin
+		// device has been activated and mounted instead of restoring onto
+		// the bare mountpoint.
+		mountpoint := filepath.Dir(rootfs)
+		mounted, err := mount.Mounted(mountpoint)
+		if err != nil {
+			return err
+		}
+		if !mounted {
+			return fmt.Errorf("thin device of the rootfs %s is not mounted at %s", rootfs, mountpoint)
+		}
+	}
+	return nil
+}
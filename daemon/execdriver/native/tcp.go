@@ -0,0 +1,31 @@
+// +build linux,cgo
+
+package native
+
+import (
+	"fmt"
+
+	"github.com/docker/docker/pkg/parsers/kernel"
+)
+
+// The kernel which introduced the TCP repair mode, in which criu reads and
+// sets the sequence numbers and queues of established connections.
+var minTcpRepairKernel = &kernel.KernelVersionInfo{Kernel: 3, Major: 5}
+
+// checkTcpRepair verifies that the kernel supports TCP repair mode, without
+// which criu fails to dump or restore established connections with an
+// error about setting a socket option.
+func checkTcpRepair() error {
+	kv, err := kernel.GetKernelVersion()
+	if err != nil {
+		return err
+	}
+	if !tcpRepairSupported(kv) {
+		return fmt.Errorf("TCP repair mode requires linux >= %d.%d, the kernel is %s", minTcpRepairKernel.Kernel, minTcpRepairKernel.Major, kv)
+	}
+	return nil
+}
+
+func tcpRepairSupported(kv *kernel.KernelVersionInfo) bool {
+	return kernel.CompareKernelVersion(kv, minTcpRepairKernel) >= 0
+}
@@ -0,0 +1,24 @@
+// +build linux,cgo
+
+package native
+
+import (
+	"testing"
+
+	"github.com/docker/docker/pkg/parsers/kernel"
+)
+
+func TestTcpRepairSupported(t *testing.T) {
+	for kv, supported := range map[kernel.KernelVersionInfo]bool{
+		{Kernel: 3, Major: 4, Minor: 99}:                 false,
+		{Kernel: 2, Major: 6, Minor: 32, Flavor: "-431"}: false,
+		{Kernel: 3, Major: 5}:                            true,
+		{Kernel: 3, Major: 10, Minor: 0, Flavor: "-el7"}: true,
+		{Kernel: 4, Major: 0}:                            true,
+	} {
+		kv := kv
+		if tcpRepairSupported(&kv) != supported {
+			t.Fatalf("expected TCP repair mode to be supported on %s: %v", &kv, supported)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// +build linux,cgo
+
+package native
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// tmpfsMounts returns the mountpoints of the tmpfs mounted in the mount
+// namespace of pid, relative to its root. Unlike the bind mounts declared
+// external, criu dumps the contents of these into the checkpoint, e.g. the
+// ones of /dev/shm, and mounts them afresh on restore.
+func tmpfsMounts(pid int) ([]string, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/mountinfo", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mountpoints []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// ID PARENT MAJ:MIN ROOT MOUNTPOINT OPTIONS [OPTIONAL...] - FSTYPE SOURCE SUPEROPTIONS
+		fields := strings.Fields(s.Text())
+		for i, field := range fields {
+			if field != "-" {
+				continue
+			}
+			if len(fields) > i+1 && len(fields) > 4 && fields[i+1] == "tmpfs" {
+				mountpoints = append(mountpoints, fields[4])
+			}
+			break
+		}
+	}
+	return mountpoints, s.Err()
+}
+
+// checkTmpfsDump verifies that the tmpfs mounted in the container can be
+// dumped. criu archives their contents with tar and fails obscurely if
+// it can't be run.
+func checkTmpfsDump(pid int) error {
+	mountpoints, err := tmpfsMounts(pid)
+	if err != nil {
+		return err
+	}
+	if len(mountpoints) == 0 {
+		return nil
+	}
+	if _, err := exec.LookPath("tar"); err != nil {
+		return fmt.Errorf("tar is required to checkpoint the contents of tmpfs mounted at %s: %s", strings.Join(mountpoints, ", "), err)
+	}
+	return nil
+}
+
+// checkTmpfsRestore verifies that the contents of the tmpfs dumped into the
+// checkpoint can be restored.
+func checkTmpfsRestore(imagePath string) error {
+	archives, err := filepath.Glob(filepath.Join(imagePath, "tmpfs-dev-*.tar.gz.img"))
+	if err != nil {
+		return err
+	}
+	if len(archives) == 0 {
+		return nil
+	}
+	if _, err := exec.LookPath("tar"); err != nil {
+		return fmt.Errorf("tar is required to restore the contents of tmpfs in the checkpoint: %s", err)
+	}
+	return nil
+}
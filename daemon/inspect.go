@@ -44,6 +44,7 @@ func (daemon *Daemon) ContainerInspect(job *engine.Job) engine.Status {
 		out.SetInt("RestartCount", container.RestartCount)
 		out.Set("Driver", container.Driver)
 		out.Set("ExecDriver", container.ExecDriver)
+		out.Set("CriuVersion", daemon.execDriver.Info(container.ID).CriuVersion())
 		out.Set("MountLabel", container.MountLabel)
 		out.Set("ProcessLabel", container.ProcessLabel)
 		out.SetJson("Volumes", container.Volumes)
@@ -60,18 +61,7 @@ func (daemon *Daemon) ContainerInspect(job *engine.Job) engine.Status {
 
 		out.SetJson("HostConfig", container.hostConfig)
 
-		checkpoints := make([]*ContainerCheckpoint, 0, len(container.Checkpoints))
-		// Make checkpoint list with ordering by creation time
-		for _, checkpoint := range container.Checkpoints {
-			checkpoints = append(checkpoints, checkpoint)
-			for i := len(checkpoints)-1; i > 0; i-- {
-				if checkpoints[i-1].CreatedAt.Before(checkpoint.CreatedAt) {
-					break
-				}
-				checkpoints[i], checkpoints[i-1] = checkpoints[i-1], checkpoint
-			}
-		}
-		out.SetJson("Checkpoints", checkpoints)
+		out.SetJson("Checkpoints", container.checkpointStatuses())
 
 		container.hostConfig.Links = nil
 		if _, err := out.WriteTo(job.Stdout); err != nil {
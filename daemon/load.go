@@ -1,6 +1,10 @@
 package daemon
 
 import (
+	"path/filepath"
+	"runtime"
+	"strings"
+
 	"github.com/docker/docker/engine"
 )
 
@@ -10,18 +14,51 @@ func (daemon *Daemon) ContainerLoad(job *engine.Job) engine.Status {
 	}
 	id := job.Args[0]
 
+	img, err := daemon.graph.Get(job.Args[1])
+	if err != nil {
+		return job.Errorf("Cannot load container %s: no such image %s: %s", id, job.Args[1], err)
+	}
+	// Checkpoint images contain architecture dependent process state, so
+	// the rootfs committed along with it is useless on the other arch.
+	if img.Architecture != "" && img.Architecture != runtime.GOARCH {
+		return job.Errorf("Cannot load container %s: image %s is for %s, not %s", id, img.ID, img.Architecture, runtime.GOARCH)
+	}
+
+	volumeMap := job.GetenvList("volumeMap")
+	pathMap := make(map[string]string, len(volumeMap))
+	for _, spec := range volumeMap {
+		arr := strings.SplitN(spec, ":", 2)
+		if len(arr) != 2 || !filepath.IsAbs(arr[0]) || !filepath.IsAbs(arr[1]) {
+			return job.Errorf("Invalid volume path mapping: %s", spec)
+		}
+		pathMap[arr[0]] = arr[1]
+	}
+
 	if err := daemon.restoreSingleContainer(id); err != nil {
 		return job.Error(err)
 	}
 
 	container := daemon.Get(id)
-	container.ImageID = job.Args[1]
+	rollbackVolumes := func() {}
+	if len(pathMap) > 0 {
+		rollbackVolumes = container.rebaseVolumes(pathMap)
+	}
+
+	prevImageID, prevConfigImage := container.ImageID, container.Config.Image
+	container.ImageID = img.ID
 	// We also have to rebase the image id in configuration
 	// to prevent depending non-existing image when migrated.
-	container.Config.Image = job.Args[1]
+	container.Config.Image = img.ID
 
 	if err := daemon.createRootfs(container); err != nil {
-		return job.Error(err)
+		// Roll back the rebase so the container keeps pointing at the
+		// image and volumes it was checkpointed with, and unregister it
+		// so that the load can be retried.
+		container.ImageID, container.Config.Image = prevImageID, prevConfigImage
+		rollbackVolumes()
+		daemon.driver.Remove(container.ID + "-init")
+		daemon.unregister(container)
+		return job.Errorf("Cannot create rootfs of container %s from image %s: %s", id, img.ID, err)
 	}
 	return engine.StatusOK
 }
@@ -0,0 +1,162 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/daemon/graphdriver"
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/graph"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/graphdb"
+	"github.com/docker/docker/pkg/truncindex"
+	"github.com/docker/docker/runconfig"
+	"github.com/docker/docker/volumes"
+)
+
+// dirDriver is a graph driver whose layers are empty directories, which is
+// enough to set up a container's rootfs without copying the image.
+type dirDriver struct {
+	home string
+}
+
+func (d *dirDriver) String() string {
+	return "dir"
+}
+
+func (d *dirDriver) Create(id, parent string) error {
+	if err := os.MkdirAll(d.home, 0700); err != nil {
+		return err
+	}
+	return os.Mkdir(filepath.Join(d.home, id), 0755)
+}
+
+func (d *dirDriver) Remove(id string) error {
+	return os.RemoveAll(filepath.Join(d.home, id))
+}
+
+func (d *dirDriver) Get(id, mountLabel string) (string, error) {
+	dir := filepath.Join(d.home, id)
+	if _, err := os.Stat(dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+func (d *dirDriver) Put(id string) error {
+	return nil
+}
+
+func (d *dirDriver) Exists(id string) bool {
+	_, err := os.Stat(filepath.Join(d.home, id))
+	return err == nil
+}
+
+func (d *dirDriver) Status() [][2]string {
+	return nil
+}
+
+func (d *dirDriver) Cleanup() error {
+	return nil
+}
+
+func TestContainerLoadRetry(t *testing.T) {
+	const (
+		containerID = "1a2d3c4d4e5fa2d2a21acea242a5e2345d3aefc3e7dfa2a2a2a21a2a2ad2d234"
+		imageID     = "5bc255f8699e4ee89ac4469266c3d11515da88fdcbde45d7b069b636ff4efd81"
+	)
+	root, err := ioutil.TempDir("", "docker-load-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	driver := graphdriver.NaiveDiffDriver(&dirDriver{home: filepath.Join(root, "dir")})
+	g, err := graph.NewGraph(filepath.Join(root, "graph"), driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Register(&image.Image{ID: imageID}, nil); err != nil {
+		t.Fatal(err)
+	}
+	containerGraph, err := graphdb.NewSqliteConn(filepath.Join(root, "linkgraph.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vols, err := volumes.NewRepository(filepath.Join(root, "volumes"), driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon := &Daemon{
+		repository:     filepath.Join(root, "containers"),
+		containers:     &contStore{s: make(map[string]*Container)},
+		graph:          g,
+		idIndex:        truncindex.NewTruncIndex([]string{}),
+		volumes:        vols,
+		config:         &Config{},
+		containerGraph: containerGraph,
+		driver:         driver,
+	}
+
+	// As received from the daemon it was migrated from
+	received := &Container{
+		ID:         containerID,
+		root:       daemon.containerRoot(containerID),
+		Config:     &runconfig.Config{Image: "checkpointed"},
+		hostConfig: &runconfig.HostConfig{},
+		State:      NewState(),
+		Driver:     driver.String(),
+		ImageID:    "checkpointed",
+	}
+	if err := os.MkdirAll(received.root, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := received.ToDisk(); err != nil {
+		t.Fatal(err)
+	}
+
+	eng := engine.New()
+	eng.Register("container_load", daemon.ContainerLoad)
+
+	job := eng.Job("container_load", containerID, imageID)
+	job.SetenvList("volumeMap", []string{"/var/lib/docker"})
+	if err := job.Run(); err == nil {
+		t.Fatal("expected the load to fail on the invalid volume path mapping")
+	}
+	if daemon.Get(containerID) != nil {
+		t.Fatal("expected the container not to be registered with an invalid volume path mapping")
+	}
+
+	// A leftover rootfs fails the load after the container is registered
+	if err := driver.Create(containerID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.Job("container_load", containerID, imageID).Run(); err == nil {
+		t.Fatal("expected the load to fail on the leftover rootfs")
+	}
+	if daemon.Get(containerID) != nil {
+		t.Fatal("expected the container to be unregistered after the failed load")
+	}
+	if driver.Exists(containerID + "-init") {
+		t.Fatal("expected the init layer to be removed after the failed load")
+	}
+
+	if err := driver.Remove(containerID); err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.Job("container_load", containerID, imageID).Run(); err != nil {
+		t.Fatalf("expected the load to be retried, got %s", err)
+	}
+	container := daemon.Get(containerID)
+	if container == nil {
+		t.Fatal("expected the container to be registered")
+	}
+	if container.ImageID != imageID || container.Config.Image != imageID {
+		t.Fatalf("expected the container to be rebased on %s, got %s", imageID, container.ImageID)
+	}
+	if !driver.Exists(containerID) {
+		t.Fatal("expected the rootfs of the container to be created")
+	}
+}
@@ -0,0 +1,414 @@
+package daemon
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/api"
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/utils"
+)
+
+const (
+	// How long to keep trying to connect to the daemon a container is
+	// migrated to, which may have just been started to receive it.
+	remoteConnectTimeout       = 10 * time.Second
+	remoteConnectRetryInterval = 100 * time.Millisecond
+)
+
+// ContainerMigrate moves a running container to the daemon listening at
+// REMOTE (host:port). The container is checkpointed and stopped, its
+// image, configuration and checkpoint image are transferred, then it is
+// loaded and restored from the checkpoint there. It is removed from this
+// host once it runs on the remote one, and restored back here if the
+// migration fails at any step.
+//
+// Given a pageServerPort, the memory pages are streamed while dumping to a
+// page server the remote daemon starts on that port, instead of being
+// written to disk here and transferred afterwards, which takes most of the
+// time the container is down. They are not kept here though, so the
+// container can't be restored back if the migration fails after the dump.
+func (daemon *Daemon) ContainerMigrate(job *engine.Job) engine.Status {
+	if len(job.Args) != 2 || job.Args[1] == "" {
+		return job.Errorf("Usage: %s CONTAINER REMOTE", job.Name)
+	}
+	name, remoteAddr := job.Args[0], job.Args[1]
+	startedAt := time.Now()
+
+	container := daemon.Get(name)
+	if container == nil {
+		return job.Errorf("No such container: %s", name)
+	}
+	if !container.IsRunning() {
+		return job.Errorf("Cannot migrate container %s: it is not running", name)
+	}
+
+	remote := newRemoteDaemon(remoteAddr)
+	checkpointJob := job.Eng.Job("checkpoint", container.ID, "1")
+	checkpointJob.Setenv("description", "migration to "+remoteAddr)
+	streamed := job.EnvExists("pageServerPort")
+	if streamed {
+		port := job.GetenvInt("pageServerPort")
+		host, _, err := net.SplitHostPort(remoteAddr)
+		if err != nil {
+			return job.Errorf("Invalid remote address %s: %s", remoteAddr, err)
+		}
+		checkpointID, err := remote.receiveCheckpoint(container.ID, port)
+		if err != nil {
+			return job.Errorf("Cannot migrate container %s: failed to start page server on %s: %s", name, remoteAddr, err)
+		}
+		checkpointJob.Setenv("id", checkpointID)
+		checkpointJob.Setenv("pageServer", net.JoinHostPort(host, strconv.Itoa(port)))
+	}
+	checkpointOut, err := checkpointJob.Stdout.AddEnv()
+	if err != nil {
+		return job.Error(err)
+	}
+	if err := checkpointJob.Run(); err != nil {
+		return job.Errorf("Cannot migrate container %s: %s", name, err)
+	}
+	checkpointID := checkpointOut.Get("Id")
+	checkpoint := container.Checkpoints[checkpointID]
+	if checkpoint == nil {
+		return job.Errorf("Cannot migrate container %s: checkpoint %s is gone", name, checkpointID)
+	}
+
+	restoredID, err := remote.migrate(job.Eng, checkpoint, streamed)
+	if err != nil {
+		if streamed {
+			return job.Errorf("Cannot migrate container %s to %s: %s; its memory pages were streamed there, it can't be restored here from checkpoint %s", name, remoteAddr, err, checkpointID)
+		}
+		// Bring the container back up here as if it had never been stopped
+		if err := job.Eng.Job("restore", container.ID, checkpointID).Run(); err != nil {
+			log.Errorf("Cannot restore container %s back from checkpoint %s: %s", container.ID, checkpointID, err)
+		}
+		return job.Errorf("Cannot migrate container %s to %s: %s", name, remoteAddr, err)
+	}
+	container.LogEvent("migrate")
+
+	rmJob := job.Eng.Job("rm", container.ID)
+	rmJob.SetenvBool("forceRemove", true)
+	if err := rmJob.Run(); err != nil {
+		log.Errorf("Cannot remove container %s migrated to %s: %s", container.ID, remoteAddr, err)
+	}
+
+	out := &engine.Env{}
+	out.Set("Id", restoredID)
+	out.Set("CheckpointId", checkpointID)
+	out.Set("Remote", remoteAddr)
+	out.SetInt64("DurationMs", int64(time.Since(startedAt)/time.Millisecond))
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// ContainerReceive unpacks a container migrated to this host from the
+// archive read from stdin, for it to be loaded and restored afterwards.
+func (daemon *Daemon) ContainerReceive(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s CONTAINER_ID", job.Name)
+	}
+	id := job.Args[0]
+	if err := utils.ValidateID(id); err != nil {
+		return job.Errorf("Invalid container ID %s", id)
+	}
+	root := daemon.containerRoot(id)
+	if daemon.Get(id) != nil {
+		return job.Errorf("Cannot receive container %s: it already exists", id)
+	}
+	// The root is already there when the memory pages of its checkpoint
+	// were streamed to this host, but not its configuration
+	if _, err := os.Stat(filepath.Join(root, "config.json")); err == nil {
+		return job.Errorf("Cannot receive container %s: %s already exists", id, root)
+	}
+
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return job.Error(err)
+	}
+	if err := daemon.unpackMigration(id, job.Stdin); err != nil {
+		os.RemoveAll(root)
+		os.RemoveAll(daemon.checkpointRoot(id))
+		return job.Errorf("Cannot receive container %s: %s", id, err)
+	}
+	return engine.StatusOK
+}
+
+func (daemon *Daemon) unpackMigration(id string, src io.Reader) error {
+	root := daemon.containerRoot(id)
+	if err := archive.Untar(src, root, nil); err != nil {
+		return err
+	}
+	checkpointRoot := daemon.checkpointRoot(id)
+	if checkpointRoot == root {
+		return nil
+	}
+	// Merged file by file, the memory pages of a checkpoint streamed to
+	// this host may already be there
+	from := filepath.Join(root, "checkpoints")
+	to := filepath.Join(checkpointRoot, "checkpoints")
+	if err := filepath.Walk(from, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return os.MkdirAll(filepath.Join(to, rel), fi.Mode().Perm())
+		}
+		return os.Rename(path, filepath.Join(to, rel))
+	}); err != nil {
+		return err
+	}
+	return os.RemoveAll(from)
+}
+
+// remoteDaemon talks to the API of the daemon a container is migrated to.
+type remoteDaemon struct {
+	addr   string
+	client *http.Client
+}
+
+func newRemoteDaemon(addr string) *remoteDaemon {
+	return &remoteDaemon{
+		addr:   addr,
+		client: &http.Client{Transport: &http.Transport{Dial: dialRemoteDaemon}},
+	}
+}
+
+// dialRemoteDaemon keeps trying to connect until remoteConnectTimeout, as
+// connect-bm does, rather than failing if the daemon isn't listening yet.
+func dialRemoteDaemon(network, addr string) (net.Conn, error) {
+	deadline := time.Now().Add(remoteConnectTimeout)
+	for {
+		conn, err := net.DialTimeout(network, addr, remoteConnectRetryInterval)
+		if err == nil || time.Now().After(deadline) {
+			return conn, err
+		}
+		time.Sleep(remoteConnectRetryInterval)
+	}
+}
+
+func (r *remoteDaemon) call(method, path string, query url.Values, body io.Reader) (*http.Response, error) {
+	u := fmt.Sprintf("http://%s/v%s%s", r.addr, api.APIVERSION, path)
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-tar")
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s %s: %s", method, path, bytes.TrimSpace(msg))
+	}
+	return resp, nil
+}
+
+func (r *remoteDaemon) post(path string, query url.Values, body io.Reader) error {
+	resp, err := r.call("POST", path, query, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// receiveCheckpoint has the remote daemon start a page server on port for
+// the memory pages of a checkpoint of the container, returning the ID of
+// the checkpoint to dump them under.
+func (r *remoteDaemon) receiveCheckpoint(id string, port int) (string, error) {
+	resp, err := r.call("POST", "/containers/"+id+"/checkpoints/receive", url.Values{"port": {strconv.Itoa(port)}}, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var received struct{ Id string }
+	if err := json.NewDecoder(resp.Body).Decode(&received); err != nil {
+		return "", fmt.Errorf("failed to decode received checkpoint: %s", err)
+	}
+	return received.Id, nil
+}
+
+// migrate transfers the container of cp to the remote daemon and restores
+// it there from cp, returning the ID of the restored container. The
+// container is removed from the remote daemon if it fails to be restored,
+// unless it holds the only copy of the memory pages streamed there.
+func (r *remoteDaemon) migrate(eng *engine.Engine, cp *ContainerCheckpoint, streamed bool) (string, error) {
+	id := cp.container.ID
+
+	imageArchive, imageWriter := io.Pipe()
+	go func() {
+		exportJob := eng.Job("image_export", cp.ImageID)
+		exportJob.Stdout.Add(imageWriter)
+		imageWriter.CloseWithError(exportJob.Run())
+	}()
+	err := r.post("/images/load", nil, imageArchive)
+	imageArchive.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to transfer image %s: %s", cp.ImageID, err)
+	}
+
+	containerArchive, containerWriter := io.Pipe()
+	go func() {
+		containerWriter.CloseWithError(cp.writeMigrationArchive(containerWriter))
+	}()
+	err = r.post("/containers/receive", url.Values{"id": {id}}, containerArchive)
+	containerArchive.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to transfer checkpoint %s: %s", cp.ID, err)
+	}
+
+	restoredID, err := r.loadAndRestore(id, cp)
+	if err != nil {
+		if streamed {
+			return "", err
+		}
+		if resp, err := r.call("DELETE", "/containers/"+id, url.Values{"force": {"1"}}, nil); err == nil {
+			resp.Body.Close()
+		} else {
+			log.Errorf("failed to remove container %s from %s: %s", id, r.addr, err)
+		}
+		return "", err
+	}
+	return restoredID, nil
+}
+
+func (r *remoteDaemon) loadAndRestore(id string, cp *ContainerCheckpoint) (string, error) {
+	if err := r.post("/containers/load", url.Values{"id": {id}, "new_image_id": {cp.ImageID}}, nil); err != nil {
+		return "", fmt.Errorf("failed to load container: %s", err)
+	}
+	resp, err := r.call("POST", fmt.Sprintf("/containers/%s/restore/%s", id, cp.ID), nil, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to restore container: %s", err)
+	}
+	defer resp.Body.Close()
+	var restored struct{ Id string }
+	if err := json.NewDecoder(resp.Body).Decode(&restored); err != nil {
+		return "", fmt.Errorf("failed to decode restored container: %s", err)
+	}
+	return restored.Id, nil
+}
+
+// writeMigrationArchive writes a tar archive of what the remote daemon needs
+// to load the container of cp and restore it: its configuration files, with
+// cp as its only checkpoint, and the image of cp under checkpoints/.
+func (cp *ContainerCheckpoint) writeMigrationArchive(w io.Writer) error {
+	container := cp.container
+	tw := tar.NewWriter(w)
+
+	config, err := cp.migrationConfig()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     "config.json",
+		Mode:     0600,
+		Size:     int64(len(config)),
+		ModTime:  time.Now(),
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(config); err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(container.root)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		// Other checkpoints are left behind, along with the rootfs which
+		// is created afresh from the image by ContainerLoad
+		if !fi.Mode().IsRegular() || fi.Name() == "config.json" {
+			continue
+		}
+		if err := addMigrationFile(tw, filepath.Join(container.root, fi.Name()), fi.Name(), fi); err != nil {
+			return err
+		}
+	}
+
+	imagePath := cp.imagePath()
+	if err := filepath.Walk(imagePath, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(imagePath, path)
+		if err != nil {
+			return err
+		}
+		return addMigrationFile(tw, path, filepath.Join("checkpoints", cp.ID, rel), fi)
+	}); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func addMigrationFile(tw *tar.Writer, path, name string, fi os.FileInfo) error {
+	hdr, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// migrationConfig returns the configuration of the container of cp as
+// saved in config.json, but listing only cp as its checkpoint, since the
+// images of the others aren't transferred.
+func (cp *ContainerCheckpoint) migrationConfig() ([]byte, error) {
+	container := cp.container
+	container.Lock()
+	data, err := json.Marshal(container)
+	container.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	var config map[string]*json.RawMessage
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	checkpoints, err := json.Marshal(map[string]*ContainerCheckpoint{cp.ID: cp})
+	if err != nil {
+		return nil, err
+	}
+	raw := json.RawMessage(checkpoints)
+	config["Checkpoints"] = &raw
+	return json.Marshal(config)
+}
@@ -124,6 +124,37 @@ func (container *Container) registerVolumes() {
 	}
 }
 
+// rebaseVolumes rewrites the host paths of the container's volumes and
+// bind mounts according to pathMap, which maps a host directory on the
+// source host to the directory it is found at on this host. It returns a
+// function to roll the rebase back with.
+func (container *Container) rebaseVolumes(pathMap map[string]string) (rollback func()) {
+	prevVolumes := make(map[string]string, len(container.Volumes))
+	for mountToPath, path := range container.Volumes {
+		prevVolumes[mountToPath] = path
+	}
+	prevBinds := append([]string(nil), container.hostConfig.Binds...)
+
+	container.derefVolumes()
+	for mountToPath, path := range container.Volumes {
+		container.Volumes[mountToPath] = volumes.RebasePath(path, pathMap)
+	}
+	for i, spec := range container.hostConfig.Binds {
+		arr := strings.SplitN(spec, ":", 2)
+		if len(arr) == 2 {
+			container.hostConfig.Binds[i] = volumes.RebasePath(arr[0], pathMap) + ":" + arr[1]
+		}
+	}
+	container.registerVolumes()
+
+	return func() {
+		container.derefVolumes()
+		container.Volumes = prevVolumes
+		container.hostConfig.Binds = prevBinds
+		container.registerVolumes()
+	}
+}
+
 func (container *Container) derefVolumes() {
 	for path := range container.VolumePaths() {
 		vol := container.daemon.volumes.Get(path)
@@ -273,11 +304,17 @@ func (container *Container) setupMounts() error {
 	// want this new mount in the container
 	// These mounts must be ordered based on the length of the path that it is being mounted to (lexicographic)
 	for _, path := range container.sortedVolumeMounts() {
-		mounts = append(mounts, execdriver.Mount{
+		m := execdriver.Mount{
 			Source:      container.Volumes[path],
 			Destination: path,
 			Writable:    container.VolumesRW[path],
-		})
+		}
+		// Volumes created by the daemon are only used by containers, so
+		// they can be relabeled to be shared among the ones using them.
+		if v := container.daemon.volumes.Get(m.Source); v != nil && !v.IsBindMount {
+			m.Relabel = "z"
+		}
+		mounts = append(mounts, m)
 	}
 
 	container.command.Mounts = mounts
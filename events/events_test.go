@@ -152,3 +152,32 @@ func TestEventsCountJob(t *testing.T) {
 		t.Fatalf("There must be 2 subscribers, got %d", count)
 	}
 }
+
+func TestLogEventsWithAttributes(t *testing.T) {
+	e := New()
+	eng := engine.New()
+	if err := e.Install(eng); err != nil {
+		t.Fatal(err)
+	}
+	l := make(chan *utils.JSONMessage)
+	e.subscribe(l)
+
+	job := eng.Job("log", "checkpoint", "cont", "image")
+	if err := job.SetenvJson("attributes", map[string]string{"checkpointId": "cp"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-l:
+		if msg.Status != "checkpoint" {
+			t.Fatalf("Status should be checkpoint, got %s", msg.Status)
+		}
+		if msg.Attributes["checkpointId"] != "cp" {
+			t.Fatalf("checkpointId attribute should be cp, got %s", msg.Attributes["checkpointId"])
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for broadcasted message")
+	}
+}
@@ -0,0 +1,731 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A process keeping a bind mounted log file open in append mode must keep
+// appending to it after being checkpointed and restored.
+func TestCheckpointRestoreAppendLogFile(t *testing.T) {
+	if _, err := exec.LookPath("criu"); err != nil {
+		t.Skip("criu is not installed, skip this test")
+	}
+	defer deleteAllContainers()
+
+	logDir, err := ioutil.TempDir("", "docker-checkpoint-log-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(logDir)
+	logFile := filepath.Join(logDir, "app.log")
+	if err := ioutil.WriteFile(logFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	runCmd := exec.Command(dockerBinary, "run", "-d", "-v", logFile+":/app.log", "busybox",
+		"sh", "-c", "exec 3>>/app.log; i=0; while true; do echo $i >&3; i=$((i+1)); sleep 0.1; done")
+	out, _, err := runCommandWithOutput(runCmd)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	containerID := stripTrailingCharacters(out)
+	time.Sleep(1 * time.Second)
+
+	out, _, err = dockerCmd(t, "checkpoint", "--stop", containerID)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	checkpointID := stripTrailingCharacters(out)
+	if out, _, err := dockerCmd(t, "inspect", "-f", "{{(index .Checkpoints 0).ID}}", containerID); err != nil || stripTrailingCharacters(out) != checkpointID {
+		t.Fatalf("expected the ID of checkpoint %s to be printed, got %s: %v", out, checkpointID, err)
+	}
+	beforeRestore := readFile(logFile, t)
+
+	out, _, err = dockerCmd(t, "restore", containerID, checkpointID)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	if err := waitRun(stripTrailingCharacters(out)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1 * time.Second)
+
+	afterRestore := readFile(logFile, t)
+	if len(afterRestore) <= len(beforeRestore) || !strings.HasPrefix(afterRestore, beforeRestore) {
+		t.Fatalf("restored process did not append to the log file, before: %q, after: %q", beforeRestore, afterRestore)
+	}
+	for i, line := range strings.Split(strings.TrimSpace(afterRestore), "\n") {
+		if line != strconv.Itoa(i) {
+			t.Fatalf("expected line %d of the log file to be %d, got %q", i, i, line)
+		}
+	}
+
+	logDone("checkpoint - append mode log file kept across checkpoint and restore")
+}
+
+func TestCheckpointRestoreTmpfs(t *testing.T) {
+	if _, err := exec.LookPath("criu"); err != nil {
+		t.Skip("criu is not installed, skip this test")
+	}
+	defer deleteAllContainers()
+
+	reportDir, err := ioutil.TempDir("", "docker-checkpoint-tmpfs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(reportDir)
+	reportFile := filepath.Join(reportDir, "report")
+	if err := ioutil.WriteFile(reportFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// /dev/shm is a tmpfs mounted in the container, the contents of which
+	// only survive the restore if dumped into the checkpoint.
+	runCmd := exec.Command(dockerBinary, "run", "-d", "-v", reportFile+":/report", "busybox",
+		"sh", "-c", "echo kept in memory > /dev/shm/data; while true; do cat /dev/shm/data > /report 2>&1; sleep 0.1; done")
+	out, _, err := runCommandWithOutput(runCmd)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	containerID := stripTrailingCharacters(out)
+	time.Sleep(1 * time.Second)
+
+	if out, _, err := dockerCmd(t, "checkpoint", "--stop", containerID); err != nil {
+		t.Fatal(out, err)
+	}
+	out, _, err = dockerCmd(t, "inspect", "-f", "{{(index .Checkpoints 0).ID}}", containerID)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	checkpointID := stripTrailingCharacters(out)
+	if err := ioutil.WriteFile(reportFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, _, err = dockerCmd(t, "restore", containerID, checkpointID)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	if err := waitRun(stripTrailingCharacters(out)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1 * time.Second)
+
+	if report := readFile(reportFile, t); report != "kept in memory\n" {
+		t.Fatalf("expected the file written to the tmpfs to survive the restore, got %q", report)
+	}
+
+	logDone("checkpoint - tmpfs contents kept across checkpoint and restore")
+}
+
+func TestCheckpointRestoreFork(t *testing.T) {
+	if _, err := exec.LookPath("criu"); err != nil {
+		t.Skip("criu is not installed, skip this test")
+	}
+	defer deleteAllContainers()
+
+	out, _, err := dockerCmd(t, "run", "-d", "busybox", "sh", "-c", "while true; do sleep 0.1; done")
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	containerID := stripTrailingCharacters(out)
+
+	if out, _, err := dockerCmd(t, "checkpoint", containerID); err != nil {
+		t.Fatal(out, err)
+	}
+	out, _, err = dockerCmd(t, "inspect", "-f", "{{(index .Checkpoints 0).ID}}", containerID)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	checkpointID := stripTrailingCharacters(out)
+
+	out, _, err = dockerCmd(t, "restore", "--fork", "--name", "forked", containerID, checkpointID)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	forkID := stripTrailingCharacters(out)
+	if forkID == containerID {
+		t.Fatal("expected the fork to get a new ID")
+	}
+	if err := waitRun("forked"); err != nil {
+		t.Fatal(err)
+	}
+	out, _, err = dockerCmd(t, "inspect", "-f", "{{.Id}}", "forked")
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	if stripTrailingCharacters(out) != forkID {
+		t.Fatalf("expected the fork to be named forked, got %s", out)
+	}
+	if err := waitRun(containerID); err != nil {
+		t.Fatalf("expected the original container to keep running: %s", err)
+	}
+
+	logDone("checkpoint - restore a fork under a new ID and name")
+}
+
+func TestCheckpointVerify(t *testing.T) {
+	if _, err := exec.LookPath("criu"); err != nil {
+		t.Skip("criu is not installed, skip this test")
+	}
+	defer deleteAllContainers()
+
+	out, _, err := dockerCmd(t, "run", "-d", "busybox", "sh", "-c", "while true; do sleep 0.1; done")
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	containerID := stripTrailingCharacters(out)
+
+	if out, _, err := dockerCmd(t, "checkpoint", containerID); err != nil {
+		t.Fatal(out, err)
+	}
+	out, _, err = dockerCmd(t, "inspect", "-f", "{{(index .Checkpoints 0).ID}}", containerID)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	checkpointID := stripTrailingCharacters(out)
+
+	if out, _, err := dockerCmd(t, "restore", "--verify", containerID, checkpointID); err != nil {
+		t.Fatal(out, err)
+	}
+	out, _, err = dockerCmd(t, "ps", "-aq", "--no-trunc")
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	if ids := strings.Fields(out); len(ids) != 1 || ids[0] != containerID {
+		t.Fatalf("expected the container restored to verify the checkpoint to be removed, got %v", ids)
+	}
+
+	logDone("checkpoint - verify a checkpoint with a throwaway restore")
+}
+
+func TestCheckpointRestoreByName(t *testing.T) {
+	if _, err := exec.LookPath("criu"); err != nil {
+		t.Skip("criu is not installed, skip this test")
+	}
+	defer deleteAllContainers()
+
+	out, _, err := dockerCmd(t, "run", "-d", "busybox", "sh", "-c", "while true; do sleep 0.1; done")
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	containerID := stripTrailingCharacters(out)
+
+	if out, _, err := runCommandWithOutput(exec.Command(dockerBinary, "checkpoint", "--name", "not/valid", containerID)); err == nil {
+		t.Fatalf("expected checkpointing under an invalid name to fail, got %s", out)
+	}
+	if out, _, err := dockerCmd(t, "checkpoint", "--name", "nightly", containerID); err != nil {
+		t.Fatal(out, err)
+	}
+	out, _, err = dockerCmd(t, "inspect", "-f", "{{(index .Checkpoints 0).ID}}", containerID)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	if id := stripTrailingCharacters(out); id != "nightly" {
+		t.Fatalf("expected the checkpoint ID to be its name, got %s", id)
+	}
+	if out, _, err := runCommandWithOutput(exec.Command(dockerBinary, "checkpoint", "--name", "nightly", containerID)); err == nil {
+		t.Fatalf("expected checkpointing under a taken name to fail, got %s", out)
+	}
+	if out, _, err := dockerCmd(t, "stop", containerID); err != nil {
+		t.Fatal(out, err)
+	}
+
+	out, _, err = dockerCmd(t, "restore", containerID, "nightly")
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	if err := waitRun(stripTrailingCharacters(out)); err != nil {
+		t.Fatal(err)
+	}
+
+	logDone("checkpoint - restore a checkpoint by its name")
+}
+
+func TestCheckpointLeaveRunning(t *testing.T) {
+	if _, err := exec.LookPath("criu"); err != nil {
+		t.Skip("criu is not installed, skip this test")
+	}
+	defer deleteAllContainers()
+
+	out, _, err := dockerCmd(t, "run", "-d", "busybox", "sh", "-c", "while true; do sleep 0.1; done")
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	containerID := stripTrailingCharacters(out)
+	pid, err := inspectField(containerID, "State.Pid")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out, _, err := dockerCmd(t, "checkpoint", containerID); err != nil {
+		t.Fatal(out, err)
+	}
+	time.Sleep(1 * time.Second)
+
+	if running, err := inspectField(containerID, "State.Running"); err != nil || running != "true" {
+		t.Fatalf("expected the container to be left running, got %s: %v", running, err)
+	}
+	if newPid, err := inspectField(containerID, "State.Pid"); err != nil || newPid != pid {
+		t.Fatalf("expected the pid of the container to stay %s, got %s: %v", pid, newPid, err)
+	}
+	if _, err := os.Stat(filepath.Join("/proc", pid)); err != nil {
+		t.Fatalf("expected the processes of the container to be left running: %s", err)
+	}
+
+	eventsCmd := exec.Command(dockerBinary, "events", "--since=0", "--until="+strconv.FormatInt(time.Now().Unix(), 10))
+	out, _, err = runCommandWithOutput(eventsCmd)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	checkpointed := false
+	for _, event := range strings.Split(out, "\n") {
+		if strings.Contains(event, containerID) && strings.Contains(event, ") checkpoint ") {
+			checkpointed = true
+		}
+	}
+	if !checkpointed {
+		t.Fatalf("expected a checkpoint event for %s, got %s", containerID, out)
+	}
+
+	logDone("checkpoint - leave the container running")
+}
+
+// A TTY container must keep its console across checkpoint and restore, and
+// have it resized afterwards.
+func TestCheckpointRestoreTty(t *testing.T) {
+	if _, err := exec.LookPath("criu"); err != nil {
+		t.Skip("criu is not installed, skip this test")
+	}
+	defer deleteAllContainers()
+
+	out, _, err := dockerCmd(t, "run", "-dt", "busybox", "sh", "-c", "while true; do stty size; sleep 0.1; done")
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	containerID := stripTrailingCharacters(out)
+
+	if out, _, err := dockerCmd(t, "checkpoint", "--stop", containerID); err != nil {
+		t.Fatal(out, err)
+	}
+	out, _, err = dockerCmd(t, "inspect", "-f", "{{(index .Checkpoints 0).ID}}", containerID)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	checkpointID := stripTrailingCharacters(out)
+
+	out, _, err = dockerCmd(t, "restore", containerID, checkpointID)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	restoredID := stripTrailingCharacters(out)
+	if err := waitRun(restoredID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sockRequest("POST", "/containers/"+restoredID+"/resize?h=40&w=100", nil); err != nil {
+		t.Fatalf("failed to resize the console of the restored container: %v", err)
+	}
+	time.Sleep(1 * time.Second)
+
+	out, _, err = dockerCmd(t, "logs", restoredID)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	if !strings.Contains(out, "40 100") {
+		t.Fatalf("expected the restored process to see its resized console, got %q", out)
+	}
+
+	logDone("checkpoint - console of a TTY container kept across checkpoint and restore")
+}
+
+// A restored container killed for running out of memory must be reported as
+// such, although it was restored into the cgroups it was dumped from.
+func TestCheckpointRestoreOOMKilled(t *testing.T) {
+	if _, err := exec.LookPath("criu"); err != nil {
+		t.Skip("criu is not installed, skip this test")
+	}
+	defer deleteAllContainers()
+
+	triggerDir, err := ioutil.TempDir("", "docker-checkpoint-oom-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(triggerDir)
+
+	// Once restored, the process keeps doubling a string until killed
+	out, _, err := dockerCmd(t, "run", "-d", "-m", "32m", "-v", triggerDir+":/trigger", "busybox",
+		"sh", "-c", "while [ ! -e /trigger/go ]; do sleep 0.1; done; x=a; while true; do x=$x$x; done")
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	containerID := stripTrailingCharacters(out)
+
+	if out, _, err := dockerCmd(t, "checkpoint", "--stop", containerID); err != nil {
+		t.Fatal(out, err)
+	}
+	out, _, err = dockerCmd(t, "inspect", "-f", "{{(index .Checkpoints 0).ID}}", containerID)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	checkpointID := stripTrailingCharacters(out)
+
+	out, _, err = dockerCmd(t, "restore", containerID, checkpointID)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	restoredID := stripTrailingCharacters(out)
+	if err := waitRun(restoredID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(triggerDir, "go"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, _, err := dockerCmd(t, "wait", restoredID); err != nil {
+		t.Fatal(out, err)
+	}
+	if oomKilled, err := inspectField(restoredID, "State.OOMKilled"); err != nil || oomKilled != "true" {
+		t.Fatalf("expected the restored container to be reported OOM killed, got %s: %v", oomKilled, err)
+	}
+
+	logDone("checkpoint - OOM kill of a restored container reported")
+}
+
+func TestCheckpointMigrate(t *testing.T) {
+	if _, err := exec.LookPath("criu"); err != nil {
+		t.Skip("criu is not installed, skip this test")
+	}
+	defer deleteAllContainers()
+
+	d := NewDaemon(t)
+	if err := d.StartWithBusybox("--host", "tcp://127.0.0.1:4271"); err != nil {
+		t.Fatalf("Could not start daemon with busybox: %v", err)
+	}
+	defer d.Stop()
+
+	out, _, err := dockerCmd(t, "run", "-d", "busybox", "sh", "-c", "while true; do sleep 0.1; done")
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	containerID := stripTrailingCharacters(out)
+
+	if _, err := sockRequest("POST", "/containers/"+containerID+"/migrate?remote=127.0.0.1:4271", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if out, err := d.Cmd("inspect", "-f", "{{.State.Running}}", containerID); err != nil || stripTrailingCharacters(out) != "true" {
+		t.Fatalf("expected the container to run on the remote daemon, got %s: %v", out, err)
+	}
+	if out, _, err := runCommandWithOutput(exec.Command(dockerBinary, "inspect", containerID)); err == nil || !strings.Contains(out, "No such image or container") {
+		t.Fatalf("expected the migrated container to be removed, got %s: %v", out, err)
+	}
+
+	logDone("checkpoint - migrate a container to another daemon")
+}
+
+func TestCheckpointMigrateStreamedPages(t *testing.T) {
+	if _, err := exec.LookPath("criu"); err != nil {
+		t.Skip("criu is not installed, skip this test")
+	}
+	defer deleteAllContainers()
+
+	d := NewDaemon(t)
+	if err := d.StartWithBusybox("--host", "tcp://127.0.0.1:4271"); err != nil {
+		t.Fatalf("Could not start daemon with busybox: %v", err)
+	}
+	defer d.Stop()
+
+	out, _, err := dockerCmd(t, "run", "-d", "busybox", "sh", "-c", "while true; do sleep 0.1; done")
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	containerID := stripTrailingCharacters(out)
+
+	if _, err := sockRequest("POST", "/containers/"+containerID+"/migrate?remote=127.0.0.1:4271&page_server_port=4272", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if out, err := d.Cmd("inspect", "-f", "{{.State.Running}}", containerID); err != nil || stripTrailingCharacters(out) != "true" {
+		t.Fatalf("expected the container to run on the remote daemon, got %s: %v", out, err)
+	}
+	if out, _, err := runCommandWithOutput(exec.Command(dockerBinary, "inspect", containerID)); err == nil || !strings.Contains(out, "No such image or container") {
+		t.Fatalf("expected the migrated container to be removed, got %s: %v", out, err)
+	}
+
+	logDone("checkpoint - migrate a container streaming its memory pages to the other daemon")
+}
+
+func TestCheckpointMigrateFailureKeepsContainer(t *testing.T) {
+	if _, err := exec.LookPath("criu"); err != nil {
+		t.Skip("criu is not installed, skip this test")
+	}
+	defer deleteAllContainers()
+
+	out, _, err := dockerCmd(t, "run", "-d", "busybox", "sh", "-c", "while true; do sleep 0.1; done")
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	containerID := stripTrailingCharacters(out)
+
+	// Nothing listens there, the transfer fails once the container is stopped
+	if _, err := sockRequest("POST", "/containers/"+containerID+"/migrate?remote=127.0.0.1:1", nil); err == nil {
+		t.Fatal("expected migrating to an unreachable daemon to fail")
+	}
+	if err := waitRun(containerID); err != nil {
+		t.Fatalf("expected the container to be restored back: %s", err)
+	}
+
+	logDone("checkpoint - failed migration leaves the container running")
+}
+
+func TestCheckpointOnTopOfPreDump(t *testing.T) {
+	if _, err := exec.LookPath("criu"); err != nil {
+		t.Skip("criu is not installed, skip this test")
+	}
+	defer deleteAllContainers()
+
+	out, _, err := dockerCmd(t, "run", "-d", "busybox", "sh", "-c", "i=0; while true; do i=$((i+1)); echo $i; sleep 0.1; done")
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	containerID := stripTrailingCharacters(out)
+
+	if out, _, err := dockerCmd(t, "checkpoint", "--pre-dump", "--name", "pre", containerID); err != nil {
+		t.Fatal(out, err)
+	}
+	if running, err := inspectField(containerID, "State.Running"); err != nil || running != "true" {
+		t.Fatalf("expected the container to be left running after a pre-dump, got %s: %v", running, err)
+	}
+	if out, _, err := runCommandWithOutput(exec.Command(dockerBinary, "restore", containerID, "pre")); err == nil {
+		t.Fatalf("expected restoring a pre-dump to fail, got %s", out)
+	}
+
+	if out, _, err := dockerCmd(t, "checkpoint", "--stop", "--parent", "pre", "--name", "final", containerID); err != nil {
+		t.Fatal(out, err)
+	}
+	out, _, err = dockerCmd(t, "restore", containerID, "final")
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	if err := waitRun(stripTrailingCharacters(out)); err != nil {
+		t.Fatal(err)
+	}
+
+	logDone("checkpoint - restore a checkpoint taken on top of a pre-dump")
+}
+
+func TestCheckpointRemovedWithContainer(t *testing.T) {
+	if _, err := exec.LookPath("criu"); err != nil {
+		t.Skip("criu is not installed, skip this test")
+	}
+	defer deleteAllContainers()
+
+	out, _, err := dockerCmd(t, "run", "-d", "busybox", "top")
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	containerID := stripTrailingCharacters(out)
+
+	if out, _, err := dockerCmd(t, "checkpoint", "--name", "cp", containerID); err != nil {
+		t.Fatal(out, err)
+	}
+	checkpointsPath := filepath.Join(containerStoragePath, containerID, "checkpoints")
+	if _, err := os.Stat(filepath.Join(checkpointsPath, "cp")); err != nil {
+		t.Fatalf("expected the checkpoint image to be saved: %s", err)
+	}
+
+	if out, _, err := dockerCmd(t, "rm", "-f", containerID); err != nil {
+		t.Fatal(out, err)
+	}
+	if _, err := os.Stat(checkpointsPath); !os.IsNotExist(err) {
+		t.Fatalf("expected the checkpoint images to be removed along with the container, got %v", err)
+	}
+
+	logDone("checkpoint - checkpoint images are removed along with the container")
+}
+
+func TestCheckpointRestorePaused(t *testing.T) {
+	if _, err := exec.LookPath("criu"); err != nil {
+		t.Skip("criu is not installed, skip this test")
+	}
+	defer deleteAllContainers()
+
+	out, _, err := dockerCmd(t, "run", "-d", "busybox", "top")
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	containerID := stripTrailingCharacters(out)
+	if out, _, err := dockerCmd(t, "pause", containerID); err != nil {
+		t.Fatal(out, err)
+	}
+	defer dockerCmd(t, "unpause", containerID)
+
+	if out, _, err := dockerCmd(t, "checkpoint", "--name", "paused", containerID); err != nil {
+		t.Fatal(out, err)
+	}
+	if paused, err := inspectField(containerID, "State.Paused"); err != nil || paused != "true" {
+		t.Fatalf("expected the container to be left paused, got %s: %v", paused, err)
+	}
+	out, _, err = dockerCmd(t, "inspect", "-f", "{{(index .Checkpoints 0).Paused}}", containerID)
+	if err != nil || stripTrailingCharacters(out) != "true" {
+		t.Fatalf("expected the checkpoint to be recorded as paused, got %s: %v", out, err)
+	}
+
+	if out, _, err := dockerCmd(t, "restore", "--fork", "--name", "forked", containerID, "paused"); err != nil {
+		t.Fatal(out, err)
+	}
+	defer dockerCmd(t, "unpause", "forked")
+	if paused, err := inspectField("forked", "State.Paused"); err != nil || paused != "true" {
+		t.Fatalf("expected the restored container to be paused, got %s: %v", paused, err)
+	}
+
+	logDone("checkpoint - restore a paused container paused")
+}
+
+func TestCheckpointWithExecRunning(t *testing.T) {
+	if _, err := exec.LookPath("criu"); err != nil {
+		t.Skip("criu is not installed, skip this test")
+	}
+	defer deleteAllContainers()
+
+	out, _, err := dockerCmd(t, "run", "-d", "busybox", "top")
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	containerID := stripTrailingCharacters(out)
+	if out, _, err := dockerCmd(t, "exec", "-d", containerID, "sleep", "100"); err != nil {
+		t.Fatal(out, err)
+	}
+
+	out, _, err = runCommandWithOutput(exec.Command(dockerBinary, "checkpoint", containerID))
+	if err == nil {
+		t.Fatalf("expected checkpointing with an exec session running to fail, got %s", out)
+	}
+	if !strings.Contains(out, "exec sessions running") {
+		t.Fatalf("expected the error to tell about the exec sessions, got %s", out)
+	}
+
+	if out, _, err := dockerCmd(t, "checkpoint", "--force", containerID); err != nil {
+		t.Fatal(out, err)
+	}
+
+	logDone("checkpoint - refuse to checkpoint with exec sessions running unless forced")
+}
+
+func TestCheckpointClonesGetDistinctAddresses(t *testing.T) {
+	if _, err := exec.LookPath("criu"); err != nil {
+		t.Skip("criu is not installed, skip this test")
+	}
+	defer deleteAllContainers()
+
+	out, _, err := dockerCmd(t, "run", "-d", "busybox", "top")
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	containerID := stripTrailingCharacters(out)
+	if out, _, err := dockerCmd(t, "checkpoint", "--name", "cp", containerID); err != nil {
+		t.Fatal(out, err)
+	}
+
+	sourceIP, err := inspectField(containerID, "NetworkSettings.IPAddress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]string{sourceIP: containerID}
+	for i := 0; i < 2; i++ {
+		out, _, err := dockerCmd(t, "restore", "--clone", containerID, "cp")
+		if err != nil {
+			t.Fatal(out, err)
+		}
+		cloneID := stripTrailingCharacters(out)
+		ip, err := inspectField(cloneID, "NetworkSettings.IPAddress")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other, ok := seen[ip]; ok {
+			t.Fatalf("expected clone %s to get an address of its own, got %s of %s", cloneID, ip, other)
+		}
+		seen[ip] = cloneID
+	}
+
+	logDone("checkpoint - clones of a container get distinct addresses")
+}
+
+func TestCheckpointTcpEstablished(t *testing.T) {
+	if _, err := exec.LookPath("criu"); err != nil {
+		t.Skip("criu is not installed, skip this test")
+	}
+	defer deleteAllContainers()
+
+	out, _, err := dockerCmd(t, "run", "-d", "busybox", "nc", "-l", "-p", "8080")
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	serverID := stripTrailingCharacters(out)
+	serverIP, err := inspectField(serverID, "NetworkSettings.IPAddress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _, err = dockerCmd(t, "run", "-d", "busybox", "sh", "-c", "while true; do echo ping; sleep 1; done | nc "+serverIP+" 8080")
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	time.Sleep(time.Second)
+
+	if out, _, err := dockerCmd(t, "checkpoint", "--tcp-established", "--name", "cp", serverID); err != nil {
+		t.Fatal(out, err)
+	}
+	out, _, err = dockerCmd(t, "inspect", "-f", "{{(index .Checkpoints 0).TcpEstablished}}", serverID)
+	if err != nil || stripTrailingCharacters(out) != "true" {
+		t.Fatalf("expected the checkpoint to be recorded with its TCP connections, got %s: %v", out, err)
+	}
+
+	out, _, err = runCommandWithOutput(exec.Command(dockerBinary, "restore", "--clone", serverID, "cp"))
+	if err == nil {
+		t.Fatalf("expected cloning a checkpoint with established TCP connections to fail, got %s", out)
+	}
+	if !strings.Contains(out, "established TCP connections") {
+		t.Fatalf("expected the error to tell about the TCP connections, got %s", out)
+	}
+
+	logDone("checkpoint - checkpoint established TCP connections")
+}
+
+func TestCheckpointFileLocks(t *testing.T) {
+	if _, err := exec.LookPath("criu"); err != nil {
+		t.Skip("criu is not installed, skip this test")
+	}
+	defer deleteAllContainers()
+
+	out, _, err := dockerCmd(t, "run", "-d", "busybox", "flock", "/tmp/lock", "top")
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	containerID := stripTrailingCharacters(out)
+
+	out, _, err = runCommandWithOutput(exec.Command(dockerBinary, "checkpoint", "--file-locks=false", containerID))
+	if err == nil {
+		t.Fatalf("expected checkpointing a container holding a file lock without its locks to fail, got %s", out)
+	}
+
+	if out, _, err := dockerCmd(t, "checkpoint", "--name", "locked", containerID); err != nil {
+		t.Fatal(out, err)
+	}
+	out, _, err = dockerCmd(t, "inspect", "-f", "{{(index .Checkpoints 0).FileLocks}}", containerID)
+	if err != nil || stripTrailingCharacters(out) != "true" {
+		t.Fatalf("expected the checkpoint to be recorded with its file locks, got %s: %v", out, err)
+	}
+	if out, _, err := dockerCmd(t, "restore", "--clone", containerID, "locked"); err != nil {
+		t.Fatal(out, err)
+	}
+
+	logDone("checkpoint - checkpoint and restore file locks by default")
+}
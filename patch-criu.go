@@ -14,6 +14,7 @@ import (
 	"regexp"
 	"unsafe"
 	"strings"
+	"syscall"
 	criu_pb "./criu"
 	"github.com/golang/protobuf/proto"
 )
@@ -29,27 +30,83 @@ func init() {
 	}
 }
 
-func rewriteMacAddress(srcPath, destPath, mac string) error {
+// Magic numbers the images start with, see magic.h of criu. Images of
+// criu 1.5 and later have imgCommonMagic in front of theirs.
+const (
+	imgCommonMagic = 0x54564319
+	netdevMagic    = 0x57373951
+	cgroupMagic    = 0x59383330
+
+	// The ifaddr and route images are the raw output of `ip addr save`
+	// and `ip route save`, which starts with the magic of iproute2
+	ipAddrDumpMagic  = 0x47361222
+	ipRouteDumpMagic = 0x45311224
+)
+
+// readImageMagic reads the magic header of the image name from r and
+// returns it to be copied as is, or an error if the image is not of the
+// type magic is for.
+func readImageMagic(r io.Reader, name string, magic uint32) ([]byte, error) {
+	header := make([]byte, 4, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("can't read the magic of %s: %s", name, err)
+	}
+	if native.Uint32(header) == imgCommonMagic {
+		header = header[:8]
+		if _, err := io.ReadFull(r, header[4:]); err != nil {
+			return nil, fmt.Errorf("can't read the magic of %s: %s", name, err)
+		}
+	}
+	if found := native.Uint32(header[len(header)-4:]); found != magic {
+		return nil, fmt.Errorf("%s has magic %#x instead of %#x, it's not the expected image or its format has changed", name, found, magic)
+	}
+	return header, nil
+}
+
+// checkImageMagic checks the magic header of the image name read as data.
+func checkImageMagic(data []byte, name string, magic uint32) error {
+	_, err := readImageMagic(bytes.NewReader(data), name, magic)
+	return err
+}
+
+// defaultInterface is the interface specs not naming any apply to.
+const defaultInterface = "eth0"
+
+// parseInterfaceSpec splits the value of a spec qualified by the interface
+// it applies to, e.g. "eth1=10.0.0.5", defaulting to defaultInterface.
+func parseInterfaceSpec(value string) (string, string) {
+	if parts := strings.SplitN(value, "=", 2); len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return defaultInterface, value
+}
+
+// rewriteMacAddress rewrites the MAC addresses of the interfaces given by
+// name in macs.
+func rewriteMacAddress(srcPath, destPath string, macs map[string]string) error {
 	srcFp, err := os.Open(filepath.Join(srcPath, "netdev-8.img"))
 	if err != nil {
 		return err
 	}
 	defer srcFp.Close()
 
-	destFilePath := filepath.Join(destPath, "netdev-8.img")
-	os.Remove(destFilePath)
-	destFp, err := os.OpenFile(destFilePath, os.O_WRONLY|os.O_CREATE, 0644)
+	destFp, err := createLike(srcFp, filepath.Join(destPath, "netdev-8.img"))
 	if err != nil {
 		return err
 	}
 	defer destFp.Close()
 
 	// Copy magic header first
-	if _, err := io.CopyN(destFp, srcFp, 4); err != nil {
+	magic, err := readImageMagic(srcFp, "netdev-8.img", netdevMagic)
+	if err != nil {
+		return err
+	}
+	if _, err := destFp.Write(magic); err != nil {
 		return err
 	}
 
 	// Checkout each device
+	rewritten := make(map[string]bool)
 	for {
 		var (
 			size uint32
@@ -70,13 +127,13 @@ func rewriteMacAddress(srcPath, destPath, mac string) error {
 		if err := proto.Unmarshal(buf, &device); err != nil {
 			return err
 		}
-		if device.Name != nil && *device.Name == "eth0" {
-			// TODO only ipv4
-			macHex, err := hex.DecodeString(mac)
+		if mac, ok := macs[device.GetName()]; ok {
+			macHex, err := hex.DecodeString(strings.Replace(mac, ":", "", -1))
 			if err != nil {
 				return err
 			}
 			device.Address = macHex
+			rewritten[device.GetName()] = true
 		}
 		data, err := proto.Marshal(&device)
 		if err != nil {
@@ -91,33 +148,169 @@ func rewriteMacAddress(srcPath, destPath, mac string) error {
 			return err
 		}
 	}
+	for name := range macs {
+		if !rewritten[name] {
+			return fmt.Errorf("can't find network device %s", name)
+		}
+	}
 	return nil
 }
 
-func rewriteBytes(data, from, to []byte) int {
-	replaced := 0
+// netDeviceIndexes returns the indexes of the network devices of the
+// checkpoint by name, by which `ip addr showdump` refers to them.
+func netDeviceIndexes(srcPath string) (map[string]uint32, error) {
+	fp, err := os.Open(filepath.Join(srcPath, "netdev-8.img"))
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	// Skip magic header
+	if _, err := readImageMagic(fp, "netdev-8.img", netdevMagic); err != nil {
+		return nil, err
+	}
+
+	indexes := make(map[string]uint32)
 	for {
-		pos := bytes.Index(data, from)
-		if pos == -1 {
+		var (
+			size   uint32
+			device criu_pb.NetDeviceEntry
+		)
+		if err := binary.Read(fp, native, &size); err != nil {
+			if err == io.EOF {
+				break
+			} else {
+				return nil, err
+			}
+		}
+
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(fp, buf); err != nil {
+			return nil, err
+		}
+		if err := proto.Unmarshal(buf, &device); err != nil {
+			return nil, err
+		}
+		indexes[device.GetName()] = device.GetIfindex()
+	}
+	return indexes, nil
+}
+
+// Header of the addresses of an interface in `ip addr showdump`
+var addrDumpHeaderPattern = regexp.MustCompile(`(?m)^if([0-9]+):$`)
+
+// addrDumpOf returns the part of the output of `ip addr showdump` listing
+// the addresses of the interface of index ifindex.
+func addrDumpOf(dump []byte, ifindex uint32) []byte {
+	var addrs []byte
+	headers := addrDumpHeaderPattern.FindAllSubmatchIndex(dump, -1)
+	for i, header := range headers {
+		if string(dump[header[2]:header[3]]) != fmt.Sprint(ifindex) {
+			continue
+		}
+		end := len(dump)
+		if i+1 < len(headers) {
+			end = headers[i+1][0]
+		}
+		addrs = append(addrs, dump[header[1]:end]...)
+	}
+	return addrs
+}
+
+// rewriteBytes replaces every occurrence of from in data with to, in place,
+// returning how many it replaced. The images hold the lengths of what they
+// contain, so the replacement has to be as long as what it replaces.
+func rewriteBytes(data, from, to []byte) (int, error) {
+	if len(from) != len(to) {
+		return 0, fmt.Errorf("can't rewrite %d bytes with %d bytes in place", len(from), len(to))
+	}
+	replaced := 0
+	for pos := 0; ; {
+		i := bytes.Index(data[pos:], from)
+		if i == -1 {
 			break
 		}
-		copy(data[pos:pos+len(to)], to)
+		copy(data[pos+i:], to)
+		pos += i + len(to)
 		replaced++
 	}
-	return replaced
+	return replaced, nil
+}
+
+// spliceBytes returns a copy of data with every occurrence of from replaced
+// with to, which may differ in length, and how many it replaced. It's up to
+// the caller to fix the lengths recorded around them.
+func spliceBytes(data, from, to []byte) ([]byte, int) {
+	replaced := bytes.Count(data, from)
+	if replaced == 0 {
+		return data, 0
+	}
+	return bytes.Replace(data, from, to, -1), replaced
+}
+
+// replaceWrite writes data to destPath in place of the image at srcPath,
+// with the same mode and owner so that it matches the rest of the dump.
+func replaceWrite(srcPath, destPath string, data []byte) error {
+	fi, err := os.Stat(srcPath)
+	if err != nil {
+		return err
+	}
+	os.Remove(destPath)
+	if err := ioutil.WriteFile(destPath, data, fi.Mode().Perm()); err != nil {
+		return err
+	}
+	return copyModeAndOwner(destPath, fi)
+}
+
+// createLike creates the file at destPath to write a patched copy of srcFp
+// into, with the same mode and owner.
+func createLike(srcFp *os.File, destPath string) (*os.File, error) {
+	fi, err := srcFp.Stat()
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(destPath)
+	destFp, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE, fi.Mode().Perm())
+	if err != nil {
+		return nil, err
+	}
+	if err := copyModeAndOwner(destPath, fi); err != nil {
+		destFp.Close()
+		return nil, err
+	}
+	return destFp, nil
 }
 
-func replaceWrite(path string, data []byte) error {
-	os.Remove(path)
-	return ioutil.WriteFile(path, data, 0644)
+// copyModeAndOwner gives the file at path the mode and owner of fi, which
+// the umask and the user running patch-criu would otherwise decide.
+func copyModeAndOwner(path string, fi os.FileInfo) error {
+	if err := os.Chmod(path, fi.Mode().Perm()); err != nil {
+		return err
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return os.Lchown(path, int(st.Uid), int(st.Gid))
+	}
+	return nil
 }
 
-func rewriteIPAddress(srcPath, destPath, ip string) error {
+// Lines of `ip addr showdump` carrying the global address of an interface
+var (
+	inetPattern  = regexp.MustCompile(`    inet ((?:[0-9]{0,3}\.){3}[0-9]{0,3})/[0-9]+ (?:brd [0-9.]+ )?scope global`)
+	inet6Pattern = regexp.MustCompile(`    inet6 ([0-9a-fA-F:.]+)/[0-9]+ scope global`)
+)
+
+// rewriteIPAddress rewrites the global addresses of the interfaces given by
+// name in ips, each of which replaces the old address of its own family on
+// the interface. An interface may have an IPv4 address, an IPv6 one or both.
+func rewriteIPAddress(srcPath, destPath string, ips map[string][]string) error {
 	// TODO obviously incomplete implementation
 	data, err := ioutil.ReadFile(filepath.Join(srcPath, "ifaddr-8.img"))
 	if err != nil {
 		return err
 	}
+	if err := checkImageMagic(data, "ifaddr-8.img", ipAddrDumpMagic); err != nil {
+		return err
+	}
 
 	ipCmd := exec.Command("ip", "addr", "showdump")
 	ipCmd.Stdin = bytes.NewBuffer(data)
@@ -127,35 +320,107 @@ func rewriteIPAddress(srcPath, destPath, ip string) error {
 	}
 	// fmt.Println(string(dump))
 
-	found := regexp.MustCompile(`    inet ((?:[0-9]{0,3}\.){3}[0-9]{0,3})/[0-9]+ scope global eth0`).FindSubmatch(dump)
-	if found == nil {
-		return fmt.Errorf("can't find old inet address")
-	}
-
-	oldAddress := net.ParseIP(string(found[1])).To4()
-	// fmt.Println(oldAddress)
-
-	newAddress := net.ParseIP(ip)
-	if newAddress == nil {
-		return fmt.Errorf("can't parse %s as an IPv4 Address", ip)
+	routeData, err := ioutil.ReadFile(filepath.Join(srcPath, "route-8.img"))
+	if err != nil {
+		return err
 	}
-	newAddress = newAddress.To4()
-
-	if rewriteBytes(data, oldAddress, newAddress) < 1 {
-		return fmt.Errorf("can't find old address pos in ip addr dump")
+	if err := checkImageMagic(routeData, "route-8.img", ipRouteDumpMagic); err != nil {
+		return err
 	}
-	if err := replaceWrite(filepath.Join(destPath, "ifaddr-8.img"), data); err != nil {
+	// Dumped by criu versions which save IPv6 routes apart
+	route6Data, err := ioutil.ReadFile(filepath.Join(srcPath, "route6-9.img"))
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
+	if route6Data != nil {
+		if err := checkImageMagic(route6Data, "route6-9.img", ipRouteDumpMagic); err != nil {
+			return err
+		}
+	}
 
-	routeData, err := ioutil.ReadFile(filepath.Join(srcPath, "route-8.img"))
+	indexes, err := netDeviceIndexes(srcPath)
 	if err != nil {
 		return err
 	}
-	if rewriteBytes(routeData, oldAddress, newAddress) < 1 {
-		return fmt.Errorf("can't find old address pos in ip route dump")
+	for name, ifaceIPs := range ips {
+		if err := rewriteInterfaceIPAddress(data, routeData, route6Data, dump, indexes, name, ifaceIPs); err != nil {
+			return err
+		}
+	}
+
+	if err := replaceWrite(filepath.Join(srcPath, "ifaddr-8.img"), filepath.Join(destPath, "ifaddr-8.img"), data); err != nil {
+		return err
+	}
+	if route6Data != nil {
+		if err := replaceWrite(filepath.Join(srcPath, "route6-9.img"), filepath.Join(destPath, "route6-9.img"), route6Data); err != nil {
+			return err
+		}
 	}
-	return replaceWrite(filepath.Join(destPath, "route-8.img"), routeData)
+	return replaceWrite(filepath.Join(srcPath, "route-8.img"), filepath.Join(destPath, "route-8.img"), routeData)
+}
+
+// rewriteInterfaceIPAddress rewrites the old addresses of the interface
+// name found in dump to ips, in place in the ifaddr and route images.
+func rewriteInterfaceIPAddress(data, routeData, route6Data, dump []byte, indexes map[string]uint32, name string, ips []string) error {
+	ifindex, ok := indexes[name]
+	if !ok {
+		return fmt.Errorf("can't find network device %s", name)
+	}
+	dump = addrDumpOf(dump, ifindex)
+
+	for _, ip := range ips {
+		newAddress := net.ParseIP(ip)
+		if newAddress == nil {
+			return fmt.Errorf("can't parse %s as an IP address", ip)
+		}
+
+		if newAddress.To4() != nil {
+			found := inetPattern.FindSubmatch(dump)
+			if found == nil {
+				return fmt.Errorf("can't find old inet address of %s", name)
+			}
+			oldAddress := net.ParseIP(string(found[1])).To4()
+			newAddress = newAddress.To4()
+			if oldAddress.Equal(newAddress) {
+				continue
+			}
+			if replaced, err := rewriteBytes(data, oldAddress, newAddress); err != nil {
+				return err
+			} else if replaced < 1 {
+				return fmt.Errorf("can't find old address pos in ip addr dump")
+			}
+			if replaced, err := rewriteBytes(routeData, oldAddress, newAddress); err != nil {
+				return err
+			} else if replaced < 1 {
+				return fmt.Errorf("can't find old address pos in ip route dump")
+			}
+			continue
+		}
+
+		found := inet6Pattern.FindSubmatch(dump)
+		if found == nil {
+			return fmt.Errorf("can't find old inet6 address of %s", name)
+		}
+		oldAddress := net.ParseIP(string(found[1])).To16()
+		newAddress = newAddress.To16()
+		if oldAddress.Equal(newAddress) {
+			continue
+		}
+		if replaced, err := rewriteBytes(data, oldAddress, newAddress); err != nil {
+			return err
+		} else if replaced < 1 {
+			return fmt.Errorf("can't find old inet6 address pos in ip addr dump")
+		}
+		// Unlike IPv4 ones, the IPv6 routes of the prefix don't carry the
+		// source address, there may be nothing to rewrite in them.
+		if _, err := rewriteBytes(routeData, oldAddress, newAddress); err != nil {
+			return err
+		}
+		if _, err := rewriteBytes(route6Data, oldAddress, newAddress); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func rewriteCgroupDirEntry(dir *criu_pb.CgroupDirEntry, fromPattern, toPattern string) {
@@ -172,16 +437,18 @@ func rewriteCgroupPaths(srcPath, destPath, fromPattern, toPattern string) error
 	}
 	defer srcFp.Close()
 
-	destFilePath := filepath.Join(destPath, "cgroup.img")
-	os.Remove(destFilePath)
-	destFp, err := os.OpenFile(destFilePath, os.O_WRONLY|os.O_CREATE, 0644)
+	destFp, err := createLike(srcFp, filepath.Join(destPath, "cgroup.img"))
 	if err != nil {
 		return err
 	}
 	defer destFp.Close()
 
 	// Copy magic header first
-	if _, err := io.CopyN(destFp, srcFp, 4); err != nil {
+	magic, err := readImageMagic(srcFp, "cgroup.img", cgroupMagic)
+	if err != nil {
+		return err
+	}
+	if _, err := destFp.Write(magic); err != nil {
 		return err
 	}
 
@@ -235,20 +502,27 @@ func rewriteCgroupPaths(srcPath, destPath, fromPattern, toPattern string) error
 
 func main() {
 	if len(os.Args) < 3 {
-		fmt.Fprintf(os.Stderr, "Usage: %s SRC_DIR DEST_DIR ip=NEW_IPADDR mac=NEW_MACADDR cgroup=OLD_CGROUP_PATTERN:NEW_CGROUP_PATTERN\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s SRC_DIR DEST_DIR ip=[IFACE=]NEW_IPADDR [ip=[IFACE=]NEW_IPV6ADDR] mac=[IFACE=]NEW_MACADDR cgroup=OLD_CGROUP_PATTERN:NEW_CGROUP_PATTERN\n", os.Args[0])
 		os.Exit(1)
 	}
 
 	srcPath := os.Args[1]
 	destPath := os.Args[2]
-	var err error
+	var (
+		err  error
+		ips  = make(map[string][]string)
+		macs = make(map[string]string)
+	)
 	for _, spec := range os.Args[3:] {
 		kv := strings.SplitN(spec, "=", 2)
 		switch kv[0] {
 		case "ip":
-			err = rewriteIPAddress(srcPath, destPath, kv[1])
+			// the addresses of all the interfaces are rewritten at once below
+			iface, ip := parseInterfaceSpec(kv[1])
+			ips[iface] = append(ips[iface], ip)
 		case "mac":
-			err = rewriteMacAddress(srcPath, destPath, kv[1])
+			iface, mac := parseInterfaceSpec(kv[1])
+			macs[iface] = mac
 		case "cgroup":
 			oldAndNew := strings.SplitN(kv[1], ":", 2)
 			if len(oldAndNew) < 2 {
@@ -264,4 +538,16 @@ func main() {
 			os.Exit(1)
 		}
 	}
+	if len(ips) > 0 {
+		if err := rewriteIPAddress(srcPath, destPath, ips); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if len(macs) > 0 {
+		if err := rewriteMacAddress(srcPath, destPath, macs); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(1)
+		}
+	}
 }
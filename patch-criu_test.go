@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	criu_pb "./criu"
+)
+
+func TestRewriteCgroupDirEntry(t *testing.T) {
+	name := func(s string) *string { return &s }
+	dir := &criu_pb.CgroupDirEntry{
+		DirName: name("docker/0123abcd"),
+		Children: []*criu_pb.CgroupDirEntry{
+			{DirName: name("docker/0123abcd/child")},
+			{
+				DirName: name("docker/0123abcd/other"),
+				Children: []*criu_pb.CgroupDirEntry{
+					{DirName: name("docker/0123abcd/other/grandchild")},
+				},
+			},
+		},
+	}
+
+	rewriteCgroupDirEntry(dir, "0123abcd", "4567ef01")
+
+	for _, c := range []struct {
+		dir      *criu_pb.CgroupDirEntry
+		expected string
+	}{
+		{dir, "docker/4567ef01"},
+		{dir.Children[0], "docker/4567ef01/child"},
+		{dir.Children[1], "docker/4567ef01/other"},
+		{dir.Children[1].Children[0], "docker/4567ef01/other/grandchild"},
+	} {
+		if *c.dir.DirName != c.expected {
+			t.Fatalf("expected %s, got %s", c.expected, *c.dir.DirName)
+		}
+	}
+}
+
+func TestRewriteBytes(t *testing.T) {
+	data := []byte("10.0.0.2 via 10.0.0.2")
+	replaced, err := rewriteBytes(data, []byte("10.0.0.2"), []byte("10.0.0.9"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replaced != 2 || string(data) != "10.0.0.9 via 10.0.0.9" {
+		t.Fatalf("expected 2 addresses rewritten, got %d: %s", replaced, data)
+	}
+
+	if _, err := rewriteBytes(data, []byte("10.0.0.9"), []byte("10.0.0.10")); err == nil {
+		t.Fatal("expected rewriting with a longer replacement in place to fail")
+	}
+	if string(data) != "10.0.0.9 via 10.0.0.9" {
+		t.Fatalf("expected data to be left alone on failure, got %s", data)
+	}
+}
+
+func TestSpliceBytes(t *testing.T) {
+	data := []byte("host-a and host-a")
+	spliced, replaced := spliceBytes(data, []byte("host-a"), []byte("host-abc"))
+	if replaced != 2 || !bytes.Equal(spliced, []byte("host-abc and host-abc")) {
+		t.Fatalf("expected 2 occurrences spliced, got %d: %s", replaced, spliced)
+	}
+	if string(data) != "host-a and host-a" {
+		t.Fatalf("expected the original data to be left alone, got %s", data)
+	}
+}
+
+func TestReadImageMagic(t *testing.T) {
+	magic := func(values ...uint32) []byte {
+		buf := make([]byte, 4*len(values))
+		for i, v := range values {
+			native.PutUint32(buf[4*i:], v)
+		}
+		return buf
+	}
+
+	for _, header := range [][]byte{
+		magic(netdevMagic),
+		magic(imgCommonMagic, netdevMagic),
+	} {
+		data := append(header, "entries"...)
+		r := bytes.NewReader(data)
+		read, err := readImageMagic(r, "netdev-8.img", netdevMagic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(read, header) {
+			t.Fatalf("expected the magic header %x to be returned, got %x", header, read)
+		}
+		if r.Len() != len("entries") {
+			t.Fatalf("expected only the magic header to be read, %d bytes left", r.Len())
+		}
+	}
+
+	if _, err := readImageMagic(bytes.NewReader(magic(cgroupMagic)), "netdev-8.img", netdevMagic); err == nil {
+		t.Fatal("expected an image of another type to be refused")
+	}
+	if err := checkImageMagic([]byte{0x01}, "ifaddr-8.img", ipAddrDumpMagic); err == nil {
+		t.Fatal("expected a truncated image to be refused")
+	}
+}
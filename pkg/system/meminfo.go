@@ -9,6 +9,11 @@ type MemInfo struct {
 	// Amount of free memory.
 	MemFree int64
 
+	// Estimate of the memory available for starting new applications
+	// without swapping, including reclaimable caches. Only reported by
+	// kernels 3.14 and later.
+	MemAvailable int64
+
 	// Total amount of swap space available.
 	SwapTotal int64
 
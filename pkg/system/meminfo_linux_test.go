@@ -13,6 +13,7 @@ func TestMemInfo(t *testing.T) {
 	MemFree:       2 kB
 	SwapTotal:     3 kB
 	SwapFree:      4 kB
+	MemAvailable:  5 kB
 	Malformed1:
 	Malformed2:    1
 	Malformed3:    2 MB
@@ -34,4 +35,7 @@ func TestMemInfo(t *testing.T) {
 	if meminfo.SwapFree != 4*units.KiB {
 		t.Fatalf("Unexpected SwapFree: %d", meminfo.SwapFree)
 	}
+	if meminfo.MemAvailable != 5*units.KiB {
+		t.Fatalf("Unexpected MemAvailable: %d", meminfo.MemAvailable)
+	}
 }
@@ -99,26 +99,27 @@ type RestartPolicy struct {
 }
 
 type HostConfig struct {
-	Binds           []string
-	ContainerIDFile string
-	LxcConf         []utils.KeyValuePair
-	Privileged      bool
-	PortBindings    nat.PortMap
-	Links           []string
-	PublishAllPorts bool
-	Dns             []string
-	DnsSearch       []string
-	ExtraHosts      []string
-	VolumesFrom     []string
-	Devices         []DeviceMapping
-	NetworkMode     NetworkMode
-	IpcMode         IpcMode
-	PidMode         PidMode
-	CapAdd          []string
-	CapDrop         []string
-	RestartPolicy   RestartPolicy
-	SecurityOpt     []string
-	ReadonlyRootfs  bool
+	Binds            []string
+	ContainerIDFile  string
+	LxcConf          []utils.KeyValuePair
+	Privileged       bool
+	PortBindings     nat.PortMap
+	Links            []string
+	PublishAllPorts  bool
+	Dns              []string
+	DnsSearch        []string
+	ExtraHosts       []string
+	VolumesFrom      []string
+	Devices          []DeviceMapping
+	NetworkMode      NetworkMode
+	IpcMode          IpcMode
+	PidMode          PidMode
+	CapAdd           []string
+	CapDrop          []string
+	RestartPolicy    RestartPolicy
+	SecurityOpt      []string
+	ReadonlyRootfs   bool
+	DisableOomNotify bool
 }
 
 // This is used by the create command when you want to set both the
@@ -143,13 +144,14 @@ func ContainerHostConfigFromJob(job *engine.Job) *HostConfig {
 	}
 
 	hostConfig := &HostConfig{
-		ContainerIDFile: job.Getenv("ContainerIDFile"),
-		Privileged:      job.GetenvBool("Privileged"),
-		PublishAllPorts: job.GetenvBool("PublishAllPorts"),
-		NetworkMode:     NetworkMode(job.Getenv("NetworkMode")),
-		IpcMode:         IpcMode(job.Getenv("IpcMode")),
-		PidMode:         PidMode(job.Getenv("PidMode")),
-		ReadonlyRootfs:  job.GetenvBool("ReadonlyRootfs"),
+		ContainerIDFile:  job.Getenv("ContainerIDFile"),
+		Privileged:       job.GetenvBool("Privileged"),
+		PublishAllPorts:  job.GetenvBool("PublishAllPorts"),
+		NetworkMode:      NetworkMode(job.Getenv("NetworkMode")),
+		IpcMode:          IpcMode(job.Getenv("IpcMode")),
+		PidMode:          PidMode(job.Getenv("PidMode")),
+		ReadonlyRootfs:   job.GetenvBool("ReadonlyRootfs"),
+		DisableOomNotify: job.GetenvBool("DisableOomNotify"),
 	}
 
 	job.GetenvJson("LxcConf", &hostConfig.LxcConf)
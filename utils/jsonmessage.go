@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 	"time"
 
@@ -74,15 +75,16 @@ func (p *JSONProgress) String() string {
 }
 
 type JSONMessage struct {
-	Stream          string        `json:"stream,omitempty"`
-	Status          string        `json:"status,omitempty"`
-	Progress        *JSONProgress `json:"progressDetail,omitempty"`
-	ProgressMessage string        `json:"progress,omitempty"` //deprecated
-	ID              string        `json:"id,omitempty"`
-	From            string        `json:"from,omitempty"`
-	Time            int64         `json:"time,omitempty"`
-	Error           *JSONError    `json:"errorDetail,omitempty"`
-	ErrorMessage    string        `json:"error,omitempty"` //deprecated
+	Stream          string            `json:"stream,omitempty"`
+	Status          string            `json:"status,omitempty"`
+	Progress        *JSONProgress     `json:"progressDetail,omitempty"`
+	ProgressMessage string            `json:"progress,omitempty"` //deprecated
+	ID              string            `json:"id,omitempty"`
+	From            string            `json:"from,omitempty"`
+	Time            int64             `json:"time,omitempty"`
+	Error           *JSONError        `json:"errorDetail,omitempty"`
+	ErrorMessage    string            `json:"error,omitempty"` //deprecated
+	Attributes      map[string]string `json:"attributes,omitempty"`
 }
 
 func (jm *JSONMessage) Display(out io.Writer, isTerminal bool) error {
@@ -115,6 +117,13 @@ func (jm *JSONMessage) Display(out io.Writer, isTerminal bool) error {
 		fmt.Fprintf(out, "%s %s%s", jm.Status, jm.ProgressMessage, endl)
 	} else if jm.Stream != "" {
 		fmt.Fprintf(out, "%s%s", jm.Stream, endl)
+	} else if len(jm.Attributes) > 0 {
+		attrs := make([]string, 0, len(jm.Attributes))
+		for k, v := range jm.Attributes {
+			attrs = append(attrs, k+"="+v)
+		}
+		sort.Strings(attrs)
+		fmt.Fprintf(out, "%s (%s)%s\n", jm.Status, strings.Join(attrs, ", "), endl)
 	} else {
 		fmt.Fprintf(out, "%s%s\n", jm.Status, endl)
 	}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"sync"
 
 	"github.com/docker/docker/pkg/archive"
@@ -177,3 +178,21 @@ func (v *Volume) getResourcePath(path string) (string, error) {
 	cleanPath := filepath.Join("/", path)
 	return symlink.FollowSymlinkInScope(filepath.Join(v.Path, cleanPath), v.Path)
 }
+
+// RebasePath rebases path by the longest directory of pathMap it is
+// in, so that the result doesn't depend on the order of overlapping ones.
+func RebasePath(path string, pathMap map[string]string) string {
+	longest, rebased := "", path
+	for from, to := range pathMap {
+		from = filepath.Clean(from)
+		if len(from) <= len(longest) {
+			continue
+		}
+		if path == from {
+			longest, rebased = from, filepath.Clean(to)
+		} else if strings.HasPrefix(path, from+"/") {
+			longest, rebased = from, filepath.Join(to, strings.TrimPrefix(path, from))
+		}
+	}
+	return rebased
+}
@@ -0,0 +1,26 @@
+package volumes
+
+import (
+	"testing"
+)
+
+func TestRebasePath(t *testing.T) {
+	pathMap := map[string]string{
+		"/var/lib/docker/vfs/dir": "/data/docker/vfs/dir",
+		"/srv/":                   "/mnt/srv",
+		"/srv/www/static":         "/cdn/static",
+	}
+	for path, expected := range map[string]string{
+		"/var/lib/docker/vfs/dir/abcdef": "/data/docker/vfs/dir/abcdef",
+		"/var/lib/docker/vfs/dir":        "/data/docker/vfs/dir",
+		"/var/lib/docker/vfs/directory":  "/var/lib/docker/vfs/directory",
+		"/srv/www":                       "/mnt/srv/www",
+		"/srv/www/static/img":            "/cdn/static/img",
+		"/srv/www/statics":               "/mnt/srv/www/statics",
+		"/tmp/foo":                       "/tmp/foo",
+	} {
+		if rebased := RebasePath(path, pathMap); rebased != expected {
+			t.Fatalf("expected %s to be rebased to %s, got %s", path, expected, rebased)
+		}
+	}
+}